@@ -0,0 +1,274 @@
+package icapclient
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// BreakerState is the state of the circuit breaker of an ICAP service
+type BreakerState int
+
+// the states of a circuit breaker
+const (
+	BreakerClosed   BreakerState = iota // the calls are made as usual
+	BreakerOpen                         // the service is failing, the calls are not made at all
+	BreakerHalfOpen                     // the open timeout is over, a few trial calls are made to see if the service is back
+)
+
+// String returns the name of the state
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// FailurePolicy is what the client returns for a call the ICAP service fails or the circuit breaker doesn't let through
+type FailurePolicy int
+
+// the failure policies
+const (
+	FailClosed FailurePolicy = iota // an error, the content is to be blocked as it is not scanned
+	FailOpen                        // a 204 No Content response with NotScanned set, the content is to be allowed unscanned
+)
+
+// String returns the name of the policy
+func (p FailurePolicy) String() string {
+	if p == FailOpen {
+		return "fail-open"
+	}
+
+	return "fail-closed"
+}
+
+// CircuitBreaker stops the calls to an ICAP service failing over & over, so that they fail at once instead of waiting
+// for the timeouts. Every service, the url without the query, gets its own breaker state
+//
+// The breaker opens after FailureThreshold calls in a row fail for a transient reason, the ones RetryPolicy retries.
+// Once the OpenTimeout is over, it lets HalfOpenMaxCalls trial calls through, closing after SuccessThreshold of them
+// succeed or opening again as soon as one fails. The calls failing or not let through are returned as the Policy tells,
+// with the decision recorded in the Breaker of the response for the fail-open ones. The calls failing with an error
+// status, as 503, get a *ServiceError for it whether the StatusErrors of the client is set or not
+type CircuitBreaker struct {
+	FailureThreshold int           // the number of failures in a row to open the breaker after (default: 5)
+	OpenTimeout      time.Duration // how long the breaker stays open before letting the trial calls through (default: 30s)
+	HalfOpenMaxCalls int           // the number of trial calls let through at the same time when half-open (default: 1)
+	SuccessThreshold int           // the number of trial calls to succeed to close the breaker (default: 1)
+	Policy           FailurePolicy
+	mu               sync.Mutex
+	services         map[string]*serviceBreaker
+}
+
+// serviceBreaker is the breaker state of a service
+type serviceBreaker struct {
+	state     BreakerState
+	fails     int       // the number of failures in a row
+	successes int       // the number of trial calls succeeded when half-open
+	trials    int       // the number of trial calls in progress when half-open
+	openedAt  time.Time // when the breaker opened last
+}
+
+// BreakerDecision is what the circuit breaker decided for a call, as recorded with the response
+type BreakerDecision struct {
+	Service string        // the service the call is for
+	State   BreakerState  // the state of the breaker when the decision was made
+	Policy  FailurePolicy // the policy applied
+	Err     error         // why, ErrCircuitOpen if the call was not made, the error of the call otherwise
+}
+
+// State returns the state of the breaker of the service at the url
+func (b *CircuitBreaker) State(serviceURL string) BreakerState {
+
+	req, err := NewRequest(MethodOPTIONS, serviceURL, nil, nil)
+
+	if err != nil {
+		return BreakerClosed
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if sb := b.services[optionsKey(req)]; sb != nil {
+		return b.current(sb)
+	}
+
+	return BreakerClosed
+}
+
+// do makes the call with the breaker of the service of the request around it
+func (b *CircuitBreaker) do(req *Request, policy *RetryPolicy, call func(*Request) (*Response, error)) (*Response, error) {
+
+	service := optionsKey(req)
+
+	state, allowed := b.allow(service)
+
+	if !allowed {
+		logDebug("The circuit breaker is open, not calling the ICAP service: ", service)
+		return b.fail(&BreakerDecision{Service: service, State: state, Policy: b.Policy, Err: ErrCircuitOpen})
+	}
+
+	resp, err := call(req)
+
+	if callContext(req).Err() != nil { // the call is given up by the caller, it tells nothing about the service
+		b.record(service, state, nil)
+		return resp, err
+	}
+
+	failed := policy.retry(resp, err)
+	b.record(service, state, &failed)
+
+	if failed {
+		if err == nil { // an error status, the response is dropped for the one the policy tells
+			err = newServiceError(resp)
+			resp.closeBody()
+		}
+		return b.fail(&BreakerDecision{Service: service, State: state, Policy: b.Policy, Err: err})
+	}
+
+	return resp, err
+}
+
+// fail returns the result of a call failed or not let through as the policy tells
+func (b *CircuitBreaker) fail(decision *BreakerDecision) (*Response, error) {
+
+	if b.Policy == FailClosed {
+		if decision.Err == ErrCircuitOpen {
+			return nil, &BreakerError{Service: decision.Service, State: decision.State}
+		}
+		return nil, decision.Err
+	}
+
+	logDebug("Failing open for the ICAP service: ", decision.Service, ", ", decision.Err)
+
+	return &Response{
+		StatusCode: http.StatusNoContent,
+		Status:     http.StatusText(http.StatusNoContent),
+		Header:     http.Header{},
+		NotScanned: true,
+		Breaker:    decision,
+	}, nil
+}
+
+// allow tells if the call to the service is let through, along with the state of the breaker
+func (b *CircuitBreaker) allow(service string) (BreakerState, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.services == nil {
+		b.services = make(map[string]*serviceBreaker)
+	}
+
+	sb := b.services[service]
+	if sb == nil {
+		sb = &serviceBreaker{}
+		b.services[service] = sb
+	}
+
+	state := b.current(sb)
+
+	switch state {
+	case BreakerOpen:
+		return state, false
+	case BreakerHalfOpen:
+		if sb.state == BreakerOpen { // the open timeout is just over
+			sb.state = BreakerHalfOpen
+			sb.successes = 0
+			sb.trials = 0
+		}
+
+		maxCalls := b.HalfOpenMaxCalls
+		if maxCalls <= 0 {
+			maxCalls = 1
+		}
+
+		if sb.trials >= maxCalls {
+			return BreakerOpen, false
+		}
+
+		sb.trials++
+	}
+
+	return state, true
+}
+
+// record records the outcome of a call let through in the state, nil failed means the call tells nothing about the service
+func (b *CircuitBreaker) record(service string, state BreakerState, failed *bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sb := b.services[service]
+
+	if state == BreakerHalfOpen {
+		sb.trials--
+	}
+
+	if failed == nil || sb.state != state { // the state has changed meanwhile, by another call
+		return
+	}
+
+	if *failed {
+		sb.fails++
+
+		threshold := b.FailureThreshold
+		if threshold <= 0 {
+			threshold = defaultFailureThreshold
+		}
+
+		if state == BreakerHalfOpen || sb.fails >= threshold {
+			logDebug("Opening the circuit breaker of the ICAP service: ", service)
+			sb.state = BreakerOpen
+			sb.openedAt = time.Now()
+		}
+		return
+	}
+
+	sb.fails = 0
+
+	if state == BreakerHalfOpen {
+		sb.successes++
+
+		threshold := b.SuccessThreshold
+		if threshold <= 0 {
+			threshold = 1
+		}
+
+		if sb.successes >= threshold {
+			logDebug("Closing the circuit breaker of the ICAP service: ", service)
+			sb.state = BreakerClosed
+		}
+	}
+}
+
+// current returns the current state of the breaker, half-open if it is open & the open timeout is over, b must be locked
+func (b *CircuitBreaker) current(sb *serviceBreaker) BreakerState {
+
+	if sb.state != BreakerOpen {
+		return sb.state
+	}
+
+	timeout := b.OpenTimeout
+	if timeout <= 0 {
+		timeout = defaultOpenTimeout
+	}
+
+	if time.Since(sb.openedAt) >= timeout {
+		return BreakerHalfOpen
+	}
+
+	return BreakerOpen
+}
+
+// callContext returns the context of the call of the request
+func callContext(req *Request) context.Context {
+	if req.ctx == nil {
+		return context.Background()
+	}
+
+	return *req.ctx
+}
@@ -0,0 +1,205 @@
+package icapclient
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+
+	t.Run("CircuitBreaker states", func(t *testing.T) {
+
+		var down int32
+
+		srv := newFakeICAPServer(t, func(req *fakeICAPRequest) string {
+			if atomic.LoadInt32(&down) == 1 {
+				return ""
+			}
+			return noContentResponse("TAG")
+		})
+		defer srv.close()
+
+		type testStep struct {
+			down         bool
+			sleep        time.Duration
+			wantedCalled bool
+			wantedErr    bool
+			wantedOpen   bool // the error is a *BreakerError, or the response a fail-open one for the breaker open
+			wantedState  BreakerState
+		}
+
+		steps := []testStep{
+			{down: false, wantedCalled: true, wantedState: BreakerClosed},
+			{down: true, wantedCalled: true, wantedErr: true, wantedState: BreakerClosed},
+			{down: false, wantedCalled: true, wantedState: BreakerClosed}, // the failures must be in a row
+			{down: true, wantedCalled: true, wantedErr: true, wantedState: BreakerClosed},
+			{down: true, wantedCalled: true, wantedErr: true, wantedState: BreakerOpen},
+			{down: false, wantedCalled: false, wantedErr: true, wantedOpen: true, wantedState: BreakerOpen},
+			{down: true, sleep: 60 * time.Millisecond, wantedCalled: true, wantedErr: true, wantedState: BreakerOpen}, // the trial call fails
+			{down: false, wantedCalled: false, wantedErr: true, wantedOpen: true, wantedState: BreakerOpen},
+			{down: false, sleep: 60 * time.Millisecond, wantedCalled: true, wantedState: BreakerClosed}, // the trial call succeeds
+			{down: false, wantedCalled: true, wantedState: BreakerClosed},
+		}
+
+		for _, policy := range []FailurePolicy{FailClosed, FailOpen} {
+			breaker := &CircuitBreaker{
+				FailureThreshold: 2,
+				OpenTimeout:      50 * time.Millisecond,
+				Policy:           policy,
+			}
+
+			client := &Client{Breaker: breaker}
+
+			for i, step := range steps {
+				time.Sleep(step.sleep)
+
+				if step.down {
+					atomic.StoreInt32(&down, 1)
+					client.CloseIdleConnections()
+				} else {
+					atomic.StoreInt32(&down, 0)
+				}
+
+				httpResp := &http.Response{
+					Status:     "200 OK",
+					StatusCode: http.StatusOK,
+					Proto:      "HTTP/1.1",
+					ProtoMajor: 1,
+					ProtoMinor: 1,
+					Header:     http.Header{},
+					Body:       ioutil.NopCloser(strings.NewReader("Hello World")),
+				}
+
+				req, err := NewRequest(MethodRESPMOD, srv.url("/respmod?file=1"), nil, httpResp)
+				if err != nil {
+					t.Fatal(err.Error())
+				}
+
+				calls := len(srv.received(MethodRESPMOD))
+
+				resp, err := client.Do(req)
+
+				if called := len(srv.received(MethodRESPMOD)) > calls; called != step.wantedCalled {
+					t.Logf("%s step %d: wanted the service called:%v, got:%v", policy, i, step.wantedCalled, called)
+					t.Fail()
+				}
+
+				if policy == FailClosed {
+					var breakerErr *BreakerError
+					if (err != nil) != step.wantedErr || errors.As(err, &breakerErr) != step.wantedOpen ||
+						errors.Is(err, ErrCircuitOpen) != step.wantedOpen {
+						t.Logf("%s step %d: wanted an error:%v & the breaker open:%v, got:%v", policy, i, step.wantedErr, step.wantedOpen, err)
+						t.Fail()
+					}
+				} else {
+					switch {
+					case err != nil:
+						t.Logf("%s step %d: wanted no error, got:%v", policy, i, err)
+						t.Fail()
+					case step.wantedErr && (!resp.NotScanned || resp.Breaker == nil || resp.Breaker.Policy != FailOpen ||
+						(resp.Breaker.Err == ErrCircuitOpen) != step.wantedOpen):
+						t.Logf("%s step %d: wanted a fail-open response with the breaker open:%v, got:%+v", policy, i, step.wantedOpen, resp.Breaker)
+						t.Fail()
+					case !step.wantedErr && (resp.NotScanned || resp.Breaker != nil || resp.StatusCode != http.StatusNoContent):
+						t.Logf("%s step %d: wanted the response of the service, got a stand-in one", policy, i)
+						t.Fail()
+					}
+				}
+
+				if state := breaker.State(srv.url("/respmod")); state != step.wantedState {
+					t.Logf("%s step %d: wanted the breaker state:%s, got:%s", policy, i, step.wantedState, state)
+					t.Fail()
+				}
+			}
+
+			client.CloseIdleConnections()
+		}
+	})
+
+	t.Run("CircuitBreaker half-open trial calls", func(t *testing.T) {
+
+		breaker := &CircuitBreaker{FailureThreshold: 1, OpenTimeout: time.Millisecond, HalfOpenMaxCalls: 2, SuccessThreshold: 2}
+
+		failed := true
+		service := "icap://localhost:1344/respmod"
+
+		breaker.allow(service)
+		breaker.record(service, BreakerClosed, &failed)
+
+		time.Sleep(5 * time.Millisecond)
+
+		type testSample struct {
+			wantedState   BreakerState
+			wantedAllowed bool
+		}
+
+		sampleTable := []testSample{
+			{wantedState: BreakerHalfOpen, wantedAllowed: true},
+			{wantedState: BreakerHalfOpen, wantedAllowed: true},
+			{wantedState: BreakerOpen, wantedAllowed: false},
+		}
+
+		for i, sample := range sampleTable {
+			if state, allowed := breaker.allow(service); state != sample.wantedState || allowed != sample.wantedAllowed {
+				t.Logf("Call %d: wanted the state:%s & allowed:%v, got:%s & %v", i, sample.wantedState, sample.wantedAllowed, state, allowed)
+				t.Fail()
+			}
+		}
+
+		succeeded := false
+
+		breaker.record(service, BreakerHalfOpen, &succeeded)
+
+		if state := breaker.State(service); state != BreakerHalfOpen {
+			t.Logf("Wanted the breaker to stay half-open after a trial call succeeds, got:%s", state)
+			t.Fail()
+		}
+
+		breaker.record(service, BreakerHalfOpen, &succeeded)
+
+		if state := breaker.State(service); state != BreakerClosed {
+			t.Logf("Wanted the breaker closed after %d trial calls succeed, got:%s", 2, state)
+			t.Fail()
+		}
+	})
+
+	t.Run("CircuitBreaker with an error status", func(t *testing.T) {
+
+		srv := newFakeICAPServer(t, func(req *fakeICAPRequest) string {
+			return "ICAP/1.0 503 Service Overloaded\r\nISTag: TAG\r\nEncapsulated: null-body=0\r\n\r\n"
+		})
+		defer srv.close()
+
+		for _, policy := range []FailurePolicy{FailClosed, FailOpen} {
+			client := &Client{Breaker: &CircuitBreaker{Policy: policy}}
+
+			httpReq, _ := http.NewRequest(http.MethodGet, "http://someurl.com/upload", nil)
+
+			req, err := NewRequest(MethodREQMOD, srv.url("/reqmod"), httpReq, nil)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			resp, err := client.Do(req)
+
+			var serviceErr *ServiceError
+
+			if policy == FailClosed && (!errors.As(err, &serviceErr) || serviceErr.StatusCode != http.StatusServiceUnavailable) {
+				t.Logf("%s: wanted a *ServiceError with the status:%d, got:%v", policy, http.StatusServiceUnavailable, err)
+				t.Fail()
+			}
+
+			if policy == FailOpen && (err != nil || !resp.NotScanned || resp.Breaker == nil || !errors.As(resp.Breaker.Err, &serviceErr)) {
+				t.Logf("%s: wanted a fail-open response for the *ServiceError, got:%v", policy, err)
+				t.Fail()
+			}
+
+			client.CloseIdleConnections()
+		}
+	})
+}
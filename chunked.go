@@ -0,0 +1,449 @@
+package icapclient
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// maxChunkSizeDigits is the maximum number of hexadecimal digits of a chunk size, so that it fits in an int64
+const maxChunkSizeDigits = 15
+
+// chunkExtension is an extension following the size of a chunk, for example: ieof, or name=value
+type chunkExtension struct {
+	name  string
+	value string // empty for the extensions with no value, for example: ieof
+}
+
+// ieofExtension tells the ICAP server the preview holds the entire body, so there is nothing more to ask for
+var ieofExtension = chunkExtension{name: "ieof"}
+
+// String returns the wire representation of the extension, the value is quoted if it isn't a token
+func (e chunkExtension) String() string {
+
+	if e.value == "" {
+		return e.name
+	}
+
+	if isToken(e.value) {
+		return e.name + "=" + e.value
+	}
+
+	return e.name + "=" + `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(e.value) + `"`
+}
+
+// chunkedWriter writes a body in the chunked transfer coding, in chunks of the given size but the last one
+//
+// The data written is held till a chunk is filled up, so the chunk boundaries don't depend on the size of the writes.
+// close must be called to write the rest of the data & the last chunk ending the body
+type chunkedWriter struct {
+	w    io.Writer
+	size int    // the size of the chunks, 0 means a chunk for every write
+	buf  []byte // the data of the chunk being filled up
+}
+
+// newChunkedWriter is the factory function for chunkedWriter
+func newChunkedWriter(w io.Writer, size int) *chunkedWriter {
+
+	if size < 0 {
+		size = 0
+	}
+
+	return &chunkedWriter{
+		w:    w,
+		size: size,
+		buf:  make([]byte, 0, size),
+	}
+}
+
+// Write writes the data in chunks, holding back what doesn't fill up a chunk
+func (cw *chunkedWriter) Write(p []byte) (int, error) {
+
+	if cw.size == 0 {
+		if err := cw.writeChunk(p); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+
+	n := 0
+
+	for len(p) > 0 {
+		if len(cw.buf) == 0 && len(p) >= cw.size { // writing the full chunks right from the data, no need to copy them
+			if err := cw.writeChunk(p[:cw.size]); err != nil {
+				return n, err
+			}
+			n += cw.size
+			p = p[cw.size:]
+			continue
+		}
+
+		m := copy(cw.buf[len(cw.buf):cw.size], p)
+		cw.buf = cw.buf[:len(cw.buf)+m]
+		n += m
+		p = p[m:]
+
+		if len(cw.buf) == cw.size {
+			if err := cw.flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+
+	return n, nil
+}
+
+// flush writes the data held back as a chunk
+func (cw *chunkedWriter) flush() error {
+
+	err := cw.writeChunk(cw.buf)
+	cw.buf = cw.buf[:0]
+
+	return err
+}
+
+// close writes the data held back & the last chunk with the extensions & the trailers, for example: 0; ieof
+func (cw *chunkedWriter) close(trailer http.Header, exts ...chunkExtension) error {
+
+	if err := cw.flush(); err != nil {
+		return err
+	}
+
+	if err := cw.writeSizeLine(0, exts); err != nil {
+		return err
+	}
+
+	if err := trailer.Write(cw.w); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(cw.w, CRLF)
+
+	return err
+}
+
+// writeChunk writes the data as a single chunk with the hexadecimal size notation, for example: Hello World, becomes
+// b
+// Hello World
+func (cw *chunkedWriter) writeChunk(data []byte, exts ...chunkExtension) error {
+
+	if len(data) == 0 { // an empty chunk would indicate the end of the body
+		return nil
+	}
+
+	if err := cw.writeSizeLine(len(data), exts); err != nil {
+		return err
+	}
+
+	if _, err := cw.w.Write(data); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(cw.w, CRLF)
+
+	return err
+}
+
+// writeSizeLine writes the line starting a chunk, its size followed by its extensions, for example: 1f4; name=value
+func (cw *chunkedWriter) writeSizeLine(size int, exts []chunkExtension) error {
+
+	line := strconv.FormatInt(int64(size), 16)
+
+	for _, ext := range exts {
+		line += "; " + ext.String()
+	}
+
+	_, err := io.WriteString(cw.w, line+CRLF)
+
+	return err
+}
+
+// chunkedReader reads a body in the chunked transfer coding, validating the chunk sizes & keeping the extensions
+// of the last chunk read & the trailers following the last chunk
+type chunkedReader struct {
+	br      *bufio.Reader
+	n       int64            // the bytes of the current chunk left to read
+	exts    []chunkExtension // the extensions of the last chunk read
+	trailer http.Header      // the trailers following the last chunk, set once the body is read till the end
+	err     error
+}
+
+// newChunkedReader is the factory function for chunkedReader
+func newChunkedReader(br *bufio.Reader) *chunkedReader {
+	return &chunkedReader{
+		br: br,
+	}
+}
+
+// Read reads the de-chunked body, io.EOF is returned once the last chunk & the trailers are read
+func (cr *chunkedReader) Read(p []byte) (int, error) {
+
+	if cr.err != nil {
+		return 0, cr.err
+	}
+
+	if cr.n == 0 {
+		if cr.err = cr.readSizeLine(); cr.err != nil {
+			return 0, cr.err
+		}
+
+		if cr.n == 0 { // the last chunk
+			cr.err = cr.readTrailer()
+			if cr.err == nil {
+				cr.err = io.EOF
+			}
+			return 0, cr.err
+		}
+	}
+
+	if int64(len(p)) > cr.n {
+		p = p[:cr.n]
+	}
+
+	n, err := cr.br.Read(p)
+	cr.n -= int64(n)
+
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+
+	if err == nil && cr.n == 0 {
+		err = cr.readDataEnd()
+	}
+
+	cr.err = err
+
+	return n, err
+}
+
+// extension returns the value of the extension of the last chunk read by its name, false if the chunk has no such extension
+func (cr *chunkedReader) extension(name string) (string, bool) {
+
+	for _, ext := range cr.exts {
+		if strings.EqualFold(ext.name, name) {
+			return ext.value, true
+		}
+	}
+
+	return "", false
+}
+
+// readSizeLine reads the line starting a chunk, for example: 1f4; name=value
+func (cr *chunkedReader) readSizeLine() error {
+
+	line, err := readChunkLine(cr.br)
+
+	if err != nil {
+		return err
+	}
+
+	sizeStr, exts := line, []chunkExtension(nil)
+
+	if i := strings.IndexByte(line, ';'); i >= 0 {
+		sizeStr = line[:i]
+		if exts, err = parseChunkExtensions(line[i+1:]); err != nil {
+			return err
+		}
+	}
+
+	size, err := parseChunkSize(strings.TrimRight(sizeStr, " \t"))
+
+	if err != nil {
+		return err
+	}
+
+	cr.n = size
+	cr.exts = exts
+
+	return nil
+}
+
+// readDataEnd reads the CRLF ending the data of a chunk
+func (cr *chunkedReader) readDataEnd() error {
+
+	b, err := cr.br.ReadByte()
+
+	if err == nil && b == '\r' {
+		b, err = cr.br.ReadByte()
+	}
+
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if b != '\n' {
+		return ErrInvalidChunkEnd
+	}
+
+	return nil
+}
+
+// readTrailer reads the trailer lines following the last chunk till the empty line ending the body
+func (cr *chunkedReader) readTrailer() error {
+
+	mimeHeader, err := textproto.NewReader(cr.br).ReadMIMEHeader()
+
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if len(mimeHeader) > 0 {
+		cr.trailer = http.Header(mimeHeader)
+	}
+
+	return nil
+}
+
+// readChunkLine reads a line of the chunked body without the line ending, the lines longer than the buffer are rejected
+func readChunkLine(br *bufio.Reader) (string, error) {
+
+	line, err := br.ReadSlice('\n')
+
+	if err == io.EOF {
+		return "", io.ErrUnexpectedEOF
+	}
+
+	if err == bufio.ErrBufferFull {
+		return "", ErrChunkLineTooLong
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	line = line[:len(line)-1]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+
+	return string(line), nil
+}
+
+// parseChunkSize parses the hexadecimal chunk size strictly, nothing but the hexadecimal digits is accepted
+func parseChunkSize(s string) (int64, error) {
+
+	if s == "" || len(s) > maxChunkSizeDigits {
+		return 0, &ProtocolError{Line: s, Err: ErrInvalidChunkSize}
+	}
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+			return 0, &ProtocolError{Line: s, Err: ErrInvalidChunkSize}
+		}
+	}
+
+	return strconv.ParseInt(s, 16, 64)
+}
+
+// parseChunkExtensions parses the extensions following the chunk size, for example: ieof; name=value; name="quoted value"
+func parseChunkExtensions(s string) ([]chunkExtension, error) {
+
+	exts := []chunkExtension{}
+
+	for {
+		s = strings.TrimLeft(s, " \t")
+
+		i := 0
+		for i < len(s) && isTokenChar(s[i]) {
+			i++
+		}
+
+		if i == 0 {
+			return nil, &ProtocolError{Line: s, Err: ErrInvalidChunkExtension}
+		}
+
+		ext := chunkExtension{name: s[:i]}
+		s = strings.TrimLeft(s[i:], " \t")
+
+		if strings.HasPrefix(s, "=") {
+			s = strings.TrimLeft(s[1:], " \t")
+
+			var err error
+			if ext.value, s, err = parseChunkExtensionValue(s); err != nil {
+				return nil, err
+			}
+
+			s = strings.TrimLeft(s, " \t")
+		}
+
+		exts = append(exts, ext)
+
+		if s == "" {
+			return exts, nil
+		}
+
+		if s[0] != ';' {
+			return nil, &ProtocolError{Line: s, Err: ErrInvalidChunkExtension}
+		}
+
+		s = s[1:]
+	}
+}
+
+// parseChunkExtensionValue parses the value of an extension, a token or a quoted string, returning the rest of the line
+func parseChunkExtensionValue(s string) (string, string, error) {
+
+	if !strings.HasPrefix(s, `"`) {
+		i := 0
+		for i < len(s) && isTokenChar(s[i]) {
+			i++
+		}
+
+		if i == 0 {
+			return "", "", &ProtocolError{Line: s, Err: ErrInvalidChunkExtension}
+		}
+
+		return s[:i], s[i:], nil
+	}
+
+	var value strings.Builder
+
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"':
+			return value.String(), s[i+1:], nil
+		case c == '\\' && i+1 < len(s):
+			i++
+			value.WriteByte(s[i])
+		case c == '\t' || c >= ' ' && c != 0x7f:
+			value.WriteByte(c)
+		default:
+			return "", "", &ProtocolError{Line: s, Err: ErrInvalidChunkExtension}
+		}
+	}
+
+	return "", "", &ProtocolError{Line: s, Err: ErrInvalidChunkExtension} // the quoted string is never closed
+}
+
+// isToken tells if the string is a token as RFC 7230 defines it
+func isToken(s string) bool {
+
+	if s == "" {
+		return false
+	}
+
+	for i := 0; i < len(s); i++ {
+		if !isTokenChar(s[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isTokenChar tells if the byte is allowed in a token
+func isTokenChar(c byte) bool {
+	return c > ' ' && c < 0x7f && !strings.ContainsRune(`"(),/:;<=>?@[\]{}`, rune(c))
+}
@@ -0,0 +1,297 @@
+package icapclient
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestChunked(t *testing.T) {
+
+	t.Run("chunkedWriter", func(t *testing.T) {
+
+		type testSample struct {
+			size    int
+			writes  []string
+			exts    []chunkExtension
+			trailer http.Header
+			wanted  string
+		}
+
+		sampleTable := []testSample{
+			{
+				size:   0,
+				writes: []string{"Hello", " World"},
+				wanted: "5\r\nHello\r\n6\r\n World\r\n0\r\n\r\n",
+			},
+			{
+				size:   4,
+				writes: []string{"Hel", "lo Wor", "ld"},
+				wanted: "4\r\nHell\r\n4\r\no Wo\r\n3\r\nrld\r\n0\r\n\r\n",
+			},
+			{
+				size:   5,
+				writes: []string{"Hello World"},
+				exts:   []chunkExtension{ieofExtension},
+				wanted: "5\r\nHello\r\n5\r\n Worl\r\n1\r\nd\r\n0; ieof\r\n\r\n",
+			},
+			{
+				size:   512,
+				writes: []string{},
+				exts: []chunkExtension{
+					{name: "name", value: "value"},
+					{name: "quoted", value: `a "b"; c`},
+				},
+				wanted: "0; name=value; quoted=\"a \\\"b\\\"; c\"\r\n\r\n",
+			},
+			{
+				size:    16,
+				writes:  []string{"Hello World"},
+				trailer: http.Header{"X-Checksum": []string{"abc"}},
+				wanted:  "b\r\nHello World\r\n0\r\nX-Checksum: abc\r\n\r\n",
+			},
+		}
+
+		for _, sample := range sampleTable {
+			buf := &bytes.Buffer{}
+			cw := newChunkedWriter(buf, sample.size)
+
+			for _, w := range sample.writes {
+				if n, err := cw.Write([]byte(w)); err != nil || n != len(w) {
+					t.Fatalf("Wanted %d bytes written, got: %d & error: %v", len(w), n, err)
+				}
+			}
+
+			if err := cw.close(sample.trailer, sample.exts...); err != nil {
+				t.Fatal(err.Error())
+			}
+
+			if buf.String() != sample.wanted {
+				t.Logf("Wanted the chunked body: %q, got: %q", sample.wanted, buf.String())
+				t.Fail()
+			}
+		}
+
+	})
+
+	t.Run("chunkedReader", func(t *testing.T) {
+
+		type testSample struct {
+			chunked       string
+			wantedBody    string
+			wantedExts    []chunkExtension
+			wantedTrailer http.Header
+			wantedErr     bool
+		}
+
+		sampleTable := []testSample{
+			{
+				chunked:    "5\r\nHello\r\n6\r\n World\r\n0\r\n\r\n",
+				wantedBody: "Hello World",
+			},
+			{
+				chunked:    "B\r\nHello World\r\n0; ieof\r\n\r\n",
+				wantedBody: "Hello World",
+				wantedExts: []chunkExtension{ieofExtension},
+			},
+			{
+				chunked:    "0005 ; foo=bar\r\nHello\r\n0;name = value ;quoted=\"a \\\"b\\\"; c\"; flag\r\n\r\n",
+				wantedBody: "Hello",
+				wantedExts: []chunkExtension{
+					{name: "name", value: "value"},
+					{name: "quoted", value: `a "b"; c`},
+					{name: "flag"},
+				},
+			},
+			{
+				chunked:       "5\nHello\n0\nX-Checksum: abc\r\nX-Scan: done\r\n\r\n",
+				wantedBody:    "Hello",
+				wantedTrailer: http.Header{"X-Checksum": []string{"abc"}, "X-Scan": []string{"done"}},
+			},
+			{
+				chunked:   "0x5\r\nHello\r\n0\r\n\r\n",
+				wantedErr: true,
+			},
+			{
+				chunked:   "+5\r\nHello\r\n0\r\n\r\n",
+				wantedErr: true,
+			},
+			{
+				chunked:   "-1\r\n\r\n",
+				wantedErr: true,
+			},
+			{
+				chunked:   " 5\r\nHello\r\n0\r\n\r\n",
+				wantedErr: true,
+			},
+			{
+				chunked:   "\r\nHello\r\n0\r\n\r\n",
+				wantedErr: true,
+			},
+			{
+				chunked:   "fffffffffffffffff\r\nHello\r\n0\r\n\r\n",
+				wantedErr: true,
+			},
+			{
+				chunked:   "5g\r\nHello\r\n0\r\n\r\n",
+				wantedErr: true,
+			},
+			{
+				chunked:   "5\r\nHelloWorld\r\n0\r\n\r\n",
+				wantedErr: true,
+			},
+			{
+				chunked:   "5; =value\r\nHello\r\n0\r\n\r\n",
+				wantedErr: true,
+			},
+			{
+				chunked:   "5; name=\"unclosed\r\nHello\r\n0\r\n\r\n",
+				wantedErr: true,
+			},
+			{
+				chunked:   "5; name=\r\nHello\r\n0\r\n\r\n",
+				wantedErr: true,
+			},
+			{
+				chunked:   "5\r\nHello\r\n",
+				wantedErr: true,
+			},
+			{
+				chunked:   "5\r\nHello\r\n0\r\nX-Checksum: abc\r\n",
+				wantedErr: true,
+			},
+			{
+				chunked:   strings.Repeat("0", 5000) + "5\r\nHello\r\n0\r\n\r\n",
+				wantedErr: true,
+			},
+		}
+
+		for _, sample := range sampleTable {
+			cr := newChunkedReader(bufio.NewReader(strings.NewReader(sample.chunked)))
+
+			body, err := ioutil.ReadAll(cr)
+
+			if sample.wantedErr {
+				if err == nil {
+					t.Logf("Wanted an error for the chunked body: %q, got none", sample.chunked)
+					t.Fail()
+				}
+				continue
+			}
+
+			if err != nil {
+				t.Logf("Wanted no error for the chunked body: %q, got: %s", sample.chunked, err.Error())
+				t.Fail()
+				continue
+			}
+
+			if string(body) != sample.wantedBody {
+				t.Logf("Wanted the body: %q, got: %q", sample.wantedBody, string(body))
+				t.Fail()
+			}
+
+			if !reflect.DeepEqual(cr.exts, sample.wantedExts) {
+				t.Logf("Wanted the last chunk extensions: %v, got: %v", sample.wantedExts, cr.exts)
+				t.Fail()
+			}
+
+			if !reflect.DeepEqual(cr.trailer, sample.wantedTrailer) {
+				t.Logf("Wanted the trailer: %v, got: %v", sample.wantedTrailer, cr.trailer)
+				t.Fail()
+			}
+		}
+
+	})
+
+	t.Run("chunkedReader ieof", func(t *testing.T) {
+
+		cr := newChunkedReader(bufio.NewReader(strings.NewReader("5\r\nHello\r\n0; IEOF\r\n\r\n")))
+
+		if _, err := ioutil.ReadAll(cr); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if _, ok := cr.extension("ieof"); !ok {
+			t.Log("Wanted the last chunk to have the ieof extension, got none")
+			t.Fail()
+		}
+	})
+
+}
+
+func FuzzChunkedReader(f *testing.F) {
+
+	f.Add([]byte("5\r\nHello\r\n6\r\n World\r\n0\r\n\r\n"))
+	f.Add([]byte("5; name=\"value\"\r\nHello\r\n0; ieof\r\n\r\n"))
+	f.Add([]byte("5\r\nHello\r\n0\r\nX-Checksum: abc\r\n\r\n"))
+	f.Add([]byte("fffffffffffffff\r\n"))
+
+	f.Fuzz(func(t *testing.T, chunked []byte) {
+		cr := newChunkedReader(bufio.NewReader(bytes.NewReader(chunked)))
+
+		body, err := ioutil.ReadAll(cr)
+
+		if err != nil {
+			return
+		}
+
+		buf := &bytes.Buffer{} // whatever is decoded must survive the round trip
+		cw := newChunkedWriter(buf, 7)
+
+		if _, err := cw.Write(body); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if err := cw.close(nil, cr.exts...); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		again, err := ioutil.ReadAll(newChunkedReader(bufio.NewReader(buf)))
+
+		if err != nil {
+			t.Fatalf("Wanted the re-encoded body to decode, got: %s", err.Error())
+		}
+
+		if !bytes.Equal(body, again) {
+			t.Fatalf("Wanted the body: %q, got: %q", body, again)
+		}
+	})
+}
+
+func FuzzChunkedRoundTrip(f *testing.F) {
+
+	f.Add([]byte("Hello World"), 0)
+	f.Add([]byte("Hello World"), 4)
+	f.Add([]byte{}, 512)
+
+	f.Fuzz(func(t *testing.T, data []byte, size int) {
+		if size < 0 || size > 1<<16 {
+			return
+		}
+
+		buf := &bytes.Buffer{}
+		cw := newChunkedWriter(buf, size)
+
+		if _, err := cw.Write(data); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if err := cw.close(nil); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		body, err := ioutil.ReadAll(newChunkedReader(bufio.NewReader(buf)))
+
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if !bytes.Equal(body, data) {
+			t.Fatalf("Wanted the body: %q, got: %q", data, body)
+		}
+	})
+}
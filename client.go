@@ -1,61 +1,153 @@
 package icapclient
 
 import (
+	"context"
+	"crypto/tls"
+	"fmt"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 )
 
 // Client represents the icap client who makes the icap server calls
+//
+// A Client is safe for concurrent use by multiple goroutines, every call made with it gets its own connection.
+// The fields should not be modified once the client is in use.
+//
+// The connections to the ICAP servers are kept open after the calls & reused by the upcoming ones,
+// unless the server closes them or MaxIdleConnsPerHost is negative
+//
+// With NegotiateOptions set, the OPTIONS of an ICAP service are fetched once & cached till the Options-TTL expires or
+// the ISTag of the service changes. The RESPMOD & REQMOD calls are then checked against the methods of the service
+// & get the preview & the Allow: 204 header as the service advertises them. The files are sent in preview, in full or
+// not at all by their extensions, as the Transfer-Preview, Transfer-Complete & Transfer-Ignore options tell,
+// the calls skipped get a 204 No Content response with NotScanned set
+//
+// With Servers set, every call is made with a server of the pool instead of the url of the request, failing over to
+// the next healthy server if it fails for a transient reason. The url of the request is set to the one of the server
+// the call is made with
 type Client struct {
-	scktDriver *Driver
-	Timeout    time.Duration
+	scktDriver          *Driver
+	Timeout             time.Duration
+	MaxIdleConnsPerHost int              // the maximum number of idle connections kept per ICAP server (default: 2)
+	IdleConnTimeout     time.Duration    // how long an idle connection is kept before getting closed (default: 90s)
+	TLSConfig           *tls.Config      // the TLS configuration for the icaps:// calls (default: the system CAs & the url host as the server name)
+	NegotiateOptions    bool             // fetch & cache the OPTIONS of every ICAP service called to set up the RESPMOD & REQMOD calls with
+	StatusErrors        bool             // return the responses with a non 2xx status as a *ServiceError instead of a Response
+	Retry               *RetryPolicy     // how to retry the calls failing for a transient reason (default: no retries)
+	Servers             *ServerPool      // the ICAP servers to spread the calls over & fail over to, instead of the url of the requests
+	Classifier          *Classifier      // the classifier of the verdicts of ScanReader & ScanFile (default: DefaultClassifier)
+	Breaker             *CircuitBreaker  // stops the calls to the ICAP services failing over & over, returning them as its policy tells
+	Multipart           *MultipartLimits // the limits of the uploads ScanMultipart takes (default: 100 parts, 32MB & 4 files at a time)
+	pool                *connPool
+	poolOnce            sync.Once
+	optsCache           *optionsCache
+	optsOnce            sync.Once
 }
 
+// defaultClient makes the ICAP calls of the helpers given no client
+var defaultClient = &Client{}
+
 // Do makes  does everything required to make a call to the ICAP server
 func (c *Client) Do(req *Request) (*Response, error) {
 
-	if c.scktDriver == nil { // create a new socket driver if one wasn't explicitly created
-		port, err := strconv.Atoi(req.URL.Port())
+	if c.Breaker != nil && !req.pinned { // the calls made on behalf of another call are left to the breaker of that call
+		return c.Breaker.do(req, c.retryPolicy(), c.doCall)
+	}
 
-		if err != nil {
-			return nil, err
+	return c.doCall(req)
+}
+
+// doCall makes the call to the ICAP server, negotiating the options & retrying as the client tells
+func (c *Client) doCall(req *Request) (*Response, error) {
+
+	req.SetDefaultRequestHeaders() // assigning default headers if not set already
+
+	req = req.forCall() // the options negotiated & the server picked are applied to the call, not to the request given
+
+	var opts *ServiceOptions
+	var err error
+
+	if c.Servers != nil && !req.pinned {
+		opts, err = c.pickServer(req)
+	} else {
+		opts, err = c.applyOptions(req)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if opts != nil && opts.Transfer(req.filename()) == TransferIgnore { // the service doesn't want the body, so the call is skipped
+		logDebug("Skipping the call as the service asks to ignore: ", req.filename())
+		if req.server != nil {
+			c.Servers.release(req.server)
+			req.setServer(nil)
 		}
-		c.scktDriver = NewDriver(req.URL.Hostname(), port)
+		return notScannedResponse(opts), nil
+	}
+
+	resp, err := c.doWithRetry(req)
+
+	if err != nil {
+		return nil, err
 	}
 
-	c.setDefaultTimeouts() // assinging default timeouts if not set already
+	resp.method = req.Method
+	resp.sentHTTPHeader = req.sentHTTPHeader
+
+	if (c.NegotiateOptions || req.negotiate) && req.Method != MethodOPTIONS { // the service has changed if its ISTag did, so its options might have too
+		c.options().checkISTag(optionsKey(req), resp.Header.Get(ISTagHeader))
+	}
+
+	if c.StatusErrors && resp.StatusCode/100 != 2 {
+		return nil, newServiceError(resp)
+	}
+
+	return resp, nil
+}
+
+// do makes the call to the ICAP server
+func (c *Client) do(req *Request) (*Response, error) {
+
+	d, err := c.driver(req) // every call works on its own copy of the socket driver
+
+	if err != nil {
+		return nil, err
+	}
 
 	if req.ctx != nil { // connect with the given context if context is set
-		if err := c.scktDriver.ConnectWithContext(*req.ctx); err != nil {
+		if err := d.ConnectWithContext(*req.ctx); err != nil {
 			return nil, err
 		}
 	} else {
-		if err := c.scktDriver.Connect(); err != nil {
+		if err := d.Connect(); err != nil {
 			return nil, err
 		}
 	}
 
-	defer c.scktDriver.Close() // closing the socket connection
+	req.scktDriver = d
 
-	req.SetDefaultRequestHeaders() // assigning default headers if not set already
+	defer func() { // closing the socket connection
+		d.Close()
+		req.scktDriver = nil
+	}()
 
 	logDebug("The request headers: ")
 	dumpDebug(req.Header)
 
-	d, err := DumpRequest(req) // getting the byte representation of the ICAP request
-
-	if err != nil {
-		return nil, err
-	}
-
-	if err := c.scktDriver.Send(d); err != nil { // sending the entire TCP message of the ICAP client to the server connected
+	if err := newRequestEncoder(d.tcp, req).encode(); err != nil { // streaming the ICAP request to the server connected, the body is read & sent chunk by chunk
+		d.tcp.keepAlive = false // the request might be cut short on the connection, whatever the error
 		return nil, err
 	}
 
-	resp, err := c.scktDriver.Receive() // taking the response
+	resp, err := d.Receive() // taking the response
 
 	if err != nil {
+		if timeoutErr, ok := err.(*TimeoutError); ok && timeoutErr.Phase == PhaseRead && req.previewSet && !req.bodyFittedInPreview {
+			timeoutErr.Phase = PhasePreviewContinue // the server is yet to tell if it wants the rest of the body
+		}
 		return nil, err
 	}
 
@@ -67,22 +159,30 @@ func (c *Client) Do(req *Request) (*Response, error) {
 	return resp, nil
 }
 
-// DoRemaining requests an ICAP server with the remaining body bytes which did not fit in the preview in the original request
-func (c *Client) DoRemaining(req *Request) (*Response, error) {
+// DoContext makes the call to the ICAP server with the context governing the entire exchange, the reads & the writes
+// in progress, the remaining body sent after the preview & the response body streamed included, are aborted as soon as
+// the context is done. The context deadline applies to every socket operation along with the timeouts of the client,
+// whichever is sooner. The error returned is then context.Canceled or context.DeadlineExceeded, checkable with errors.Is
+func (c *Client) DoContext(ctx context.Context, req *Request) (*Response, error) {
+	req.SetContext(ctx)
 
-	data := req.remainingPreviewBytes
+	return c.Do(req)
+}
 
-	if !bodyAlreadyChunked(string(data)) { // if the body is not already chunke, then add the basic hexa body bytes notation
-		ds := string(data)
-		addHexaBodyByteNotations(&ds)
-		data = []byte(ds)
+// DoRemaining requests an ICAP server with the remaining body bytes which did not fit in the preview in the original request,
+// over the connection of the call in progress for the request
+func (c *Client) DoRemaining(req *Request) (*Response, error) {
+
+	if req.scktDriver == nil {
+		return nil, ErrConnectionNotOpen
 	}
 
-	if err := c.scktDriver.Send(data); err != nil {
+	if err := newRequestEncoder(req.scktDriver.tcp, req).encodeRemaining(); err != nil { // the preview portion is already read from the body, so streaming the rest of it
+		req.scktDriver.tcp.keepAlive = false // the request might be cut short on the connection, whatever the error
 		return nil, err
 	}
 
-	resp, err := c.scktDriver.Receive()
+	resp, err := req.scktDriver.Receive()
 
 	if err != nil {
 		return nil, err
@@ -91,25 +191,165 @@ func (c *Client) DoRemaining(req *Request) (*Response, error) {
 	return resp, nil
 }
 
-// SetDriver sets a new socket driver with the client
+// ServiceOptions returns the options of the ICAP service at the url, cached ones if there are,
+// the OPTIONS call is made with the given request's context if any
+func (c *Client) ServiceOptions(req *Request) (*ServiceOptions, error) {
+	return c.options().get(optionsKey(req), func() (*ServiceOptions, error) {
+		optReq, err := NewRequest(MethodOPTIONS, req.URL.String(), nil, nil)
+
+		if err != nil {
+			return nil, err
+		}
+
+		optReq.ctx = req.ctx
+		optReq.pinned = true // the options are of the server picked for the request
+
+		resp, err := c.Do(optReq)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, newServiceError(resp)
+		}
+
+		return resp.Options()
+	})
+}
+
+// applyOptions sets up the RESPMOD & REQMOD calls with the options of the service, if the options are negotiated,
+// the options applied are returned
+func (c *Client) applyOptions(req *Request) (*ServiceOptions, error) {
+
+	if (!c.NegotiateOptions && !req.negotiate) || req.Method == MethodOPTIONS {
+		return nil, nil
+	}
+
+	opts, err := c.ServiceOptions(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !opts.Methods[req.Method] {
+		return nil, fmt.Errorf("%w: %s", ErrMethodNotAllowed, req.Method)
+	}
+
+	if !opts.Allow204 { // the service never skips the response when there is no modification, so there is no use asking for it
+		req.Header.Del(AllowHeader)
+	}
+
+	if req.previewSet || req.body() == nil {
+		return opts, nil
+	}
+
+	if opts.Preview >= 0 && opts.Transfer(req.filename()) == TransferPreview { // the files to be sent in full get no preview
+		preview := opts.Preview
+		if preview > MaxPreviewSize { // the preview is the server's to tell, not how much the client holds up sending
+			preview = MaxPreviewSize
+		}
+		if err := req.SetPreview(preview); err != nil {
+			return nil, err
+		}
+	}
+
+	return opts, nil
+}
+
+// SetDriver sets a new socket driver with the client, the driver is only used as a configuration and never modified by the client
 func (c *Client) SetDriver(d *Driver) {
 	c.scktDriver = d
 }
 
-func (c *Client) setDefaultTimeouts() {
-	if c.Timeout == 0 {
-		c.Timeout = defaultTimeout
+// CloseIdleConnections closes the connections kept open for reuse by the previous calls
+func (c *Client) CloseIdleConnections() {
+	if pool := c.connPool(); pool != nil {
+		pool.closeIdle()
+	}
+}
+
+// driver prepares the socket driver for a call, a copy of the one set with the client or a new one for the request URL,
+// with the default timeouts assigned if not set already
+func (c *Client) driver(req *Request) (*Driver, error) {
+	var d Driver
+
+	if c.scktDriver != nil {
+		d = *c.scktDriver
+		d.tcp = nil
+	} else { // create a new socket driver if one wasn't explicitly set
+		port := defaultICAPPort
+		if req.URL.Scheme == SchemeICAPS {
+			port = defaultICAPSPort
+		}
+
+		if req.URL.Port() != "" {
+			var err error
+			if port, err = strconv.Atoi(req.URL.Port()); err != nil {
+				return nil, err
+			}
+		}
+
+		d = *NewDriver(req.URL.Hostname(), port)
+	}
+
+	if req.URL.Scheme == SchemeICAPS && d.TLSConfig == nil { // the ICAP over TLS calls use the TLS configuration of the client unless the driver has its own
+		d.TLSConfig = c.TLSConfig
+		if d.TLSConfig == nil {
+			d.TLSConfig = &tls.Config{}
+		}
 	}
 
-	if c.scktDriver.DialerTimeout == 0 {
-		c.scktDriver.DialerTimeout = c.Timeout
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
 	}
 
-	if c.scktDriver.ReadTimeout == 0 {
-		c.scktDriver.ReadTimeout = c.Timeout
+	if d.DialerTimeout == 0 {
+		d.DialerTimeout = timeout
 	}
 
-	if c.scktDriver.WriteTimeout == 0 {
-		c.scktDriver.WriteTimeout = c.Timeout
+	if d.ReadTimeout == 0 {
+		d.ReadTimeout = timeout
 	}
+
+	if d.WriteTimeout == 0 {
+		d.WriteTimeout = timeout
+	}
+
+	d.pool = c.connPool()
+
+	return &d, nil
+}
+
+// connPool returns the connection pool of the client, creating one on the first call, nil means the connections are not reused
+func (c *Client) connPool() *connPool {
+	if c.MaxIdleConnsPerHost < 0 {
+		return nil
+	}
+
+	c.poolOnce.Do(func() {
+		maxIdle := c.MaxIdleConnsPerHost
+		if maxIdle == 0 {
+			maxIdle = defaultMaxIdleConnsPerHost
+		}
+
+		idleTimeout := c.IdleConnTimeout
+		if idleTimeout == 0 {
+			idleTimeout = defaultIdleConnTimeout
+		}
+
+		c.pool = newConnPool(maxIdle, idleTimeout)
+	})
+
+	return c.pool
+}
+
+// options returns the options cache of the client, creating one on the first call
+func (c *Client) options() *optionsCache {
+	c.optsOnce.Do(func() {
+		c.optsCache = newOptionsCache()
+	})
+
+	return c.optsCache
 }
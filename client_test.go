@@ -1,12 +1,20 @@
 package icapclient
 
 import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/textproto"
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -14,6 +22,7 @@ import (
 func TestClient(t *testing.T) {
 	if !testServerRunning() {
 		go startTestServer()
+		waitForTestServer()
 	}
 
 	t.Run("Client Do RESPMOD", func(t *testing.T) {
@@ -29,7 +38,6 @@ func TestClient(t *testing.T) {
 			httpResp            *http.Response
 			wantedStatusCode    int
 			wantedStatus        string
-			wantedTimeout       time.Duration
 			wantedDialerTimeout time.Duration
 			wantedReadTimeout   time.Duration
 			wantedWriteTimeout  time.Duration
@@ -52,7 +60,6 @@ func TestClient(t *testing.T) {
 				},
 				wantedStatusCode:    http.StatusNoContent,
 				wantedStatus:        "No Modifications",
-				wantedTimeout:       defaultTimeout,
 				wantedDialerTimeout: defaultTimeout,
 				wantedReadTimeout:   defaultTimeout,
 				wantedWriteTimeout:  defaultTimeout,
@@ -73,7 +80,6 @@ func TestClient(t *testing.T) {
 				},
 				wantedStatusCode:    http.StatusOK,
 				wantedStatus:        "OK",
-				wantedTimeout:       defaultTimeout,
 				wantedDialerTimeout: defaultTimeout,
 				wantedReadTimeout:   defaultTimeout,
 				wantedWriteTimeout:  defaultTimeout,
@@ -106,20 +112,21 @@ func TestClient(t *testing.T) {
 				t.Fail()
 			}
 
-			if client.Timeout != sample.wantedTimeout {
-				t.Logf("Wanted timeout to be:%v, got:%v", sample.wantedTimeout, client.Timeout)
-				t.Fail()
+			d, err := client.driver(req) // the effective socket driver configuration of the calls
+			if err != nil {
+				t.Fatal(err.Error())
 			}
-			if client.scktDriver.DialerTimeout != sample.wantedDialerTimeout {
-				t.Logf("Wanted DialierTimeout to be:%v , got:%v", sample.wantedDialerTimeout, client.scktDriver.DialerTimeout)
+
+			if d.DialerTimeout != sample.wantedDialerTimeout {
+				t.Logf("Wanted DialierTimeout to be:%v , got:%v", sample.wantedDialerTimeout, d.DialerTimeout)
 				t.Fail()
 			}
-			if client.scktDriver.ReadTimeout != sample.wantedReadTimeout {
-				t.Logf("Wanted ReadTimeout to be:%v , got:%v", sample.wantedReadTimeout, client.scktDriver.ReadTimeout)
+			if d.ReadTimeout != sample.wantedReadTimeout {
+				t.Logf("Wanted ReadTimeout to be:%v , got:%v", sample.wantedReadTimeout, d.ReadTimeout)
 				t.Fail()
 			}
-			if client.scktDriver.WriteTimeout != sample.wantedWriteTimeout {
-				t.Logf("Wanted WriteTimeout to be:%v , got:%v", sample.wantedWriteTimeout, client.scktDriver.WriteTimeout)
+			if d.WriteTimeout != sample.wantedWriteTimeout {
+				t.Logf("Wanted WriteTimeout to be:%v , got:%v", sample.wantedWriteTimeout, d.WriteTimeout)
 				t.Fail()
 			}
 		}
@@ -132,7 +139,6 @@ func TestClient(t *testing.T) {
 			urlStr              string
 			wantedStatusCode    int
 			wantedStatus        string
-			wantedTimeout       time.Duration
 			wantedDialerTimeout time.Duration
 			wantedReadTimeout   time.Duration
 			wantedWriteTimeout  time.Duration
@@ -143,7 +149,6 @@ func TestClient(t *testing.T) {
 				urlStr:              "http://goodifle.com",
 				wantedStatusCode:    http.StatusNoContent,
 				wantedStatus:        "No Modifications",
-				wantedTimeout:       defaultTimeout,
 				wantedDialerTimeout: defaultTimeout,
 				wantedReadTimeout:   defaultTimeout,
 				wantedWriteTimeout:  defaultTimeout,
@@ -152,7 +157,6 @@ func TestClient(t *testing.T) {
 				urlStr:              "http://badfile.com",
 				wantedStatusCode:    http.StatusOK,
 				wantedStatus:        "OK",
-				wantedTimeout:       defaultTimeout,
 				wantedDialerTimeout: defaultTimeout,
 				wantedReadTimeout:   defaultTimeout,
 				wantedWriteTimeout:  defaultTimeout,
@@ -192,20 +196,21 @@ func TestClient(t *testing.T) {
 				t.Fail()
 			}
 
-			if client.Timeout != sample.wantedTimeout {
-				t.Logf("Wanted timeout to be:%v, got:%v", sample.wantedTimeout, client.Timeout)
-				t.Fail()
+			d, err := client.driver(req) // the effective socket driver configuration of the calls
+			if err != nil {
+				t.Fatal(err.Error())
 			}
-			if client.scktDriver.DialerTimeout != sample.wantedDialerTimeout {
-				t.Logf("Wanted DialierTimeout to be:%v , got:%v", sample.wantedDialerTimeout, client.scktDriver.DialerTimeout)
+
+			if d.DialerTimeout != sample.wantedDialerTimeout {
+				t.Logf("Wanted DialierTimeout to be:%v , got:%v", sample.wantedDialerTimeout, d.DialerTimeout)
 				t.Fail()
 			}
-			if client.scktDriver.ReadTimeout != sample.wantedReadTimeout {
-				t.Logf("Wanted ReadTimeout to be:%v , got:%v", sample.wantedReadTimeout, client.scktDriver.ReadTimeout)
+			if d.ReadTimeout != sample.wantedReadTimeout {
+				t.Logf("Wanted ReadTimeout to be:%v , got:%v", sample.wantedReadTimeout, d.ReadTimeout)
 				t.Fail()
 			}
-			if client.scktDriver.WriteTimeout != sample.wantedWriteTimeout {
-				t.Logf("Wanted WriteTimeout to be:%v , got:%v", sample.wantedWriteTimeout, client.scktDriver.WriteTimeout)
+			if d.WriteTimeout != sample.wantedWriteTimeout {
+				t.Logf("Wanted WriteTimeout to be:%v , got:%v", sample.wantedWriteTimeout, d.WriteTimeout)
 				t.Fail()
 			}
 
@@ -492,7 +497,6 @@ func TestClient(t *testing.T) {
 			urlStr              string
 			wantedStatusCode    int
 			wantedStatus        string
-			wantedTimeout       time.Duration
 			wantedDialerTimeout time.Duration
 			wantedReadTimeout   time.Duration
 			wantedWriteTimeout  time.Duration
@@ -503,7 +507,6 @@ func TestClient(t *testing.T) {
 				urlStr:              "http://goodifle.com",
 				wantedStatusCode:    http.StatusNoContent,
 				wantedStatus:        "No Modifications",
-				wantedTimeout:       defaultTimeout,
 				wantedDialerTimeout: 2 * time.Second,
 				wantedReadTimeout:   2 * time.Second,
 				wantedWriteTimeout:  2 * time.Second,
@@ -512,7 +515,6 @@ func TestClient(t *testing.T) {
 				urlStr:              "http://badfile.com",
 				wantedStatusCode:    http.StatusOK,
 				wantedStatus:        "OK",
-				wantedTimeout:       defaultTimeout,
 				wantedDialerTimeout: 2 * time.Second,
 				wantedReadTimeout:   2 * time.Second,
 				wantedWriteTimeout:  2 * time.Second,
@@ -559,23 +561,658 @@ func TestClient(t *testing.T) {
 				t.Fail()
 			}
 
-			if client.Timeout != sample.wantedTimeout {
-				t.Logf("Wanted timeout to be:%v, got:%v", sample.wantedTimeout, client.Timeout)
+			d, err := client.driver(req) // the effective socket driver configuration of the calls
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			if d.DialerTimeout != sample.wantedDialerTimeout {
+				t.Logf("Wanted DialierTimeout to be:%v , got:%v", sample.wantedDialerTimeout, d.DialerTimeout)
+				t.Fail()
+			}
+			if d.ReadTimeout != sample.wantedReadTimeout {
+				t.Logf("Wanted ReadTimeout to be:%v , got:%v", sample.wantedReadTimeout, d.ReadTimeout)
+				t.Fail()
+			}
+			if d.WriteTimeout != sample.wantedWriteTimeout {
+				t.Logf("Wanted WriteTimeout to be:%v , got:%v", sample.wantedWriteTimeout, d.WriteTimeout)
+				t.Fail()
+			}
+
+			if client.scktDriver.tcp != nil || client.scktDriver.pool != nil {
+				t.Log("Wanted the driver set with the client to be left untouched")
+				t.Fail()
+			}
+
+		}
+	})
+
+	t.Run("Client Do concurrently", func(t *testing.T) {
+
+		type testSample struct {
+			method           string
+			urlStr           string
+			body             string
+			wantedStatusCode int
+		}
+
+		sampleTable := []testSample{
+			{
+				method:           MethodRESPMOD,
+				body:             "This is a GOOD FILE",
+				wantedStatusCode: http.StatusNoContent,
+			},
+			{
+				method:           MethodRESPMOD,
+				body:             "This is a BAD FILE",
+				wantedStatusCode: http.StatusOK,
+			},
+			{
+				method:           MethodREQMOD,
+				urlStr:           goodURL,
+				wantedStatusCode: http.StatusNoContent,
+			},
+			{
+				method:           MethodREQMOD,
+				urlStr:           badURL,
+				wantedStatusCode: http.StatusOK,
+			},
+		}
+
+		client := &Client{} // a single client shared by all the goroutines
+
+		wg := sync.WaitGroup{}
+		errs := make(chan error, 10*len(sampleTable))
+
+		for i := 0; i < 10; i++ {
+			for _, sample := range sampleTable {
+				wg.Add(1)
+				go func(sample testSample) {
+					defer wg.Done()
+
+					var req *Request
+					var err error
+
+					if sample.method == MethodRESPMOD {
+						httpReq, _ := http.NewRequest(http.MethodGet, "http://someurl.com", nil)
+						httpResp := &http.Response{
+							Status:     "200 OK",
+							StatusCode: http.StatusOK,
+							Proto:      "HTTP/1.0",
+							ProtoMajor: 1,
+							ProtoMinor: 0,
+							Header: http.Header{
+								"Content-Type":   []string{"plain/text"},
+								"Content-Length": []string{strconv.Itoa(len(sample.body))},
+							},
+							ContentLength: int64(len(sample.body)),
+							Body:          ioutil.NopCloser(strings.NewReader(sample.body)),
+						}
+						req, err = NewRequest(MethodRESPMOD, fmt.Sprintf("icap://localhost:%d/respmod", port), httpReq, httpResp)
+					} else {
+						httpReq, _ := http.NewRequest(http.MethodGet, sample.urlStr, nil)
+						req, err = NewRequest(MethodREQMOD, fmt.Sprintf("icap://localhost:%d/reqmod", port), httpReq, nil)
+					}
+
+					if err != nil {
+						errs <- err
+						return
+					}
+
+					resp, err := client.Do(req)
+					if err != nil {
+						errs <- err
+						return
+					}
+
+					if resp.StatusCode != sample.wantedStatusCode {
+						errs <- fmt.Errorf("wanted status code:%d for %s, got:%d", sample.wantedStatusCode, sample.method,
+							resp.StatusCode)
+					}
+				}(sample)
+			}
+		}
+
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			t.Log(err.Error())
+			t.Fail()
+		}
+	})
+
+	t.Run("Client Do RESPMOD over ICAPS", func(t *testing.T) {
+
+		ca, err := newTestCA()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		srvCert, err := ca.issue("localhost", "127.0.0.1")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		sniCert, err := ca.issue("scanner.internal")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		clientCert, err := ca.issue("icap-client")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		type testSample struct {
+			serverConfig     *tls.Config
+			clientConfig     *tls.Config
+			wantedStatusCode int
+			wantedErr        bool
+		}
+
+		sampleTable := []testSample{
+			{
+				serverConfig:     &tls.Config{Certificates: []tls.Certificate{srvCert}},
+				clientConfig:     &tls.Config{RootCAs: ca.pool},
+				wantedStatusCode: http.StatusNoContent,
+			},
+			{
+				serverConfig: &tls.Config{Certificates: []tls.Certificate{srvCert}},
+				clientConfig: nil, // the test CA is not among the system CAs
+				wantedErr:    true,
+			},
+			{
+				serverConfig: &tls.Config{
+					Certificates: []tls.Certificate{srvCert},
+					ClientCAs:    ca.pool,
+					ClientAuth:   tls.RequireAndVerifyClientCert,
+				},
+				clientConfig: &tls.Config{
+					RootCAs:      ca.pool,
+					Certificates: []tls.Certificate{clientCert},
+				},
+				wantedStatusCode: http.StatusNoContent,
+			},
+			{
+				serverConfig: &tls.Config{
+					Certificates: []tls.Certificate{srvCert},
+					ClientCAs:    ca.pool,
+					ClientAuth:   tls.RequireAndVerifyClientCert,
+				},
+				clientConfig: &tls.Config{RootCAs: ca.pool},
+				wantedErr:    true,
+			},
+			{
+				serverConfig: &tls.Config{Certificates: []tls.Certificate{sniCert}},
+				clientConfig: &tls.Config{
+					RootCAs:    ca.pool,
+					ServerName: "scanner.internal",
+				},
+				wantedStatusCode: http.StatusNoContent,
+			},
+			{
+				serverConfig: &tls.Config{
+					Certificates: []tls.Certificate{srvCert},
+					MaxVersion:   tls.VersionTLS12,
+				},
+				clientConfig: &tls.Config{
+					RootCAs:    ca.pool,
+					MinVersion: tls.VersionTLS13,
+				},
+				wantedErr: true,
+			},
+		}
+
+		for _, sample := range sampleTable {
+			addr, stop, err := startTLSTestServer(sample.serverConfig)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			httpReq, _ := http.NewRequest(http.MethodGet, "http://someurl.com", nil)
+			httpResp := &http.Response{
+				Status:     "200 OK",
+				StatusCode: http.StatusOK,
+				Proto:      "HTTP/1.0",
+				ProtoMajor: 1,
+				ProtoMinor: 0,
+				Header: http.Header{
+					"Content-Type":   []string{"plain/text"},
+					"Content-Length": []string{"19"},
+				},
+				ContentLength: 19,
+				Body:          ioutil.NopCloser(strings.NewReader("This is a GOOD FILE")),
+			}
+
+			req, err := NewRequest(MethodRESPMOD, fmt.Sprintf("icaps://%s/respmod", addr), httpReq, httpResp)
+			if err != nil {
+				stop()
+				t.Fatal(err.Error())
+			}
+
+			client := &Client{
+				Timeout:   2 * time.Second,
+				TLSConfig: sample.clientConfig,
+			}
+
+			resp, err := client.Do(req)
+			stop()
+
+			if sample.wantedErr {
+				if err == nil {
+					t.Log("Wanted the TLS connection to fail, got no error")
+					t.Fail()
+				}
+				continue
+			}
+
+			if err != nil {
+				t.Log(err.Error())
+				t.Fail()
+				continue
+			}
+
+			if resp.StatusCode != sample.wantedStatusCode {
+				t.Logf("Wanted status code:%d, got:%d", sample.wantedStatusCode, resp.StatusCode)
+				t.Fail()
+			}
+		}
+	})
+
+	t.Run("Client Do reuses the connection after the body is read", func(t *testing.T) {
+
+		lstnr, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		defer lstnr.Close()
+
+		httpRespStr := "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\n\r\n"
+		icapRespStr := "ICAP/1.0 200 OK\r\n" +
+			"ISTag: \"5BDEEEA9-12E4-2\"\r\n" +
+			fmt.Sprintf("Encapsulated: res-hdr=0, res-body=%d\r\n\r\n", len(httpRespStr)) +
+			httpRespStr +
+			"b\r\nHello World\r\n0\r\n\r\n"
+
+		accepted := make(chan struct{}, 10)
+
+		go func() {
+			for {
+				conn, err := lstnr.Accept()
+				if err != nil {
+					return
+				}
+				accepted <- struct{}{}
+
+				go func(conn net.Conn) { // a keep-alive server answering every request on the same connection
+					defer conn.Close()
+					br := bufio.NewReader(conn)
+					for {
+						tp := textproto.NewReader(br)
+						if _, err := tp.ReadLine(); err != nil { // the ICAP request line
+							return
+						}
+						if _, err := tp.ReadMIMEHeader(); err != nil {
+							return
+						}
+						if _, err := tp.ReadLine(); err != nil { // the encapsulated http response status line
+							return
+						}
+						if _, err := tp.ReadMIMEHeader(); err != nil {
+							return
+						}
+						if _, err := ioutil.ReadAll(newChunkedReader(br)); err != nil {
+							return
+						}
+						if _, err := io.WriteString(conn, icapRespStr); err != nil {
+							return
+						}
+					}
+				}(conn)
+			}
+		}()
+
+		client := &Client{}
+		defer client.CloseIdleConnections()
+
+		for i := 0; i < 2; i++ {
+			httpResp := &http.Response{
+				Status:     "200 OK",
+				StatusCode: http.StatusOK,
+				Proto:      "HTTP/1.1",
+				ProtoMajor: 1,
+				ProtoMinor: 1,
+				Header:     http.Header{},
+				Body:       ioutil.NopCloser(strings.NewReader("Hello World")),
+			}
+
+			req, err := NewRequest(MethodRESPMOD, "icap://"+lstnr.Addr().String()+"/respmod", nil, httpResp)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			body, err := ioutil.ReadAll(resp.ContentResponse.Body)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			resp.ContentResponse.Body.Close()
+
+			if string(body) != "Hello World" {
+				t.Logf("Wanted the adapted body: %s, got: %s", "Hello World", string(body))
+				t.Fail()
+			}
+		}
+
+		if len(accepted) != 1 {
+			t.Logf("Wanted the connections accepted by the server:%d, got:%d", 1, len(accepted))
+			t.Fail()
+		}
+	})
+
+	t.Run("Client Do drops the connection after a body read error", func(t *testing.T) {
+
+		srv := newFakeICAPServer(t, func(req *fakeICAPRequest) string {
+			return noContentResponse("TAG")
+		})
+		defer srv.close()
+
+		client := &Client{Timeout: 2 * time.Second}
+		defer client.CloseIdleConnections()
+
+		bodies := []io.Reader{
+			io.MultiReader(io.LimitReader(zeroReader{}, 100*1024), &failingReader{}), // fails partway through
+			strings.NewReader("Hello World"),
+		}
+
+		for i, body := range bodies {
+			httpReq, err := http.NewRequest(http.MethodPost, "http://localhost/upload", ioutil.NopCloser(body))
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			req, err := NewRequest(MethodREQMOD, srv.url("/reqmod"), httpReq, nil)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			_, err = client.Do(req)
+
+			if wantedErr := i == 0; (err != nil) != wantedErr {
+				t.Fatalf("Wanted the call %d to fail:%v, got:%v", i, wantedErr, err)
+			}
+		}
+
+		if n := srv.accepted(); n != 2 {
+			t.Logf("Wanted the connections accepted by the server:%d, got:%d", 2, n)
+			t.Fail()
+		}
+	})
+
+	t.Run("Client Do sends the body in chunks of ChunkLength", func(t *testing.T) {
+
+		lstnr, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		defer lstnr.Close()
+
+		chunkSizes := make(chan []int, 1)
+
+		go func() {
+			for {
+				conn, err := lstnr.Accept()
+				if err != nil {
+					return
+				}
+
+				go func(conn net.Conn) { // a server noting down the size of every chunk it receives
+					defer conn.Close()
+					br := bufio.NewReader(conn)
+					tp := textproto.NewReader(br)
+					if _, err := tp.ReadLine(); err != nil { // the ICAP request line
+						return
+					}
+					if _, err := tp.ReadMIMEHeader(); err != nil {
+						return
+					}
+					if _, err := tp.ReadLine(); err != nil { // the encapsulated http response status line
+						return
+					}
+					if _, err := tp.ReadMIMEHeader(); err != nil {
+						return
+					}
+
+					sizes := []int{}
+					for {
+						line, err := tp.ReadLine()
+						if err != nil {
+							return
+						}
+						size, err := strconv.ParseInt(line, 16, 64)
+						if err != nil {
+							return
+						}
+						if size == 0 {
+							break
+						}
+						sizes = append(sizes, int(size))
+						if _, err := io.CopyN(ioutil.Discard, br, size+2); err != nil { // the chunk data & the CRLF
+							return
+						}
+					}
+					chunkSizes <- sizes
+
+					io.WriteString(conn, "ICAP/1.0 204 No Content\r\nConnection: close\r\nEncapsulated: null-body=0\r\n\r\n")
+				}(conn)
+			}
+		}()
+
+		type testSample struct {
+			chunkLength  int
+			bodyLen      int
+			wantedChunks []int
+		}
+
+		sampleTable := []testSample{
+			{
+				chunkLength:  0,
+				bodyLen:      1300,
+				wantedChunks: []int{512, 512, 276},
+			},
+			{
+				chunkLength:  100,
+				bodyLen:      250,
+				wantedChunks: []int{100, 100, 50},
+			},
+			{
+				chunkLength:  64 * 1024,
+				bodyLen:      150000,
+				wantedChunks: []int{65536, 65536, 18928},
+			},
+			{
+				chunkLength:  1000,
+				bodyLen:      1000,
+				wantedChunks: []int{1000},
+			},
+		}
+
+		client := &Client{}
+
+		for _, sample := range sampleTable {
+			httpResp := &http.Response{
+				Status:     "200 OK",
+				StatusCode: http.StatusOK,
+				Proto:      "HTTP/1.1",
+				ProtoMajor: 1,
+				ProtoMinor: 1,
+				Header:     http.Header{},
+				Body:       ioutil.NopCloser(strings.NewReader(strings.Repeat("a", sample.bodyLen))),
+			}
+
+			req, err := NewRequest(MethodRESPMOD, "icap://"+lstnr.Addr().String()+"/respmod", nil, httpResp)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			req.ChunkLength = sample.chunkLength
+
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			if resp.StatusCode != http.StatusNoContent {
+				t.Logf("Wanted status code:%d, got:%d", http.StatusNoContent, resp.StatusCode)
 				t.Fail()
 			}
-			if client.scktDriver.DialerTimeout != sample.wantedDialerTimeout {
-				t.Logf("Wanted DialierTimeout to be:%v , got:%v", sample.wantedDialerTimeout, client.scktDriver.DialerTimeout)
+
+			if sizes := <-chunkSizes; !reflect.DeepEqual(sizes, sample.wantedChunks) {
+				t.Logf("Wanted the server to see the chunks: %v, got: %v", sample.wantedChunks, sizes)
 				t.Fail()
 			}
-			if client.scktDriver.ReadTimeout != sample.wantedReadTimeout {
-				t.Logf("Wanted ReadTimeout to be:%v , got:%v", sample.wantedReadTimeout, client.scktDriver.ReadTimeout)
+		}
+	})
+
+	t.Run("Client DoContext", func(t *testing.T) {
+
+		stall := make(chan struct{})
+		defer close(stall)
+
+		srv := newFakeICAPServer(t, func(req *fakeICAPRequest) string {
+			<-stall // never responding in time
+			return noContentResponse("TAG")
+		})
+		defer srv.close()
+
+		previewSrv := newFakeICAPServer(t, func(req *fakeICAPRequest) string { // the fake server sends 100 Continue for the preview
+			<-stall
+			return noContentResponse("TAG")
+		})
+		defer previewSrv.close()
+
+		silentLstnr, err := net.Listen("tcp", "127.0.0.1:0") // a server never reading what is sent
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		defer silentLstnr.Close()
+
+		go func() {
+			for {
+				conn, err := silentLstnr.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					<-stall
+					conn.Close()
+				}()
+			}
+		}()
+
+		type testSample struct {
+			name        string
+			url         string
+			body        io.Reader
+			preview     int
+			cancelAfter time.Duration
+			timeout     time.Duration
+			wantedErr   error
+		}
+
+		sampleTable := []testSample{
+			{
+				name:        "cancel while reading",
+				url:         srv.url("/respmod"),
+				body:        strings.NewReader("Hello World"),
+				preview:     -1,
+				cancelAfter: 50 * time.Millisecond,
+				wantedErr:   context.Canceled,
+			},
+			{
+				name:      "deadline while reading",
+				url:       srv.url("/respmod"),
+				body:      strings.NewReader("Hello World"),
+				preview:   -1,
+				timeout:   50 * time.Millisecond,
+				wantedErr: context.DeadlineExceeded,
+			},
+			{
+				name:        "cancel while writing",
+				url:         "icap://" + silentLstnr.Addr().String() + "/respmod",
+				body:        io.LimitReader(zeroReader{}, 1<<30),
+				preview:     -1,
+				cancelAfter: 50 * time.Millisecond,
+				wantedErr:   context.Canceled,
+			},
+			{
+				name:        "cancel after the preview",
+				url:         previewSrv.url("/respmod"),
+				body:        strings.NewReader("Hello World"),
+				preview:     4,
+				cancelAfter: 50 * time.Millisecond,
+				wantedErr:   context.Canceled,
+			},
+		}
+
+		client := &Client{Timeout: 10 * time.Second}
+		defer client.CloseIdleConnections()
+
+		for _, sample := range sampleTable {
+			httpResp := &http.Response{
+				Status:     "200 OK",
+				StatusCode: http.StatusOK,
+				Proto:      "HTTP/1.1",
+				ProtoMajor: 1,
+				ProtoMinor: 1,
+				Header:     http.Header{},
+				Body:       ioutil.NopCloser(sample.body),
+			}
+
+			req, err := NewRequest(MethodRESPMOD, sample.url, nil, httpResp)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			if sample.preview >= 0 {
+				if err := req.SetPreview(sample.preview); err != nil {
+					t.Fatal(err.Error())
+				}
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			if sample.timeout > 0 {
+				ctx, cancel = context.WithTimeout(context.Background(), sample.timeout)
+			}
+
+			if sample.cancelAfter > 0 {
+				time.AfterFunc(sample.cancelAfter, cancel)
+			}
+
+			start := time.Now()
+
+			_, err = client.DoContext(ctx, req)
+			cancel()
+
+			if !errors.Is(err, sample.wantedErr) {
+				t.Logf("%s: wanted error:%v, got:%v", sample.name, sample.wantedErr, err)
 				t.Fail()
 			}
-			if client.scktDriver.WriteTimeout != sample.wantedWriteTimeout {
-				t.Logf("Wanted WriteTimeout to be:%v , got:%v", sample.wantedWriteTimeout, client.scktDriver.WriteTimeout)
+
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Logf("%s: wanted the call to be aborted right away, took:%s", sample.name, elapsed)
 				t.Fail()
 			}
+		}
 
+		if n := len(previewSrv.received(MethodRESPMOD)); n != 1 {
+			t.Logf("Wanted the remaining body to be sent after the preview, got requests:%d", n)
+			t.Fail()
 		}
 	})
 
@@ -583,3 +1220,20 @@ func TestClient(t *testing.T) {
 		defer stopTestServer()
 	}
 }
+
+// failingReader fails every read
+type failingReader struct{}
+
+func (*failingReader) Read(p []byte) (int, error) {
+	return 0, errors.New("the body failed")
+}
+
+// zeroReader reads zeros endlessly
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
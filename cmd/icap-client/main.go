@@ -0,0 +1,308 @@
+// Command icap-client makes ICAP calls from the command line, to debug & try out ICAP services
+//
+//	icap-client [flags] options <icap-url>
+//	icap-client [flags] reqmod  <icap-url> <http-url>
+//	icap-client [flags] respmod <icap-url> [file, - or nothing for stdin]
+//
+// It prints a summary of the ICAP response, the encapsulated http message & the verdict, or the same in JSON with -json.
+// It exits with 0 if the content is allowed, 1 if the call fails, 2 for a wrong usage & 3 if the content is blocked.
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	ic "github.com/egirna/icap-client"
+)
+
+// the exit codes of the command
+const (
+	exitAllowed = 0
+	exitFailed  = 1
+	exitUsage   = 2
+	exitBlocked = 3
+)
+
+// errUsage is returned for a wrong usage of the command
+var errUsage = errors.New("wrong usage")
+
+// headerFlag is a flag given several times, one header each
+type headerFlag []string
+
+// String returns the headers given
+func (h *headerFlag) String() string {
+	return strings.Join(*h, ", ")
+}
+
+// Set adds a header, in the Name: value form
+func (h *headerFlag) Set(value string) error {
+	if !strings.Contains(value, ":") {
+		return fmt.Errorf("the header %q must be in the Name: value form", value)
+	}
+
+	*h = append(*h, value)
+	return nil
+}
+
+// config is what the flags tell
+type config struct {
+	preview     int
+	headers     headerFlag
+	allow       string
+	timeout     time.Duration
+	maxTime     time.Duration
+	method      string
+	data        string
+	filename    string
+	contentType string
+	output      string
+	json        bool
+	debug       bool
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run runs the command with the arguments & returns the exit code
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+
+	cfg := &config{}
+
+	fs := flag.NewFlagSet("icap-client", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.IntVar(&cfg.preview, "preview", -1, "the number of body bytes to preview, -1 to negotiate it with the OPTIONS of the service")
+	fs.Var(&cfg.headers, "H", "an ICAP header to add to the request, in the Name: value form, can be given several times")
+	fs.StringVar(&cfg.allow, "allow", "204", "the Allow header of the request, empty not to send it")
+	fs.DurationVar(&cfg.timeout, "timeout", 0, "the timeout of connecting, writing & reading (default: 15s)")
+	fs.DurationVar(&cfg.maxTime, "max-time", 0, "the time the entire call may take, 0 for no limit")
+	fs.StringVar(&cfg.method, "X", http.MethodGet, "the http method of the REQMOD request")
+	fs.StringVar(&cfg.data, "d", "", "the file to send as the body of the REQMOD request, - for stdin")
+	fs.StringVar(&cfg.filename, "filename", "", "the filename of the RESPMOD content (default: the name of the file)")
+	fs.StringVar(&cfg.contentType, "content-type", "", "the Content-Type of the RESPMOD content (default: told by the filename or sniffed)")
+	fs.StringVar(&cfg.output, "o", "", "the file to save the body adapted by the service in")
+	fs.BoolVar(&cfg.json, "json", false, "print the result in JSON")
+	fs.BoolVar(&cfg.debug, "debug", false, "print the debug logs of the client to stderr")
+	fs.Usage = func() {
+		fmt.Fprint(stderr, "usage:\n"+
+			"  icap-client [flags] options <icap-url>\n"+
+			"  icap-client [flags] reqmod  <icap-url> <http-url>\n"+
+			"  icap-client [flags] respmod <icap-url> [file, - or nothing for stdin]\n\nflags:\n")
+		fs.PrintDefaults()
+	}
+
+	positional, err := parseFlags(fs, args)
+
+	if err == flag.ErrHelp {
+		return exitAllowed
+	}
+
+	if err != nil {
+		return exitUsage
+	}
+
+	if len(positional) < 2 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	if cfg.debug {
+		ic.SetDebugMode(true)
+		ic.SetDebugOutput(stderr)
+	}
+
+	res, err := call(cfg, strings.ToUpper(positional[0]), positional[1], positional[2:], stdin)
+
+	if err == errUsage {
+		fs.Usage()
+		return exitUsage
+	}
+
+	if err != nil {
+		fmt.Fprintln(stderr, "icap-client:", err)
+		return exitFailed
+	}
+
+	if err := printResult(stdout, res, cfg.json); err != nil {
+		fmt.Fprintln(stderr, "icap-client:", err)
+		return exitFailed
+	}
+
+	switch {
+	case res.Error != "":
+		return exitFailed
+	case res.Verdict != nil && !res.Verdict.Allowed:
+		return exitBlocked
+	}
+
+	return exitAllowed
+}
+
+// parseFlags parses the flags given before & after the positional arguments, returning the positional arguments
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+
+	positional := []string{}
+
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// call makes the ICAP call the command tells & returns its result, the error is returned if the call is not made at all
+func call(cfg *config, method, icapURL string, args []string, stdin io.Reader) (*result, error) {
+
+	var httpReq *http.Request
+	var httpResp *http.Response
+	var err error
+
+	switch method {
+	case ic.MethodOPTIONS:
+		if len(args) != 0 {
+			return nil, errUsage
+		}
+	case ic.MethodREQMOD:
+		if len(args) != 1 {
+			return nil, errUsage
+		}
+		httpReq, err = newHTTPRequest(cfg, args[0], stdin)
+	case ic.MethodRESPMOD:
+		if len(args) > 1 {
+			return nil, errUsage
+		}
+		r, filename := stdin, cfg.filename
+		if len(args) == 1 && args[0] != "-" {
+			f, err := os.Open(args[0])
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+			r = f
+			if filename == "" {
+				filename = filepath.Base(args[0])
+			}
+		}
+		if httpResp, err = newHTTPResponse(cfg, filename, r); err == nil {
+			httpReq = httpResp.Request
+		}
+	default:
+		return nil, errUsage
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := ic.NewRequest(method, icapURL, httpReq, httpResp)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := setHeaders(cfg, req); err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+
+	if cfg.maxTime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.maxTime)
+		defer cancel()
+	}
+
+	req.SetContext(ctx)
+
+	client := &ic.Client{
+		Timeout:          cfg.timeout,
+		NegotiateOptions: cfg.preview < 0 && method != ic.MethodOPTIONS,
+	}
+	defer client.CloseIdleConnections()
+
+	resp, err := client.Do(req)
+
+	return newResult(cfg, method, icapURL, resp, err), nil
+}
+
+// setHeaders sets the ICAP headers of the request as the flags tell
+func setHeaders(cfg *config, req *ic.Request) error {
+
+	for _, hdr := range cfg.headers {
+		kv := strings.SplitN(hdr, ":", 2)
+		req.Header.Add(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+	}
+
+	if cfg.allow == "" {
+		req.Header[ic.AllowHeader] = nil // the key kept with no values, so that the default is not set either
+	} else {
+		req.Header.Set(ic.AllowHeader, cfg.allow)
+	}
+
+	if cfg.preview >= 0 && req.Method != ic.MethodOPTIONS {
+		return req.SetPreview(cfg.preview)
+	}
+
+	return nil
+}
+
+// newHTTPRequest builds the http request to REQMOD, with the body from the file given with -d if any
+func newHTTPRequest(cfg *config, rawURL string, stdin io.Reader) (*http.Request, error) {
+
+	u, err := url.Parse(rawURL)
+
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid http url: %q", rawURL)
+	}
+
+	var body []byte
+
+	switch cfg.data {
+	case "":
+	case "-":
+		body, err = ioutil.ReadAll(stdin)
+	default:
+		body, err = ioutil.ReadFile(cfg.data)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if body == nil {
+		return http.NewRequest(cfg.method, u.String(), nil)
+	}
+
+	return http.NewRequest(cfg.method, u.String(), bytes.NewReader(body))
+}
+
+// newHTTPResponse builds the http response to RESPMOD, with the content read from the reader as the body
+func newHTTPResponse(cfg *config, filename string, r io.Reader) (*http.Response, error) {
+
+	contentType := cfg.contentType
+
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(filename))
+	}
+
+	return ic.NewFileResponse(filename, contentType, r)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http/httputil"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// serveICAP serves the ICAP calls on the listener, a connection each, with OPTIONS advertising no preview & RESPMOD
+// finding EICAR in the bodies that have it, the services other than /avscan are not found
+func serveICAP(lstnr net.Listener) {
+	for {
+		conn, err := lstnr.Accept()
+		if err != nil {
+			return
+		}
+
+		go func(conn net.Conn) {
+			defer conn.Close()
+
+			tp := textproto.NewReader(bufio.NewReader(conn))
+
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+
+			hdr, err := tp.ReadMIMEHeader()
+			if err != nil {
+				return
+			}
+
+			if !strings.Contains(line, "/avscan ") {
+				io.WriteString(conn, "ICAP/1.0 404 ICAP Service Not Found\r\nISTag: TAG\r\nEncapsulated: null-body=0\r\n\r\n")
+				return
+			}
+
+			if strings.HasPrefix(line, "OPTIONS") {
+				io.WriteString(conn, "ICAP/1.0 200 OK\r\nMethods: RESPMOD\r\nAllow: 204\r\nISTag: TAG\r\n"+
+					"Encapsulated: null-body=0\r\n\r\n")
+				return
+			}
+
+			encapsulated := hdr.Get("Encapsulated")
+			body := []byte{}
+
+			if i := strings.LastIndex(encapsulated, "res-body="); i >= 0 {
+				offset, _ := strconv.Atoi(encapsulated[i+len("res-body="):])
+				if _, err := io.ReadFull(tp.R, make([]byte, offset)); err != nil {
+					return
+				}
+				body, _ = ioutil.ReadAll(httputil.NewChunkedReader(tp.R))
+			}
+
+			if !bytes.Contains(body, []byte("EICAR")) {
+				io.WriteString(conn, "ICAP/1.0 204 No Content\r\nISTag: TAG\r\nEncapsulated: null-body=0\r\n\r\n")
+				return
+			}
+
+			resHdr := "HTTP/1.1 403 Forbidden\r\nContent-Type: text/plain\r\n\r\n"
+			fmt.Fprintf(conn, "ICAP/1.0 200 OK\r\nISTag: TAG\r\nX-Infection-Found: Type=0; Resolution=2; Threat=EICAR-Test-File;\r\n"+
+				"Encapsulated: res-hdr=0, res-body=%d\r\n\r\n%s7\r\nblocked\r\n0\r\n\r\n", len(resHdr), resHdr)
+		}(conn)
+	}
+}
+
+func TestRun(t *testing.T) {
+
+	lstnr, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer lstnr.Close()
+
+	go serveICAP(lstnr)
+
+	serviceURL := "icap://" + lstnr.Addr().String() + "/avscan"
+
+	dir, err := ioutil.TempDir("", "icap-client")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	eicar := filepath.Join(dir, "eicar.com")
+	if err := ioutil.WriteFile(eicar, []byte("X5O!P%@AP[4\\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*"), 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	down, err := net.Listen("tcp", "127.0.0.1:0") // a service down
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	down.Close()
+
+	t.Run("run", func(t *testing.T) {
+
+		type testSample struct {
+			args         []string
+			stdin        string
+			wantedCode   int
+			wantedOutput []string
+		}
+
+		sampleTable := []testSample{
+			{
+				args:         []string{"options", serviceURL},
+				wantedCode:   exitAllowed,
+				wantedOutput: []string{"ICAP/1.0 200 OK", "Methods: RESPMOD", "Allow 204: true"},
+			},
+			{
+				args:         []string{"options", "icap://" + lstnr.Addr().String() + "/missing"},
+				wantedCode:   exitFailed,
+				wantedOutput: []string{"ICAP/1.0 404 ICAP Service Not Found", "the ICAP service responded with 404"},
+			},
+			{
+				args:         []string{"respmod", serviceURL},
+				stdin:        "Hello World",
+				wantedCode:   exitAllowed,
+				wantedOutput: []string{"ICAP/1.0 204 No Content", "Verdict: clean"},
+			},
+			{
+				args:         []string{"-preview", "9223372036854775807", "respmod", serviceURL},
+				stdin:        "Hello World",
+				wantedCode:   exitAllowed,
+				wantedOutput: []string{"ICAP/1.0 204 No Content", "Verdict: clean"},
+			},
+			{
+				args:         []string{"-H", "X-Client-IP: 10.0.0.1", "respmod", serviceURL, eicar, "-o", filepath.Join(dir, "out")},
+				wantedCode:   exitBlocked,
+				wantedOutput: []string{"HTTP/1.1 403 Forbidden", "Verdict: infected: EICAR-Test-File", "(7 bytes)"},
+			},
+			{
+				args:         []string{"reqmod", "icap://" + down.Addr().String() + "/avscan", "http://localhost/"},
+				wantedCode:   exitFailed,
+				wantedOutput: []string{"REQMOD icap://" + down.Addr().String() + "/avscan failed", "Verdict: error"},
+			},
+			{
+				args:       []string{"scan", serviceURL},
+				wantedCode: exitUsage,
+			},
+			{
+				args:       []string{"reqmod", serviceURL},
+				wantedCode: exitUsage,
+			},
+		}
+
+		for _, sample := range sampleTable {
+			stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+
+			if code := run(sample.args, strings.NewReader(sample.stdin), stdout, stderr); code != sample.wantedCode {
+				t.Logf("Wanted the exit code %d for %v, got:%d, %s", sample.wantedCode, sample.args, code, stderr.String())
+				t.Fail()
+				continue
+			}
+
+			for _, out := range sample.wantedOutput {
+				if !strings.Contains(stdout.String(), out) {
+					t.Logf("Wanted the output for %v to have %q, got:%q", sample.args, out, stdout.String())
+					t.Fail()
+				}
+			}
+		}
+
+		if b, err := ioutil.ReadFile(filepath.Join(dir, "out")); err != nil || string(b) != "blocked" {
+			t.Logf("Wanted the adapted body saved, got:%q & %v", string(b), err)
+			t.Fail()
+		}
+	})
+
+	t.Run("run with json", func(t *testing.T) {
+
+		stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+
+		if code := run([]string{"-json", "respmod", serviceURL, eicar}, nil, stdout, stderr); code != exitBlocked {
+			t.Fatalf("Wanted the exit code %d, got:%d, %s", exitBlocked, code, stderr.String())
+		}
+
+		res := &result{}
+		if err := json.Unmarshal(stdout.Bytes(), res); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if res.StatusCode != 200 || res.Headers.Get("Istag") != "TAG" || res.Verdict == nil || res.Verdict.Status != "infected" ||
+			res.Verdict.Allowed || res.HTTPStatus != "HTTP/1.1 403 Forbidden" {
+			t.Logf("Wanted the result of the infected file, got:%s", stdout.String())
+			t.Fail()
+		}
+	})
+
+}
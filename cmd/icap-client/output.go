@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	ic "github.com/egirna/icap-client"
+)
+
+// result is the outcome of the call, as printed
+type result struct {
+	Method      string       `json:"method"`
+	URL         string       `json:"url"`
+	StatusCode  int          `json:"status_code,omitempty"`
+	Status      string       `json:"status,omitempty"`
+	Headers     http.Header  `json:"headers,omitempty"`
+	Options     *options     `json:"options,omitempty"`
+	HTTPStatus  string       `json:"http_status,omitempty"` // the request line or the status line of the encapsulated http message
+	HTTPHeaders http.Header  `json:"http_headers,omitempty"`
+	Verdict     *verdictInfo `json:"verdict,omitempty"`
+	BodyFile    string       `json:"body_file,omitempty"` // the file the adapted body is saved in
+	BodySize    int64        `json:"body_size,omitempty"`
+	Error       string       `json:"error,omitempty"`
+}
+
+// options is the options of the service, as printed
+type options struct {
+	Methods          []string `json:"methods"`
+	Preview          int      `json:"preview"`
+	Allow204         bool     `json:"allow_204"`
+	TransferPreview  []string `json:"transfer_preview,omitempty"`
+	TransferIgnore   []string `json:"transfer_ignore,omitempty"`
+	TransferComplete []string `json:"transfer_complete,omitempty"`
+	TTL              string   `json:"ttl,omitempty"`
+	MaxConnections   int      `json:"max_connections,omitempty"`
+	ISTag            string   `json:"istag,omitempty"`
+	Service          string   `json:"service,omitempty"`
+	ServiceID        string   `json:"service_id,omitempty"`
+}
+
+// verdictInfo is the verdict of the call, as printed
+type verdictInfo struct {
+	Status  string   `json:"status"`
+	Allowed bool     `json:"allowed"`
+	Threats []string `json:"threats,omitempty"`
+	Reason  string   `json:"reason,omitempty"`
+}
+
+// newResult returns the result of the call, saving the adapted body in the output file if one is given, the errors of
+// the call & of saving the body are kept in the result
+func newResult(cfg *config, method, icapURL string, resp *ic.Response, err error) *result {
+
+	res := &result{Method: method, URL: icapURL}
+
+	if method != ic.MethodOPTIONS {
+		v := ic.DefaultClassifier.Classify(resp, err)
+		res.Verdict = &verdictInfo{Status: v.Status.String(), Allowed: v.Allowed(), Threats: v.Threats, Reason: v.Reason}
+	}
+
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+
+	res.StatusCode, res.Status, res.Headers = resp.StatusCode, resp.Status, resp.Header
+
+	var body io.ReadCloser
+
+	switch {
+	case method == ic.MethodOPTIONS:
+		if resp.StatusCode != http.StatusOK {
+			res.Error = fmt.Sprintf("the ICAP service responded with %d %s", resp.StatusCode, resp.Status)
+			return res
+		}
+		opts, err := resp.Options()
+		if err != nil {
+			res.Error = err.Error()
+			return res
+		}
+		res.Options = newOptions(opts)
+	case resp.ContentResponse != nil:
+		res.HTTPStatus = resp.ContentResponse.Proto + " " + resp.ContentResponse.Status
+		res.HTTPHeaders = resp.ContentResponse.Header
+		body = resp.ContentResponse.Body
+	case resp.ContentRequest != nil:
+		res.HTTPStatus = resp.ContentRequest.Method + " " + resp.ContentRequest.URL.String() + " " + resp.ContentRequest.Proto
+		res.HTTPHeaders = resp.ContentRequest.Header
+		body = resp.ContentRequest.Body
+	}
+
+	if body == nil || body == http.NoBody {
+		return res
+	}
+
+	defer body.Close()
+
+	if cfg.output == "" {
+		return res
+	}
+
+	f, err := os.Create(cfg.output)
+
+	if err != nil {
+		res.Error = "saving the body: " + err.Error()
+		return res
+	}
+
+	defer f.Close()
+
+	if res.BodySize, err = io.Copy(f, body); err != nil {
+		res.Error = "saving the body: " + err.Error()
+		return res
+	}
+
+	res.BodyFile = cfg.output
+
+	return res
+}
+
+// newOptions returns the options of the service as printed
+func newOptions(opts *ic.ServiceOptions) *options {
+
+	methods := []string{}
+	for method := range opts.Methods {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+
+	o := &options{
+		Methods:          methods,
+		Preview:          opts.Preview,
+		Allow204:         opts.Allow204,
+		TransferPreview:  opts.TransferPreview,
+		TransferIgnore:   opts.TransferIgnore,
+		TransferComplete: opts.TransferComplete,
+		MaxConnections:   opts.MaxConnections,
+		ISTag:            opts.ISTag,
+		Service:          opts.Service,
+		ServiceID:        opts.ServiceID,
+	}
+
+	if opts.TTL > 0 {
+		o.TTL = opts.TTL.Round(time.Second).String()
+	}
+
+	return o
+}
+
+// printResult prints the result in JSON or as a summary for humans
+func printResult(w io.Writer, res *result, asJSON bool) error {
+
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(res)
+	}
+
+	b := &strings.Builder{}
+
+	if res.StatusCode != 0 {
+		fmt.Fprintf(b, "%s %d %s\n", ic.ICAPVersion, res.StatusCode, res.Status)
+		writeHeader(b, res.Headers)
+	}
+
+	if o := res.Options; o != nil {
+		fmt.Fprintf(b, "\nMethods: %s\nPreview: %d\nAllow 204: %v\n", strings.Join(o.Methods, ", "), o.Preview, o.Allow204)
+		if len(o.TransferPreview)+len(o.TransferIgnore)+len(o.TransferComplete) > 0 {
+			fmt.Fprintf(b, "Transfer: preview %s, ignore %s, complete %s\n", strings.Join(o.TransferPreview, ","),
+				strings.Join(o.TransferIgnore, ","), strings.Join(o.TransferComplete, ","))
+		}
+		if o.TTL != "" {
+			fmt.Fprintf(b, "TTL: %s\n", o.TTL)
+		}
+	}
+
+	if res.HTTPStatus != "" {
+		fmt.Fprintf(b, "\n%s\n", res.HTTPStatus)
+		writeHeader(b, res.HTTPHeaders)
+	}
+
+	if v := res.Verdict; v != nil {
+		fmt.Fprintf(b, "\nVerdict: %s", v.Status)
+		if len(v.Threats) > 0 {
+			fmt.Fprintf(b, ": %s", strings.Join(v.Threats, ", "))
+		}
+		if v.Reason != "" {
+			fmt.Fprintf(b, " (%s)", v.Reason)
+		}
+		b.WriteString("\n")
+	}
+
+	if res.BodyFile != "" {
+		fmt.Fprintf(b, "Body saved in %s (%d bytes)\n", res.BodyFile, res.BodySize)
+	}
+
+	if res.Error != "" {
+		fmt.Fprintf(b, "\n%s %s failed: %s\n", res.Method, res.URL, res.Error)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeHeader writes the header sorted by the names
+func writeHeader(b *strings.Builder, hdr http.Header) {
+
+	names := make([]string, 0, len(hdr))
+	for name := range hdr {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, val := range hdr[name] {
+			fmt.Fprintf(b, "%s: %s\n", name, val)
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package icapclient
 
-import "time"
+import (
+	"math"
+	"time"
+)
 
 // the icap request methods
 const (
@@ -9,34 +12,43 @@ const (
 	MethodREQMOD  = "REQMOD"
 )
 
-// the error messages
-const (
-	ErrInvalidScheme       = "the url scheme must be icap://"
-	ErrMethodNotRegistered = "the requested method is not registered"
-	ErrInvalidHost         = "the requested host is invalid"
-	ErrConnectionNotOpen   = "no open connection to close"
-	ErrInvalidTCPMsg       = "invalid tcp message"
-	ErrREQMODWithNoReq     = "http request cannot be nil for method REQMOD"
-	ErrREQMODWithResp      = "http response must be nil for method REQMOD"
-	ErrRESPMODWithNoResp   = "http response cannot be nil for method RESPMOD"
-)
-
 // general constants required for the package
 const (
-	SchemeICAP                      = "icap"
-	ICAPVersion                     = "ICAP/1.0"
-	HTTPVersion                     = "HTTP/1.1"
-	SchemeHTTPReq                   = "http_request"
-	SchemeHTTPResp                  = "http_response"
-	CRLF                            = "\r\n"
-	DoubleCRLF                      = "\r\n\r\n"
-	LF                              = "\n"
-	bodyEndIndicator                = CRLF + "0" + CRLF
-	fullBodyEndIndicatorPreviewMode = "; ieof" + DoubleCRLF
-	icap100ContinueMsg              = "ICAP/1.0 100 Continue" + DoubleCRLF
-	icap204NoModsMsg                = "ICAP/1.0 204 No modifications"
-	defaultChunkLength              = 512
-	defaultTimeout                  = 15 * time.Second
+	SchemeICAP                  = "icap"
+	SchemeICAPS                 = "icaps"
+	ICAPVersion                 = "ICAP/1.0"
+	MaxPreviewSize              = 1 << 20 // the largest preview negotiated with a service, the larger ones advertised are cut down to it
+	HTTPVersion                 = "HTTP/1.1"
+	SchemeHTTPReq               = "http_request"
+	SchemeHTTPResp              = "http_response"
+	CRLF                        = "\r\n"
+	DoubleCRLF                  = "\r\n\r\n"
+	LF                          = "\n"
+	defaultChunkLength          = 512
+	streamBufferSize            = 32 * 1024
+	defaultTimeout              = 15 * time.Second
+	defaultICAPPort             = 1344
+	defaultICAPSPort            = 11344
+	defaultMaxIdleConnsPerHost  = 2
+	defaultIdleConnTimeout      = 90 * time.Second
+	defaultInitialBackoff       = 100 * time.Millisecond
+	defaultMaxBackoff           = 5 * time.Second
+	defaultBackoffMultiplier    = 2
+	defaultBackoffJitter        = 0.2
+	defaultMaxFails             = 3
+	defaultProbeInterval        = 10 * time.Second
+	ringPointsPerWeight         = 100
+	defaultFailureThreshold     = 5
+	defaultOpenTimeout          = 30 * time.Second
+	maxErrorBodySize            = 64 * 1024
+	violationLines              = 4   // the lines of a violation in X-Violations-Found
+	sniffLength                 = 512 // the number of bytes http.DetectContentType considers
+	defaultMaxUploadSize        = 32 << 20
+	defaultMaxMultipartParts    = 100
+	defaultMultipartConcurrency = 4
+	maxEncapsulatedHeaderSize   = 1 << 20            // the most the encapsulated http headers may take, as http.DefaultMaxHeaderBytes
+	maxOptionValue              = math.MaxInt32      // the largest Preview, Options-TTL & Max-Connections an OPTIONS response may tell
+	maxReplaySize               = MaxPreviewSize + 1 // the most of a body ScanTransport keeps to send on, a preview & the byte read ahead of it
 )
 
 // Common ICAP headers
@@ -54,4 +66,13 @@ const (
 	ServiceIDHeader        = "Service-ID"
 	TransferIgnoreHeader   = "Transfer-Ignore"
 	TransferCompleteHeader = "Transfer-Complete"
+	DateHeader             = "Date"
+)
+
+// the headers the ICAP servers of common vendors tell the threats found with
+const (
+	InfectionFoundHeader  = "X-Infection-Found"
+	VirusIDHeader         = "X-Virus-ID"
+	ViolationsFoundHeader = "X-Violations-Found"
+	BlockedReasonHeader   = "X-Blocked-Reason"
 )
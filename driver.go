@@ -1,22 +1,27 @@
 package icapclient
 
 import (
-	"bufio"
 	"context"
-	"errors"
-	"fmt"
+	"crypto/tls"
+	"net"
+	"strconv"
 	"strings"
 	"time"
 )
 
 // Driver os the one responsible for driving the transport layer operations
+//
+// A Driver drives a single connection at a time, so it is not safe for concurrent use.
+// When set with a Client, it only serves as the connection configuration, the client makes a copy of it for every call.
 type Driver struct {
 	Host          string
 	Port          int
 	DialerTimeout time.Duration
 	ReadTimeout   time.Duration
 	WriteTimeout  time.Duration
+	TLSConfig     *tls.Config // the TLS configuration for the ICAP over TLS(icaps) connections, nil means a plain tcp connection
 	tcp           *transport
+	pool          *connPool
 }
 
 // NewDriver is the factory function for Driver
@@ -30,35 +35,49 @@ func NewDriver(host string, port int) *Driver {
 // Connect fires up a tcp socket connection with the icap server
 func (d *Driver) Connect() error {
 
-	d.tcp = &transport{
-		network:      "tcp",
-		addr:         fmt.Sprintf("%s:%d", d.Host, d.Port),
-		timeout:      d.DialerTimeout,
-		readTimeout:  d.ReadTimeout,
-		writeTimeout: d.WriteTimeout,
-	}
+	d.tcp = d.newTransport()
 
 	return d.tcp.dial()
 }
 
 // ConnectWithContext connects to the server satisfying the context
 func (d *Driver) ConnectWithContext(ctx context.Context) error {
-	d.tcp = &transport{
+	d.tcp = d.newTransport()
+
+	return d.tcp.dialWithContext(ctx)
+}
+
+// newTransport prepares the transport layer for a connection with the icap server
+func (d *Driver) newTransport() *transport {
+	t := &transport{
 		network:      "tcp",
-		addr:         fmt.Sprintf("%s:%d", d.Host, d.Port),
+		addr:         net.JoinHostPort(d.Host, strconv.Itoa(d.Port)),
 		timeout:      d.DialerTimeout,
 		readTimeout:  d.ReadTimeout,
 		writeTimeout: d.WriteTimeout,
+		pool:         d.pool,
 	}
 
-	return d.tcp.dialWithContext(ctx)
+	if d.TLSConfig != nil {
+		t.tlsConfig = d.TLSConfig.Clone()
+		if t.tlsConfig.ServerName == "" { // verifying the server certificate against the host name & sending it as SNI by default
+			t.tlsConfig.ServerName = d.Host
+		}
+	}
+
+	return t
 }
 
-// Close closes the socket connection
+// Close closes the socket connection, if the body of the last response received is still being read,
+// the connection is closed once the body is read till the end or closed instead
 func (d *Driver) Close() error {
 	if d.tcp == nil {
 
-		return errors.New(ErrConnectionNotOpen)
+		return ErrConnectionNotOpen
+	}
+
+	if d.tcp.bodyOpen {
+		return nil
 	}
 
 	return d.tcp.close()
@@ -77,21 +96,28 @@ func (d *Driver) Send(data []byte) error {
 
 }
 
-// Receive returns the respone from the tcp socket connection
+// Receive returns the respone from the tcp socket connection, the encapsulated body of it is streamed right from the connection
 func (d *Driver) Receive() (*Response, error) {
 
-	msg, err := d.tcp.read()
+	resp, err := readResponse(d.tcp.reader(), d.tcp.bodyDone)
 
 	if err != nil {
+		d.tcp.keepAlive = false
 		return nil, err
 	}
 
-	resp, err := ReadResponse(bufio.NewReader(strings.NewReader(msg)))
+	if strings.EqualFold(resp.Header.Get("Connection"), "close") { // the server is going to close the connection, so it can't be reused
+		d.tcp.keepAlive = false
+	}
 
-	if err != nil {
-		return nil, err
+	if d.pool != nil {
+		if mc, err := strconv.Atoi(resp.Header.Get(MaxConnectionsHeader)); err == nil && mc > 0 {
+			d.pool.setMaxConns(d.tcp.poolKey(), mc)
+		}
 	}
 
+	d.tcp.bodyOpen = resp.body != nil
+
 	logDebug("The final *ic.Response from tcp messages...")
 	dumpDebug(resp)
 
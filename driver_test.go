@@ -9,6 +9,7 @@ import (
 func TestDriver(t *testing.T) {
 	if !testServerRunning() {
 		go startTestServer()
+		waitForTestServer()
 	}
 
 	t.Run("Driver Connect With Context", func(t *testing.T) {
@@ -0,0 +1,236 @@
+package icapclient
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+)
+
+// requestEncoder writes an ICAP request in its wire representation, the encapsulated body is streamed in chunks
+// right from its reader, so that it is never held in the memory as a whole no matter how large it is
+type requestEncoder struct {
+	w   *bufio.Writer
+	req *Request
+}
+
+// newRequestEncoder is the factory function for requestEncoder
+func newRequestEncoder(w io.Writer, req *Request) *requestEncoder {
+	return &requestEncoder{
+		w:   bufio.NewWriterSize(w, streamBufferSize),
+		req: req,
+	}
+}
+
+// encode writes the ICAP headers, the encapsulated http headers & then the body, only the preview portion of it if the preview is set
+func (e *requestEncoder) encode() error {
+
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+
+	if body := e.req.body(); body != nil {
+		var err error
+		if e.req.previewSet {
+			err = e.writePreview(body)
+		} else {
+			err = e.writeChunks(body)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return e.w.Flush()
+}
+
+// encodeRemaining writes the rest of the body which did not fit in the preview, after the server asked for it with 100 Continue
+func (e *requestEncoder) encodeRemaining() error {
+
+	if body := e.req.body(); body != nil {
+		if err := e.writeChunks(body); err != nil {
+			return err
+		}
+	}
+
+	return e.w.Flush()
+}
+
+// writeHeader writes the ICAP message block followed by the encapsulated http request & response headers
+func (e *requestEncoder) writeHeader() error {
+
+	httpReqHdr, httpRespHdr, err := e.req.httpHeaders()
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintf(e.w, "%s %s %s%s", e.req.Method, e.req.URL.String(), ICAPVersion, CRLF); err != nil {
+		return err
+	}
+
+	if err := e.req.Header.WriteSubset(e.w, map[string]bool{EncapsulatedHeader: true}); err != nil {
+		return err
+	}
+
+	e.req.sentHTTPHeader = httpRespHdr // kept to tell if the service sends the message back unchanged
+	if e.req.Method == MethodREQMOD {
+		e.req.sentHTTPHeader = httpReqHdr
+	}
+
+	encpVal := e.req.Header.Get(EncapsulatedHeader)
+	if encpVal == "" {
+		encpVal = encapsulatedHeaderValue(e.req.Method, len(httpReqHdr), len(httpRespHdr), e.req.body() != nil)
+	}
+
+	if _, err := fmt.Fprintf(e.w, "%s: %s%s%s", EncapsulatedHeader, encpVal, CRLF, CRLF); err != nil {
+		return err
+	}
+
+	if _, err := e.w.Write(httpReqHdr); err != nil {
+		return err
+	}
+
+	_, err = e.w.Write(httpRespHdr)
+
+	return err
+}
+
+// writePreview streams the preview portion of the body, indicating if the entire body fitted in it(ieof) or not
+//
+// A byte more than the preview is read to tell if the body goes on, it is put back in front of the rest of the body
+func (e *requestEncoder) writePreview(body io.ReadCloser) error {
+
+	cw := newChunkedWriter(e.w, e.req.chunkLength())
+
+	_, err := io.CopyN(cw, body, int64(e.req.PreviewBytes))
+
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	e.req.bodyFittedInPreview = err == io.EOF
+
+	if !e.req.bodyFittedInPreview {
+		lookahead := make([]byte, 1)
+
+		n, err := io.ReadFull(body, lookahead)
+
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		if n == 0 { // the body ended right at the end of the preview
+			e.req.bodyFittedInPreview = true
+		} else {
+			e.req.setBody(&readCloser{Reader: io.MultiReader(bytes.NewReader(lookahead), body), Closer: body})
+		}
+	}
+
+	if e.req.bodyFittedInPreview {
+		return cw.close(nil, ieofExtension)
+	}
+
+	return cw.close(nil)
+}
+
+// writeChunks streams the body in chunks of the chunk length of the request till the end of it
+func (e *requestEncoder) writeChunks(body io.Reader) error {
+
+	cw := newChunkedWriter(e.w, e.req.chunkLength())
+
+	if _, err := io.CopyBuffer(cw, body, make([]byte, streamBufferSize)); err != nil {
+		return err
+	}
+
+	return cw.close(nil)
+}
+
+// httpHeaders returns the wire representation of the encapsulated http request & response headers, without the bodies
+func (r *Request) httpHeaders() ([]byte, []byte, error) {
+
+	if r.Method == MethodOPTIONS { // the OPTIONS requests don't encapsulate any http message
+		return nil, nil, nil
+	}
+
+	var httpReqHdr, httpRespHdr []byte
+
+	if r.HTTPRequest != nil {
+		b, err := httputil.DumpRequestOut(r.HTTPRequest, false)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		httpReqStr := string(b)
+		replaceRequestURIWithActualURL(&httpReqStr, r.HTTPRequest.URL.EscapedPath(), r.HTTPRequest.URL.String())
+		httpReqHdr = []byte(httpReqStr)
+	}
+
+	if r.HTTPResponse != nil {
+		b, err := httputil.DumpResponse(r.HTTPResponse, false)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		httpRespHdr = b
+	}
+
+	return httpReqHdr, httpRespHdr, nil
+}
+
+// body returns the encapsulated http body of the request depending on the ICAP method, nil if there is none
+func (r *Request) body() io.ReadCloser {
+
+	var body io.ReadCloser
+
+	if r.Method == MethodREQMOD && r.HTTPRequest != nil {
+		body = r.HTTPRequest.Body
+	}
+
+	if r.Method == MethodRESPMOD && r.HTTPResponse != nil {
+		body = r.HTTPResponse.Body
+	}
+
+	if body == http.NoBody {
+		return nil
+	}
+
+	return body
+}
+
+// bodyLength returns the length of the encapsulated http body depending on the ICAP method, -1 if it is not known
+func (r *Request) bodyLength() int64 {
+
+	if r.Method == MethodREQMOD && r.HTTPRequest != nil && r.HTTPRequest.ContentLength > 0 { // 0 means unknown for a request with a body
+		return r.HTTPRequest.ContentLength
+	}
+
+	if r.Method == MethodRESPMOD && r.HTTPResponse != nil && r.HTTPResponse.ContentLength >= 0 {
+		return r.HTTPResponse.ContentLength
+	}
+
+	return -1
+}
+
+// setBody replaces the encapsulated http body of the request depending on the ICAP method
+func (r *Request) setBody(body io.ReadCloser) {
+
+	if r.Method == MethodREQMOD && r.HTTPRequest != nil {
+		r.HTTPRequest.Body = body
+	}
+
+	if r.Method == MethodRESPMOD && r.HTTPResponse != nil {
+		r.HTTPResponse.Body = body
+	}
+}
+
+// readCloser puts together a reader & the closer of the body it reads from
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
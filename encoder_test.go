@@ -0,0 +1,219 @@
+package icapclient
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// notifyingWriter signals every time something is written to it
+type notifyingWriter struct {
+	buf     bytes.Buffer
+	written chan struct{}
+}
+
+func (w *notifyingWriter) Write(p []byte) (int, error) {
+	n, err := w.buf.Write(p)
+	select {
+	case w.written <- struct{}{}:
+	default:
+	}
+	return n, err
+}
+
+func TestEncoder(t *testing.T) {
+
+	t.Run("Stream body before reading it entirely", func(t *testing.T) {
+		pr, pw := io.Pipe()
+
+		httpReq, _ := http.NewRequest(http.MethodPost, "http://someurl.com/upload", pr)
+		httpReq.ContentLength = -1
+
+		req, err := NewRequest(MethodREQMOD, "icap://localhost:1344/reqmod", httpReq, nil)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		w := &notifyingWriter{written: make(chan struct{}, 1)}
+		done := make(chan error, 1)
+
+		go func() {
+			done <- newRequestEncoder(w, req).encode()
+		}()
+
+		data := bytes.Repeat([]byte("a"), 2*streamBufferSize)
+		if _, err := pw.Write(data); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		select {
+		case <-w.written: // the body is being streamed while its reader is still open
+		case <-time.After(time.Second):
+			t.Fatal("Wanted the body to be written before the end of it, got nothing written")
+		}
+
+		pw.Close()
+
+		if err := <-done; err != nil {
+			t.Fatal(err.Error())
+		}
+
+		_, bodyStr, ok := strings.Cut(w.buf.String(), DoubleCRLF+"POST")
+		if !ok {
+			t.Fatal("Wanted the encapsulated http request in the message")
+		}
+
+		_, chunked, ok := strings.Cut(bodyStr, DoubleCRLF)
+		if !ok {
+			t.Fatal("Wanted the encapsulated body in the message")
+		}
+
+		body, err := ioutil.ReadAll(httputil.NewChunkedReader(bufio.NewReader(strings.NewReader(chunked))))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if !bytes.Equal(body, data) {
+			t.Logf("Wanted the body of %d bytes to be decoded back, got %d bytes", len(data), len(body))
+			t.Fail()
+		}
+	})
+
+	t.Run("Preview & remaining body", func(t *testing.T) {
+		bodyStr := "Hello World! Bye Bye World!"
+		httpReq, _ := http.NewRequest(http.MethodGet, "http://someurl.com", nil)
+		httpResp := &http.Response{
+			Status:        "200 OK",
+			StatusCode:    http.StatusOK,
+			Proto:         "HTTP/1.0",
+			ProtoMajor:    1,
+			ProtoMinor:    0,
+			Header:        http.Header{},
+			ContentLength: int64(len(bodyStr)),
+			Body:          ioutil.NopCloser(strings.NewReader(bodyStr)),
+		}
+
+		req, err := NewRequest(MethodRESPMOD, "icap://localhost:1344/respmod", httpReq, httpResp)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if err := req.SetPreview(5); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		buf := &bytes.Buffer{}
+
+		if err := newRequestEncoder(buf, req).encode(); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if wanted := "5\r\nHello\r\n0\r\n\r\n"; !strings.HasSuffix(buf.String(), wanted) {
+			t.Logf("Wanted the request to end with the preview: %q, got: %q", wanted, buf.String())
+			t.Fail()
+		}
+
+		buf.Reset()
+
+		if err := newRequestEncoder(buf, req).encodeRemaining(); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if wanted := "16\r\n World! Bye Bye World!\r\n0\r\n\r\n"; buf.String() != wanted {
+			t.Logf("Wanted the remaining body: %q, got: %q", wanted, buf.String())
+			t.Fail()
+		}
+	})
+
+	t.Run("Preview of a body of unknown length", func(t *testing.T) {
+
+		type testSample struct {
+			bodyStr         string
+			preview         int
+			wantedPreview   string
+			wantedEncoded   string
+			wantedFitted    bool
+			wantedRemaining string
+		}
+
+		sampleTable := []testSample{
+			{
+				bodyStr:         "Hello!",
+				preview:         math.MaxInt, // nothing is allocated for the preview, the body is streamed
+				wantedPreview:   strconv.Itoa(math.MaxInt),
+				wantedEncoded:   "6\r\nHello!\r\n0; ieof\r\n\r\n",
+				wantedFitted:    true,
+				wantedRemaining: "",
+			},
+			{
+				bodyStr:         "Hello",
+				preview:         5,
+				wantedPreview:   "5",
+				wantedEncoded:   "5\r\nHello\r\n0; ieof\r\n\r\n",
+				wantedFitted:    true,
+				wantedRemaining: "",
+			},
+			{
+				bodyStr:         "Hello World",
+				preview:         5,
+				wantedPreview:   "5",
+				wantedEncoded:   "5\r\nHello\r\n0\r\n\r\n",
+				wantedFitted:    false,
+				wantedRemaining: "6\r\n World\r\n0\r\n\r\n",
+			},
+		}
+
+		for _, sample := range sampleTable {
+			httpReq, _ := http.NewRequest(http.MethodPost, "http://someurl.com/upload", ioutil.NopCloser(strings.NewReader(sample.bodyStr)))
+
+			req, err := NewRequest(MethodREQMOD, "icap://localhost:1344/reqmod", httpReq, nil)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			if err := req.SetPreview(sample.preview); err != nil {
+				t.Fatal(err.Error())
+			}
+
+			buf := &bytes.Buffer{}
+
+			if err := newRequestEncoder(buf, req).encode(); err != nil {
+				t.Fatal(err.Error())
+			}
+
+			if req.Header.Get(PreviewHeader) != sample.wantedPreview {
+				t.Logf("Wanted the Preview header:%s, got:%s", sample.wantedPreview, req.Header.Get(PreviewHeader))
+				t.Fail()
+			}
+
+			if !strings.HasSuffix(buf.String(), sample.wantedEncoded) || req.bodyFittedInPreview != sample.wantedFitted {
+				t.Logf("Wanted the request to end with:%q & the body fitted in preview as:%v, got:%q & %v", sample.wantedEncoded,
+					sample.wantedFitted, buf.String(), req.bodyFittedInPreview)
+				t.Fail()
+			}
+
+			if sample.wantedFitted {
+				continue
+			}
+
+			buf.Reset()
+
+			if err := newRequestEncoder(buf, req).encodeRemaining(); err != nil {
+				t.Fatal(err.Error())
+			}
+
+			if buf.String() != sample.wantedRemaining {
+				t.Logf("Wanted the remaining body:%q, got:%q", sample.wantedRemaining, buf.String())
+				t.Fail()
+			}
+		}
+	})
+}
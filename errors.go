@@ -0,0 +1,237 @@
+package icapclient
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+)
+
+// the errors returned by the package, check the errors returned against them with errors.Is
+var (
+	ErrInvalidScheme         = errors.New("the url scheme must be icap:// or icaps://")
+	ErrMethodNotRegistered   = errors.New("the requested method is not registered")
+	ErrInvalidHost           = errors.New("the requested host is invalid")
+	ErrConnectionNotOpen     = errors.New("no open connection to close")
+	ErrInvalidTCPMsg         = errors.New("invalid tcp message")
+	ErrInvalidEncapsulated   = errors.New("invalid Encapsulated header")
+	ErrBodyClosed            = errors.New("read on closed body")
+	ErrInvalidChunkSize      = errors.New("invalid chunk size")
+	ErrInvalidChunkExtension = errors.New("invalid chunk extension")
+	ErrInvalidChunkEnd       = errors.New("missing CRLF after the chunk data")
+	ErrChunkLineTooLong      = errors.New("chunk line too long")
+	ErrMethodNotAllowed      = errors.New("the ICAP service does not support the method")
+	ErrInvalidOptionsHeader  = errors.New("invalid OPTIONS header")
+	ErrInvalidThreatHeader   = errors.New("invalid threat header")
+	ErrREQMODWithNoReq       = errors.New("http request cannot be nil for method REQMOD")
+	ErrREQMODWithResp        = errors.New("http response must be nil for method REQMOD")
+	ErrRESPMODWithNoResp     = errors.New("http response cannot be nil for method RESPMOD")
+	ErrMultipartTooLarge     = errors.New("the multipart upload goes over the limits") // every *MultipartError is one
+	ErrNotMultipart          = errors.New("the request body is not multipart/form-data")
+	ErrTimeout               = errors.New("timeout") // every *TimeoutError is one
+	ErrNoHealthyServer       = errors.New("none of the ICAP servers of the pool is healthy")
+	ErrBlocked               = errors.New("the content is blocked by the ICAP service")      // every *BlockedError is one
+	ErrCircuitOpen           = errors.New("the circuit breaker of the ICAP service is open") // every *BreakerError is one
+	ErrReplayTooLarge        = errors.New("the body read for the scan is too large to be sent on unmodified")
+)
+
+// the phases of a call a timeout can happen in
+const (
+	PhaseDial            = "dial"
+	PhaseWrite           = "write"
+	PhaseRead            = "read"
+	PhasePreviewContinue = "preview continue" // waiting for the server to ask for the rest of the body after the preview
+)
+
+// ProtocolError is returned when the server sends something not conforming to the ICAP protocol
+type ProtocolError struct {
+	Line string // the raw line at fault, for example: the status line or the chunk size line
+	Err  error  // what is wrong with it, for example: ErrInvalidTCPMsg
+}
+
+// Error returns the description of the error
+func (e *ProtocolError) Error() string {
+	return e.Err.Error() + ": " + strconv.Quote(e.Line)
+}
+
+// Unwrap returns what is wrong with the line
+func (e *ProtocolError) Unwrap() error {
+	return e.Err
+}
+
+// EncapsulatedError is returned when the Encapsulated header of an ICAP response is malformed,
+// the encapsulated message can't be cut out of the response without it
+type EncapsulatedError struct {
+	Value  string // the Encapsulated header value as received
+	Entity string // the entity at fault, for example: res-body=abc, empty if the header as a whole is at fault
+	Reason string // what is wrong with it
+}
+
+// Error returns the description of the error
+func (e *EncapsulatedError) Error() string {
+
+	msg := ErrInvalidEncapsulated.Error() + ": " + e.Reason
+
+	if e.Entity != "" {
+		msg += ": " + e.Entity
+	}
+
+	return msg + " (" + e.Value + ")"
+}
+
+// Unwrap returns ErrInvalidEncapsulated
+func (e *EncapsulatedError) Unwrap() error {
+	return ErrInvalidEncapsulated
+}
+
+// ServiceError is returned when the ICAP service responds with an error status
+type ServiceError struct {
+	StatusCode int
+	Status     string
+	ISTag      string
+	Service    string // the Service header, telling which service & server responded
+	Body       string // the text of the encapsulated body if the server sent one, for example: an error page
+}
+
+// newServiceError makes a ServiceError out of the response, reading & closing the encapsulated body if there is one
+func newServiceError(resp *Response) *ServiceError {
+
+	e := &ServiceError{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		ISTag:      resp.Header.Get(ISTagHeader),
+		Service:    resp.Header.Get(ServiceHeader),
+	}
+
+	var body io.ReadCloser
+
+	if resp.ContentResponse != nil {
+		body = resp.ContentResponse.Body
+	} else if resp.ContentRequest != nil {
+		body = resp.ContentRequest.Body
+	}
+
+	if body != nil {
+		b, _ := ioutil.ReadAll(io.LimitReader(body, maxErrorBodySize)) // the body is only there to describe the error, so it is fine if it's cut short
+		body.Close()
+		e.Body = string(b)
+	}
+
+	return e
+}
+
+// Error returns the description of the error
+func (e *ServiceError) Error() string {
+	return fmt.Sprintf("the ICAP service responded with %d %s", e.StatusCode, e.Status)
+}
+
+// Retryable tells if the same call might succeed if made again, the statuses telling the server is overloaded, timed out
+// or failed on its side are retryable(408, 429, 500, 502, 503 & 504), while 405 Method Not Allowed & the other errors of
+// the request are not
+func (e *ServiceError) Retryable() bool {
+	return retryableStatus(e.StatusCode)
+}
+
+// TimeoutError is returned when a phase of the call takes longer than the timeout or the context deadline allow
+type TimeoutError struct {
+	Phase string // the phase timed out, one of PhaseDial, PhaseWrite, PhaseRead & PhasePreviewContinue
+	Err   error  // the error of the operation timed out, context.DeadlineExceeded if the context deadline is the reason
+}
+
+// Error returns the description of the error
+func (e *TimeoutError) Error() string {
+	return "the ICAP " + e.Phase + " timed out: " + e.Err.Error()
+}
+
+// Unwrap returns the error of the operation timed out
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// Is tells if the target is ErrTimeout
+func (e *TimeoutError) Is(target error) bool {
+	return target == ErrTimeout
+}
+
+// Timeout is always true, so that it is a net.Error timing out as well
+func (e *TimeoutError) Timeout() bool {
+	return true
+}
+
+// Temporary is always true, as net.Error requires
+func (e *TimeoutError) Temporary() bool {
+	return true
+}
+
+// BreakerError is returned when the circuit breaker of the ICAP service doesn't let the call through, with the
+// fail-closed policy
+type BreakerError struct {
+	Service string       // the service the call is for
+	State   BreakerState // the state of the breaker, open or half-open with all of the trial calls in progress
+}
+
+// Error returns the description of the error
+func (e *BreakerError) Error() string {
+	return ErrCircuitOpen.Error() + ": " + e.Service
+}
+
+// Is tells if the target is ErrCircuitOpen
+func (e *BreakerError) Is(target error) bool {
+	return target == ErrCircuitOpen
+}
+
+// BlockedError is returned when the verdict of the ICAP service doesn't allow the content through
+type BlockedError struct {
+	Verdict *Verdict
+}
+
+// Error returns the description of the error
+func (e *BlockedError) Error() string {
+	return ErrBlocked.Error() + ": " + e.Verdict.String()
+}
+
+// Is tells if the target is ErrBlocked
+func (e *BlockedError) Is(target error) bool {
+	return target == ErrBlocked
+}
+
+// MultipartError is returned when a multipart upload goes over the limits of ScanMultipart
+type MultipartError struct {
+	Part   string // the name of the part going over the size limit, empty for the limits of the whole upload
+	Limit  int64  // the limit gone over, the number of the parts or the size in bytes
+	Reason string // which limit is gone over
+}
+
+// Error returns the description of the error
+func (e *MultipartError) Error() string {
+
+	msg := ErrMultipartTooLarge.Error() + ": " + e.Reason + " " + strconv.FormatInt(e.Limit, 10)
+
+	if e.Part != "" {
+		msg += ": " + e.Part
+	}
+
+	return msg
+}
+
+// Is tells if the target is ErrMultipartTooLarge
+func (e *MultipartError) Is(target error) bool {
+	return target == ErrMultipartTooLarge
+}
+
+// ValidationError is returned when a field of the request is not valid for the call
+type ValidationError struct {
+	Field string // the field of the Request at fault, for example: URL
+	Err   error  // what is wrong with it, for example: ErrInvalidScheme
+}
+
+// Error returns the description of the error
+func (e *ValidationError) Error() string {
+	return e.Field + ": " + e.Err.Error()
+}
+
+// Unwrap returns what is wrong with the field
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,351 @@
+package icapclient
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestErrors(t *testing.T) {
+
+	t.Run("ProtocolError", func(t *testing.T) {
+
+		type testSample struct {
+			respStr    string
+			wantedErr  error
+			wantedLine string
+		}
+
+		sampleTable := []testSample{
+			{
+				respStr:    "HTTP/1.1 200 OK\r\n\r\n",
+				wantedErr:  ErrInvalidTCPMsg,
+				wantedLine: "HTTP/1.1 200 OK",
+			},
+			{
+				respStr: "ICAP/1.0 200 OK\r\n" +
+					"Encapsulated: res-hdr=0, res-body=19\r\n\r\n" +
+					"HTTP/1.1 200 OK\r\n\r\n" +
+					"0x5\r\nHello\r\n0\r\n\r\n",
+				wantedErr:  ErrInvalidChunkSize,
+				wantedLine: "0x5",
+			},
+		}
+
+		for _, sample := range sampleTable {
+			resp, err := ReadResponse(bufio.NewReader(strings.NewReader(sample.respStr)))
+			if err == nil {
+				_, err = ioutil.ReadAll(resp.ContentResponse.Body)
+			}
+
+			var protoErr *ProtocolError
+
+			if !errors.Is(err, sample.wantedErr) || !errors.As(err, &protoErr) || protoErr.Line != sample.wantedLine {
+				t.Logf("Wanted error:%v with the line:%q, got:%v", sample.wantedErr, sample.wantedLine, err)
+				t.Fail()
+			}
+		}
+
+		_, err := ReadResponse(bufio.NewReader(strings.NewReader("ICAP/1.0 200 OK\r\nEncapsulated: res-body=abc\r\n\r\n")))
+
+		var encErr *EncapsulatedError
+
+		if !errors.Is(err, ErrInvalidEncapsulated) || !errors.As(err, &encErr) {
+			t.Logf("Wanted error:%v as an *EncapsulatedError, got:%v", ErrInvalidEncapsulated, err)
+			t.Fail()
+		}
+	})
+
+	t.Run("ServiceError", func(t *testing.T) {
+
+		srv := newFakeICAPServer(t, func(req *fakeICAPRequest) string {
+			return "ICAP/1.0 404 ICAP Service not found\r\nISTag: TAG\r\nEncapsulated: null-body=0\r\n\r\n"
+		})
+		defer srv.close()
+
+		client := &Client{}
+		defer client.CloseIdleConnections()
+
+		req, err := NewRequest(MethodOPTIONS, srv.url("/nothing"), nil, nil)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		_, err = client.ServiceOptions(req)
+
+		var serviceErr *ServiceError
+
+		if !errors.As(err, &serviceErr) || serviceErr.StatusCode != http.StatusNotFound || serviceErr.ISTag != "TAG" {
+			t.Logf("Wanted a *ServiceError with the status code:%d & the ISTag:%s, got:%v", http.StatusNotFound, "TAG", err)
+			t.Fail()
+		}
+	})
+
+	t.Run("ServiceError with StatusErrors", func(t *testing.T) {
+
+		srv := newFakeICAPServer(t, func(req *fakeICAPRequest) string {
+			switch req.path {
+			case "/overloaded":
+				return "ICAP/1.0 503 Service Overloaded\r\nISTag: TAG\r\nService: FOO Tech Server 1.0\r\n" +
+					"Encapsulated: null-body=0\r\n\r\n"
+			case "/timeout":
+				return "ICAP/1.0 408 Request Timeout\r\nEncapsulated: null-body=0\r\n\r\n"
+			case "/method":
+				errPage := "<html>RESPMOD is not allowed</html>"
+				httpResp := "HTTP/1.1 405 Method Not Allowed\r\nContent-Type: text/html\r\n\r\n"
+				return "ICAP/1.0 405 Method Not Allowed\r\nService: FOO Tech Server 1.0\r\n" +
+					"Encapsulated: res-hdr=0, res-body=" + strconv.Itoa(len(httpResp)) + "\r\n\r\n" + httpResp +
+					strconv.FormatInt(int64(len(errPage)), 16) + "\r\n" + errPage + "\r\n0\r\n\r\n"
+			}
+			return noContentResponse("TAG")
+		})
+		defer srv.close()
+
+		type testSample struct {
+			path            string
+			statusErrors    bool
+			wantedStatus    int
+			wantedService   string
+			wantedBody      string
+			wantedRetryable bool
+			wantedErr       bool
+		}
+
+		sampleTable := []testSample{
+			{
+				path:            "/overloaded",
+				statusErrors:    true,
+				wantedStatus:    http.StatusServiceUnavailable,
+				wantedService:   "FOO Tech Server 1.0",
+				wantedRetryable: true,
+				wantedErr:       true,
+			},
+			{
+				path:            "/timeout",
+				statusErrors:    true,
+				wantedStatus:    http.StatusRequestTimeout,
+				wantedRetryable: true,
+				wantedErr:       true,
+			},
+			{
+				path:            "/method",
+				statusErrors:    true,
+				wantedStatus:    http.StatusMethodNotAllowed,
+				wantedService:   "FOO Tech Server 1.0",
+				wantedBody:      "<html>RESPMOD is not allowed</html>",
+				wantedRetryable: false,
+				wantedErr:       true,
+			},
+			{
+				path:         "/clean",
+				statusErrors: true,
+				wantedStatus: http.StatusNoContent,
+			},
+			{
+				path:         "/overloaded",
+				statusErrors: false,
+				wantedStatus: http.StatusServiceUnavailable,
+			},
+		}
+
+		for _, sample := range sampleTable {
+			client := &Client{StatusErrors: sample.statusErrors}
+
+			httpResp := &http.Response{
+				Status:     "200 OK",
+				StatusCode: http.StatusOK,
+				Proto:      "HTTP/1.1",
+				ProtoMajor: 1,
+				ProtoMinor: 1,
+				Header:     http.Header{},
+				Body:       ioutil.NopCloser(strings.NewReader("Hello World")),
+			}
+
+			req, err := NewRequest(MethodRESPMOD, srv.url(sample.path), nil, httpResp)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			resp, err := client.Do(req)
+
+			if !sample.wantedErr {
+				if err != nil || resp.StatusCode != sample.wantedStatus {
+					t.Logf("Wanted a response with the status code:%d, got:%v", sample.wantedStatus, err)
+					t.Fail()
+				}
+				client.CloseIdleConnections()
+				continue
+			}
+
+			var serviceErr *ServiceError
+
+			if !errors.As(err, &serviceErr) {
+				t.Logf("Wanted a *ServiceError for %s, got:%v", sample.path, err)
+				t.Fail()
+				client.CloseIdleConnections()
+				continue
+			}
+
+			if serviceErr.StatusCode != sample.wantedStatus || serviceErr.Service != sample.wantedService ||
+				serviceErr.Body != sample.wantedBody || serviceErr.Retryable() != sample.wantedRetryable {
+				t.Logf("Wanted the status code:%d, the service:%q, the body:%q & retryable:%v, got:%d, %q, %q & %v",
+					sample.wantedStatus, sample.wantedService, sample.wantedBody, sample.wantedRetryable, serviceErr.StatusCode,
+					serviceErr.Service, serviceErr.Body, serviceErr.Retryable())
+				t.Fail()
+			}
+
+			client.CloseIdleConnections()
+		}
+	})
+
+	t.Run("TimeoutError", func(t *testing.T) {
+
+		stall := make(chan struct{})
+		defer close(stall)
+
+		lstnr, err := net.Listen("tcp", "127.0.0.1:0") // a server reading what is sent, but never responding
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		defer lstnr.Close()
+
+		silentLstnr, err := net.Listen("tcp", "127.0.0.1:0") // a server never reading what is sent
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		defer silentLstnr.Close()
+
+		go func() {
+			for {
+				conn, err := lstnr.Accept()
+				if err != nil {
+					return
+				}
+				go io.Copy(ioutil.Discard, conn)
+			}
+		}()
+
+		go func() {
+			for {
+				conn, err := silentLstnr.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					<-stall
+					conn.Close()
+				}()
+			}
+		}()
+
+		type testSample struct {
+			url         string
+			body        io.Reader
+			preview     int
+			ctxTimeout  time.Duration
+			wantedPhase string
+			wantedErr   error
+		}
+
+		sampleTable := []testSample{
+			{
+				url:         "icaps://" + silentLstnr.Addr().String() + "/respmod", // the TLS handshake never completes
+				body:        strings.NewReader("Hello World"),
+				preview:     -1,
+				wantedPhase: PhaseDial,
+			},
+			{
+				url:         "icap://" + silentLstnr.Addr().String() + "/respmod",
+				body:        io.LimitReader(zeroReader{}, 1<<30),
+				preview:     -1,
+				wantedPhase: PhaseWrite,
+			},
+			{
+				url:         "icap://" + lstnr.Addr().String() + "/respmod",
+				body:        strings.NewReader("Hello World"),
+				preview:     -1,
+				wantedPhase: PhaseRead,
+			},
+			{
+				url:         "icap://" + lstnr.Addr().String() + "/respmod",
+				body:        strings.NewReader("Hello World"),
+				preview:     4,
+				wantedPhase: PhasePreviewContinue,
+			},
+			{
+				url:         "icap://" + lstnr.Addr().String() + "/respmod",
+				body:        strings.NewReader("Hello World"),
+				preview:     -1,
+				ctxTimeout:  50 * time.Millisecond,
+				wantedPhase: PhaseRead,
+				wantedErr:   context.DeadlineExceeded,
+			},
+		}
+
+		for _, sample := range sampleTable {
+			client := &Client{Timeout: 100 * time.Millisecond}
+			if sample.ctxTimeout > 0 {
+				client.Timeout = 10 * time.Second
+			}
+
+			httpResp := &http.Response{
+				Status:     "200 OK",
+				StatusCode: http.StatusOK,
+				Proto:      "HTTP/1.1",
+				ProtoMajor: 1,
+				ProtoMinor: 1,
+				Header:     http.Header{},
+				Body:       ioutil.NopCloser(sample.body),
+			}
+
+			req, err := NewRequest(MethodRESPMOD, sample.url, nil, httpResp)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			if sample.preview >= 0 {
+				if err := req.SetPreview(sample.preview); err != nil {
+					t.Fatal(err.Error())
+				}
+			}
+
+			ctx := context.Background()
+			if sample.ctxTimeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, sample.ctxTimeout)
+				defer cancel()
+			}
+
+			_, err = client.DoContext(ctx, req)
+
+			var timeoutErr *TimeoutError
+
+			if !errors.Is(err, ErrTimeout) || !errors.As(err, &timeoutErr) || timeoutErr.Phase != sample.wantedPhase {
+				t.Logf("Wanted a *TimeoutError in the phase:%s, got:%v", sample.wantedPhase, err)
+				t.Fail()
+				continue
+			}
+
+			if sample.wantedErr != nil && !errors.Is(err, sample.wantedErr) {
+				t.Logf("Wanted error:%v, got:%v", sample.wantedErr, err)
+				t.Fail()
+			}
+
+			if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+				t.Logf("Wanted the error to be a net.Error timing out, got:%v", err)
+				t.Fail()
+			}
+
+			client.CloseIdleConnections()
+		}
+	})
+
+}
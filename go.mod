@@ -1,6 +1,6 @@
 module github.com/egirna/icap-client
 
-go 1.12
+go 1.20
 
 require (
 	github.com/davecgh/go-spew v1.1.1
@@ -1,75 +1,43 @@
 package icapclient
 
 import (
-	"bytes"
-	"io/ioutil"
 	"net/http"
 	"os"
 	"strconv"
 )
 
 // SetPreview sets the preview bytes in the icap header
+//
+// The body is not read here, the preview portion of it is streamed when the request is sent, with a byte more read to
+// know if the entire body fits in the preview or not. The preview is cut down to the length of the body if it is known
+// to be shorter, the bodies of unknown length ending within the preview are sent with ieof
 func (r *Request) SetPreview(maxBytes int) error {
 
-	bodyBytes := []byte{}
-
 	previewBytes := 0
 
-	// receiving the body bites to determine the preview bytes depending on the request ICAP method
-
-	if r.Method == MethodREQMOD {
-		if r.HTTPRequest == nil {
-			return nil
-		}
-		if r.HTTPRequest.Body != nil {
-			var err error
-			bodyBytes, err = ioutil.ReadAll(r.HTTPRequest.Body)
-
-			if err != nil {
-				return err
-			}
-
-			defer r.HTTPRequest.Body.Close()
-		}
+	if maxBytes < 0 {
+		maxBytes = 0
 	}
 
-	if r.Method == MethodRESPMOD {
-		if r.HTTPResponse == nil {
-			return nil
-		}
+	// receiving the preview portion of the body depending on the request ICAP method
 
-		if r.HTTPResponse.Body != nil {
-			var err error
-			bodyBytes, err = ioutil.ReadAll(r.HTTPResponse.Body)
-
-			if err != nil {
-				return err
-			}
-
-			defer r.HTTPResponse.Body.Close()
-		}
+	if r.Method == MethodREQMOD && r.HTTPRequest == nil {
+		return nil
 	}
 
-	previewBytes = len(bodyBytes)
-
-	if previewBytes > 0 { // if the preview byte is 0 or less, there is no question of the body fitting insides
-		r.bodyFittedInPreview = true
+	if r.Method == MethodRESPMOD && r.HTTPResponse == nil {
+		return nil
 	}
 
-	if previewBytes > maxBytes { // if the preview bytes is greater than what was mentioned by the ICAP Server(did not fit in the body)
-		previewBytes = maxBytes
-		r.bodyFittedInPreview = false
-		r.remainingPreviewBytes = bodyBytes[maxBytes:] // storing the rest of the body byte which were not sent as preview for further operations
-	}
+	r.bodyFittedInPreview = false
 
-	// returning the body back to the http message depending on the request method
-
-	if r.Method == MethodREQMOD {
-		r.HTTPRequest.Body = ioutil.NopCloser(bytes.NewReader(bodyBytes))
-	}
+	if r.body() != nil {
+		previewBytes = maxBytes
 
-	if r.Method == MethodRESPMOD {
-		r.HTTPResponse.Body = ioutil.NopCloser(bytes.NewReader(bodyBytes))
+		if length := r.bodyLength(); length > 0 && length <= int64(maxBytes) { // the body is known to fit in the preview
+			previewBytes = int(length)
+			r.bodyFittedInPreview = true
+		}
 	}
 
 	// finally assinging the preview informations including setting the header
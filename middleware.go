@@ -0,0 +1,202 @@
+package icapclient
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// errBodyTooLarge is returned reading an upload larger than the limit
+var errBodyTooLarge = errors.New("the body is too large to be scanned")
+
+// UploadScanner scans the bodies of the requests coming in to an http server with the REQMOD service before they reach
+// the handler, Handler is the middleware: http.Handle("/upload", scanner.Handler(uploadHandler))
+//
+// The body is read in the memory, up to MaxBodySize, to be scanned & then passed on to the handler. The request adapted
+// by the service, if any, goes to the handler instead of the original one. The uploads with a verdict not allowed are
+// rejected with the Reject response, the ones the service fails to scan are passed on unscanned or rejected as the
+// Policy tells
+type UploadScanner struct {
+	Client       *Client                                                  // the client making the ICAP calls (default: a client with the default settings)
+	REQMODURL    string                                                   // the url of the REQMOD service
+	MaxBodySize  int64                                                    // the larger bodies are rejected with 413 Request Entity Too Large (default: 32MB, -1 for no limit)
+	ContentTypes []string                                                 // the media types of the bodies scanned, all of them if empty, for example: multipart/form-data & image/*
+	Policy       FailurePolicy                                            // what to do with the uploads the service fails to scan
+	Reject       func(w http.ResponseWriter, r *http.Request, v *Verdict) // writes the response of the uploads rejected (default: 403 Forbidden, 503 Service Unavailable if failed to scan)
+}
+
+// Handler returns the middleware scanning the uploads before they reach the handler
+func (s *UploadScanner) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if !s.scanned(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := s.readBody(r)
+
+		if err == errBodyTooLarge {
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+
+		adapted, v := s.scan(r, body)
+
+		if v.Status == VerdictError && s.Policy == FailOpen {
+			logDebug("Failing open for the upload to: ", r.URL.Path, ", ", v.Reason)
+		} else if !v.Allowed() {
+			s.reject(w, r, v)
+			return
+		}
+
+		if adapted != nil {
+			defer adapted.Body.Close()
+			next.ServeHTTP(w, adapted)
+			return
+		}
+
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// scanned tells if the body of the request is to be scanned
+func (s *UploadScanner) scanned(r *http.Request) bool {
+
+	if r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0 {
+		return false
+	}
+
+	if len(s.ContentTypes) == 0 {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	if err != nil {
+		return true // the content is scanned if it can't be told
+	}
+
+	for _, ct := range s.ContentTypes {
+		ct = strings.ToLower(ct)
+		if ct == mediaType || strings.HasSuffix(ct, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(ct, "*")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readBody reads the body of the request up to the size limit
+func (s *UploadScanner) readBody(r *http.Request) ([]byte, error) {
+
+	defer r.Body.Close()
+
+	limit := s.MaxBodySize
+	if limit == 0 {
+		limit = defaultMaxUploadSize
+	}
+
+	if limit < 0 {
+		return ioutil.ReadAll(r.Body)
+	}
+
+	if r.ContentLength > limit {
+		return nil, errBodyTooLarge
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, limit+1))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(body)) > limit {
+		return nil, errBodyTooLarge
+	}
+
+	return body, nil
+}
+
+// scan scans the request with the body read, returning the verdict & the request adapted by the service if any
+func (s *UploadScanner) scan(r *http.Request, body []byte) (*http.Request, *Verdict) {
+
+	client := s.Client
+	if client == nil {
+		client = defaultClient
+	}
+
+	scanReq := r.Clone(r.Context()) // sent as a client request, with the absolute url
+	scanReq.RequestURI = ""
+	scanReq.URL.Host = r.Host
+	scanReq.URL.Scheme = "http"
+	if r.TLS != nil {
+		scanReq.URL.Scheme = "https"
+	}
+	scanReq.ContentLength = int64(len(body))
+	scanReq.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	req, err := NewRequest(MethodREQMOD, s.REQMODURL, scanReq, nil)
+
+	if err != nil {
+		return nil, client.classifier().Classify(nil, err)
+	}
+
+	req.SetContext(r.Context())
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+
+	resp, err := client.Do(req)
+
+	v := client.classifier().Classify(resp, err)
+
+	if err != nil || !v.Allowed() || resp.ContentRequest == nil {
+		if resp != nil {
+			resp.closeBody()
+		}
+		return nil, v
+	}
+
+	adapted := r.Clone(r.Context())
+	adapted.Method = resp.ContentRequest.Method
+	adapted.URL = resp.ContentRequest.URL
+	adapted.RequestURI = resp.ContentRequest.URL.RequestURI()
+	adapted.Header = resp.ContentRequest.Header
+	adapted.ContentLength = resp.ContentRequest.ContentLength
+	adapted.TransferEncoding = resp.ContentRequest.TransferEncoding
+	adapted.Trailer = resp.ContentRequest.Trailer
+	adapted.Body = resp.ContentRequest.Body
+
+	if resp.ContentRequest.Host != "" {
+		adapted.Host = resp.ContentRequest.Host
+	}
+
+	return adapted, v
+}
+
+// reject writes the response of the upload rejected
+func (s *UploadScanner) reject(w http.ResponseWriter, r *http.Request, v *Verdict) {
+
+	if s.Reject != nil {
+		s.Reject(w, r, v)
+		return
+	}
+
+	if v.Status == VerdictError { // the details of the failure are not for the uploader
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+
+	http.Error(w, v.String(), http.StatusForbidden)
+}
@@ -0,0 +1,153 @@
+package icapclient
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUploadScanner(t *testing.T) {
+
+	srv := newFakeICAPServer(t, func(req *fakeICAPRequest) string {
+		body := string(req.body)
+		switch {
+		case strings.Contains(body, "EICAR"):
+			return "ICAP/1.0 200 OK\r\nISTag: TAG\r\nX-Infection-Found: Type=0; Resolution=2; Threat=EICAR-Test-File;\r\n" +
+				"Encapsulated: res-hdr=0, null-body=26\r\n\r\nHTTP/1.1 403 Forbidden\r\n\r\n"
+		case strings.Contains(body, "rewrite"):
+			reqHdr := "PUT /adapted HTTP/1.1\r\nHost: uploads.example.com\r\n\r\n"
+			return fmt.Sprintf("ICAP/1.0 200 OK\r\nISTag: TAG\r\nEncapsulated: req-hdr=0, req-body=%d\r\n\r\n%s"+
+				"9\r\nrewritten\r\n0\r\n\r\n", len(reqHdr), reqHdr)
+		case strings.Contains(body, "fail"):
+			return "" // the connection is dropped
+		}
+		return noContentResponse("TAG")
+	})
+	defer srv.close()
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s %s %s", r.Method, r.URL.Path, body)
+	})
+
+	client := &Client{}
+	defer client.CloseIdleConnections()
+
+	type testSample struct {
+		name         string
+		scanner      *UploadScanner
+		contentType  string
+		body         string
+		wantedStatus int
+		wantedBody   string
+		wantedCalled bool
+	}
+
+	sampleTable := []testSample{
+		{
+			name:         "clean",
+			scanner:      &UploadScanner{},
+			body:         "some content",
+			wantedStatus: http.StatusOK,
+			wantedBody:   "POST /upload some content",
+			wantedCalled: true,
+		},
+		{
+			name:         "adapted",
+			scanner:      &UploadScanner{},
+			body:         "rewrite me",
+			wantedStatus: http.StatusOK,
+			wantedBody:   "PUT /adapted rewritten",
+			wantedCalled: true,
+		},
+		{
+			name:         "infected",
+			scanner:      &UploadScanner{},
+			body:         "X5O!P%@AP[4\\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*",
+			wantedStatus: http.StatusForbidden,
+			wantedBody:   "infected: EICAR-Test-File\n",
+			wantedCalled: true,
+		},
+		{
+			name: "infected with a custom rejection",
+			scanner: &UploadScanner{Reject: func(w http.ResponseWriter, r *http.Request, v *Verdict) {
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				fmt.Fprint(w, strings.Join(v.Threats, ","))
+			}},
+			body:         "X5O!P%@AP[4\\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*",
+			wantedStatus: http.StatusUnprocessableEntity,
+			wantedBody:   "EICAR-Test-File",
+			wantedCalled: true,
+		},
+		{
+			name:         "failed closed",
+			scanner:      &UploadScanner{},
+			body:         "fail me",
+			wantedStatus: http.StatusServiceUnavailable,
+			wantedBody:   "Service Unavailable\n",
+			wantedCalled: true,
+		},
+		{
+			name:         "failed open",
+			scanner:      &UploadScanner{Policy: FailOpen},
+			body:         "fail me",
+			wantedStatus: http.StatusOK,
+			wantedBody:   "POST /upload fail me",
+			wantedCalled: true,
+		},
+		{
+			name:         "too large",
+			scanner:      &UploadScanner{MaxBodySize: 4},
+			body:         "some content",
+			wantedStatus: http.StatusRequestEntityTooLarge,
+			wantedBody:   "Request Entity Too Large\n",
+		},
+		{
+			name:         "content type scanned",
+			scanner:      &UploadScanner{ContentTypes: []string{"application/json", "image/*"}},
+			contentType:  "image/png",
+			body:         "some content",
+			wantedStatus: http.StatusOK,
+			wantedBody:   "POST /upload some content",
+			wantedCalled: true,
+		},
+		{
+			name:         "content type not scanned",
+			scanner:      &UploadScanner{ContentTypes: []string{"application/json", "image/*"}},
+			contentType:  "text/plain; charset=utf-8",
+			body:         "X5O!P%@AP[4\\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*",
+			wantedStatus: http.StatusOK,
+			wantedBody:   "POST /upload X5O!P%@AP[4\\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*",
+		},
+	}
+
+	for _, sample := range sampleTable {
+		calls := len(srv.received(MethodREQMOD))
+
+		sample.scanner.Client = client
+		sample.scanner.REQMODURL = srv.url("/reqmod")
+
+		r := httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader(sample.body))
+		if sample.contentType != "" {
+			r.Header.Set("Content-Type", sample.contentType)
+		}
+		w := httptest.NewRecorder()
+
+		sample.scanner.Handler(next).ServeHTTP(w, r)
+
+		if w.Code != sample.wantedStatus || w.Body.String() != sample.wantedBody {
+			t.Logf("Wanted %d:%q for the upload %s, got:%d:%q", sample.wantedStatus, sample.wantedBody, sample.name, w.Code,
+				w.Body.String())
+			t.Fail()
+		}
+
+		if called := len(srv.received(MethodREQMOD)) > calls; called != sample.wantedCalled {
+			t.Logf("Wanted the service called for the upload %s:%v, got:%v", sample.name, sample.wantedCalled, called)
+			t.Fail()
+		}
+	}
+
+}
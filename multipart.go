@@ -0,0 +1,276 @@
+package icapclient
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"sync"
+)
+
+// PartVerdict is the verdict of a part of a multipart/form-data upload
+type PartVerdict struct {
+	FormName    string   // the name of the form field
+	Filename    string   // the name of the file, empty for the fields that are not files
+	ContentType string   // the Content-Type of the part
+	Verdict     *Verdict // the verdict of the scan, nil for the fields that are not files as they are not scanned
+	Adapted     bool     // the content of the part is replaced by the one adapted by the service
+	Removed     bool     // the part is removed from the request as its verdict is not allowed
+}
+
+// MultipartLimits bounds what ScanMultipart takes for an upload, the uploads going over the limits are not scanned
+// & get an error that is ErrMultipartTooLarge
+type MultipartLimits struct {
+	MaxParts     int   // the number of parts, the fields included (default: 100)
+	MaxPartSize  int64 // the size of the content of a part (default: MaxTotalSize)
+	MaxTotalSize int64 // the size of the contents of all the parts (default: 32MB)
+	Concurrency  int   // the number of files scanned at the same time (default: 4)
+}
+
+// maxParts returns the maximum number of parts
+func (l *MultipartLimits) maxParts() int {
+	if l == nil || l.MaxParts <= 0 {
+		return defaultMaxMultipartParts
+	}
+
+	return l.MaxParts
+}
+
+// maxTotalSize returns the maximum size of the contents of all the parts
+func (l *MultipartLimits) maxTotalSize() int64 {
+	if l == nil || l.MaxTotalSize <= 0 {
+		return defaultMaxUploadSize
+	}
+
+	return l.MaxTotalSize
+}
+
+// maxPartSize returns the maximum size of the content of a part
+func (l *MultipartLimits) maxPartSize() int64 {
+	if l == nil || l.MaxPartSize <= 0 || l.MaxPartSize > l.maxTotalSize() {
+		return l.maxTotalSize()
+	}
+
+	return l.MaxPartSize
+}
+
+// concurrency returns the number of files scanned at the same time
+func (l *MultipartLimits) concurrency() int {
+	if l == nil || l.Concurrency <= 0 {
+		return defaultMultipartConcurrency
+	}
+
+	return l.Concurrency
+}
+
+// multipartPart is a part of a multipart/form-data body read in the memory
+type multipartPart struct {
+	header  textproto.MIMEHeader
+	content []byte
+	verdict *PartVerdict
+}
+
+// ScanMultipart scans every file of the multipart/form-data request with the ICAP service at the url, each on its own
+// as a RESPMOD call of a file downloaded with its filename & Content-Type as ScanReader does, the files concurrently.
+// The fields that are not files are not scanned
+//
+// The verdicts of the parts are returned in the order of the parts along with the request rebuilt with the parts as
+// the service left them: the ones adapted replaced by their adapted content & the ones with a verdict not allowed,
+// including the ones the service failed to scan, removed. The body of the request is read in the memory, as far as
+// the Multipart limits of the client allow, & closed, the rebuilt request is to be used instead
+func (c *Client) ScanMultipart(ctx context.Context, serviceURL string, r *http.Request) ([]*PartVerdict, *http.Request, error) {
+
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	if err != nil || mediaType != "multipart/form-data" || params["boundary"] == "" || r.Body == nil {
+		return nil, nil, ErrNotMultipart
+	}
+
+	defer r.Body.Close()
+
+	parts, err := readParts(multipart.NewReader(r.Body, params["boundary"]), c.Multipart)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, c.Multipart.concurrency()) // the files scanned at the same time
+
+	for _, part := range parts {
+		if part.verdict.Filename == "" {
+			continue
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(part *multipartPart) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			c.scanPart(ctx, serviceURL, part)
+		}(part)
+	}
+
+	wg.Wait()
+
+	body, err := writeParts(parts, params["boundary"])
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	verdicts := make([]*PartVerdict, 0, len(parts))
+	for _, part := range parts {
+		verdicts = append(verdicts, part.verdict)
+	}
+
+	rebuilt := r.Clone(r.Context())
+	rebuilt.ContentLength = int64(len(body))
+	rebuilt.TransferEncoding = nil
+	rebuilt.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	rebuilt.Body = ioutil.NopCloser(bytes.NewReader(body))
+	rebuilt.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+
+	return verdicts, rebuilt, nil
+}
+
+// scanPart scans the file of the part, replacing its content by the one adapted by the service if any
+func (c *Client) scanPart(ctx context.Context, serviceURL string, part *multipartPart) {
+
+	pv := part.verdict
+
+	req, err := newScanRequest(serviceURL, pv.Filename, pv.ContentType, bytes.NewReader(part.content))
+
+	if err != nil {
+		pv.Verdict, pv.Removed = c.classifier().Classify(nil, err), true
+		return
+	}
+
+	req.SetContext(ctx)
+
+	resp, err := c.Do(req)
+
+	pv.Verdict = c.classifier().Classify(resp, err)
+
+	if err != nil || !pv.Verdict.Allowed() || resp.ContentResponse == nil {
+		if resp != nil {
+			resp.closeBody()
+		}
+		pv.Removed = !pv.Verdict.Allowed()
+		return
+	}
+
+	defer resp.closeBody()
+
+	limit := c.Multipart.maxPartSize()
+	content, err := ioutil.ReadAll(io.LimitReader(resp.ContentResponse.Body, limit+1))
+
+	if err == nil && int64(len(content)) > limit {
+		err = &MultipartError{Part: pv.Filename, Limit: limit, Reason: "adapted part larger than"}
+	}
+
+	if err != nil { // the adapted content is cut short or too large, the part is not to be trusted
+		pv.Verdict, pv.Removed = c.classifier().Classify(nil, err), true
+		return
+	}
+
+	part.content = content
+	pv.Adapted = true
+
+	if ct := resp.ContentResponse.Header.Get("Content-Type"); ct != "" {
+		pv.ContentType = ct
+		part.header.Set("Content-Type", ct)
+	}
+}
+
+// readParts reads the parts of the multipart body in the memory, within the limits
+func readParts(mr *multipart.Reader, limits *MultipartLimits) ([]*multipartPart, error) {
+
+	parts := []*multipartPart{}
+	left := limits.maxTotalSize() // what is left of the total size
+
+	for {
+		p, err := mr.NextPart()
+
+		if err == io.EOF {
+			return parts, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(parts) == limits.maxParts() {
+			return nil, &MultipartError{Limit: int64(limits.maxParts()), Reason: "more parts than"}
+		}
+
+		limit, partErr := limits.maxPartSize(), &MultipartError{Part: p.FormName(), Limit: limits.maxPartSize(), Reason: "part larger than"}
+		if left < limit {
+			limit, partErr = left, &MultipartError{Limit: limits.maxTotalSize(), Reason: "upload larger than"}
+		}
+
+		content, err := ioutil.ReadAll(io.LimitReader(p, limit+1))
+
+		if err != nil {
+			return nil, err
+		}
+
+		if int64(len(content)) > limit {
+			return nil, partErr
+		}
+
+		left -= int64(len(content))
+
+		parts = append(parts, &multipartPart{
+			header:  p.Header,
+			content: content,
+			verdict: &PartVerdict{
+				FormName:    p.FormName(),
+				Filename:    p.FileName(),
+				ContentType: p.Header.Get("Content-Type"),
+			},
+		})
+	}
+}
+
+// writeParts writes the multipart body of the parts not removed, with the boundary
+func writeParts(parts []*multipartPart, boundary string) ([]byte, error) {
+
+	buf := &bytes.Buffer{}
+	mw := multipart.NewWriter(buf)
+
+	if err := mw.SetBoundary(boundary); err != nil {
+		return nil, err
+	}
+
+	for _, part := range parts {
+		if part.verdict.Removed {
+			continue
+		}
+
+		w, err := mw.CreatePart(part.header)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := w.Write(part.content); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,253 @@
+package icapclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestScanMultipart(t *testing.T) {
+
+	srv := newFakeICAPServer(t, func(req *fakeICAPRequest) string {
+		if req.method == MethodOPTIONS {
+			return optionsResponse("Methods: RESPMOD", "Allow: 204", "Preview: 4", "ISTag: TAG", "Transfer-Preview: *")
+		}
+		body := string(req.body)
+		switch {
+		case strings.Contains(body, "EICAR"):
+			return "ICAP/1.0 200 OK\r\nISTag: TAG\r\nX-Infection-Found: Type=0; Resolution=2; Threat=EICAR-Test-File;\r\n" +
+				"Encapsulated: res-hdr=0, null-body=26\r\n\r\nHTTP/1.1 403 Forbidden\r\n\r\n"
+		case strings.Contains(body, "macro"):
+			resHdr := "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\n\r\n"
+			return fmt.Sprintf("ICAP/1.0 200 OK\r\nISTag: TAG\r\nEncapsulated: res-hdr=0, res-body=%d\r\n\r\n%s"+
+				"7\r\ncleaned\r\n0\r\n\r\n", len(resHdr), resHdr)
+		}
+		return noContentResponse("TAG")
+	})
+	defer srv.close()
+
+	client := &Client{}
+	defer client.CloseIdleConnections()
+
+	t.Run("Client ScanMultipart", func(t *testing.T) {
+
+		buf := &bytes.Buffer{}
+		mw := multipart.NewWriter(buf)
+
+		mw.WriteField("title", "my uploads")
+		files := [][2]string{
+			{"report.txt", "Hello World"},
+			{"eicar.com", "X5O!P%@AP[4\\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*"},
+			{"sheet.xls", "a sheet with a macro"},
+		}
+		for _, file := range files {
+			w, _ := mw.CreateFormFile("files", file[0])
+			w.Write([]byte(file[1]))
+		}
+		mw.Close()
+
+		r, err := http.NewRequest(http.MethodPost, "http://localhost/upload", buf)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		r.Header.Set("Content-Type", mw.FormDataContentType())
+
+		verdicts, rebuilt, err := client.ScanMultipart(context.Background(), srv.url("/avscan"), r)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		type testSample struct {
+			formName      string
+			filename      string
+			wantedStatus  VerdictStatus
+			wantedAdapted bool
+			wantedRemoved bool
+		}
+
+		sampleTable := []testSample{
+			{formName: "title"},
+			{formName: "files", filename: "report.txt", wantedStatus: VerdictClean},
+			{formName: "files", filename: "eicar.com", wantedStatus: VerdictInfected, wantedRemoved: true},
+			{formName: "files", filename: "sheet.xls", wantedStatus: VerdictModified, wantedAdapted: true},
+		}
+
+		if len(verdicts) != len(sampleTable) {
+			t.Fatalf("Wanted %d part verdicts, got:%d", len(sampleTable), len(verdicts))
+		}
+
+		for i, sample := range sampleTable {
+			pv := verdicts[i]
+
+			if pv.FormName != sample.formName || pv.Filename != sample.filename || pv.Adapted != sample.wantedAdapted ||
+				pv.Removed != sample.wantedRemoved {
+				t.Logf("Wanted the part %s %s adapted:%v & removed:%v, got:%+v", sample.formName, sample.filename,
+					sample.wantedAdapted, sample.wantedRemoved, pv)
+				t.Fail()
+				continue
+			}
+
+			if sample.filename == "" {
+				if pv.Verdict != nil {
+					t.Logf("Wanted the field %s not scanned, got:%+v", sample.formName, pv.Verdict)
+					t.Fail()
+				}
+				continue
+			}
+
+			if pv.Verdict == nil || pv.Verdict.Status != sample.wantedStatus {
+				t.Logf("Wanted the verdict of %s:%s, got:%+v", sample.filename, sample.wantedStatus, pv.Verdict)
+				t.Fail()
+			}
+		}
+
+		if err := rebuilt.ParseMultipartForm(1 << 20); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if rebuilt.FormValue("title") != "my uploads" {
+			t.Logf("Wanted the field title kept, got:%q", rebuilt.FormValue("title"))
+			t.Fail()
+		}
+
+		wantedFiles := map[string]string{"report.txt": "Hello World", "sheet.xls": "cleaned"}
+
+		if len(rebuilt.MultipartForm.File["files"]) != len(wantedFiles) {
+			t.Logf("Wanted %d files in the rebuilt request, got:%d", len(wantedFiles), len(rebuilt.MultipartForm.File["files"]))
+			t.Fail()
+		}
+
+		for _, fh := range rebuilt.MultipartForm.File["files"] {
+			f, err := fh.Open()
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			content, _ := ioutil.ReadAll(f)
+			f.Close()
+
+			if string(content) != wantedFiles[fh.Filename] {
+				t.Logf("Wanted the content of %s in the rebuilt request:%q, got:%q", fh.Filename, wantedFiles[fh.Filename],
+					string(content))
+				t.Fail()
+			}
+		}
+	})
+
+	t.Run("Client ScanMultipart over the limits", func(t *testing.T) {
+
+		type testSample struct {
+			limits    *MultipartLimits
+			files     int
+			size      int
+			wantedErr error
+		}
+
+		sampleTable := []testSample{
+			{limits: &MultipartLimits{MaxParts: 3}, files: 3, size: 10, wantedErr: nil},
+			{limits: &MultipartLimits{MaxParts: 3}, files: 4, size: 10, wantedErr: ErrMultipartTooLarge},
+			{limits: &MultipartLimits{MaxPartSize: 10}, files: 1, size: 10, wantedErr: nil},
+			{limits: &MultipartLimits{MaxPartSize: 10}, files: 1, size: 11, wantedErr: ErrMultipartTooLarge},
+			{limits: &MultipartLimits{MaxTotalSize: 30}, files: 3, size: 10, wantedErr: nil},
+			{limits: &MultipartLimits{MaxTotalSize: 30}, files: 4, size: 10, wantedErr: ErrMultipartTooLarge},
+		}
+
+		for _, sample := range sampleTable {
+			buf := &bytes.Buffer{}
+			mw := multipart.NewWriter(buf)
+			for i := 0; i < sample.files; i++ {
+				w, _ := mw.CreateFormFile("files", fmt.Sprintf("file-%d.txt", i))
+				w.Write(bytes.Repeat([]byte("a"), sample.size))
+			}
+			mw.Close()
+
+			r, err := http.NewRequest(http.MethodPost, "http://localhost/upload", buf)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			r.Header.Set("Content-Type", mw.FormDataContentType())
+
+			limited := &Client{Multipart: sample.limits}
+
+			_, _, err = limited.ScanMultipart(context.Background(), srv.url("/avscan"), r)
+			limited.CloseIdleConnections()
+
+			if !errors.Is(err, sample.wantedErr) || (sample.wantedErr == nil && err != nil) {
+				t.Logf("Wanted the error:%v for %d files of %d bytes with the limits:%+v, got:%v", sample.wantedErr,
+					sample.files, sample.size, sample.limits, err)
+				t.Fail()
+			}
+		}
+	})
+
+	t.Run("Client ScanMultipart concurrency", func(t *testing.T) {
+
+		var inFlight, maxInFlight int32
+
+		slowSrv := newFakeICAPServer(t, func(req *fakeICAPRequest) string {
+			if req.method == MethodOPTIONS {
+				return optionsResponse("Methods: RESPMOD", "Allow: 204", "ISTag: TAG")
+			}
+			n := atomic.AddInt32(&inFlight, 1)
+			defer atomic.AddInt32(&inFlight, -1)
+			for {
+				max := atomic.LoadInt32(&maxInFlight)
+				if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+					break
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+			return noContentResponse("TAG")
+		})
+		defer slowSrv.close()
+
+		buf := &bytes.Buffer{}
+		mw := multipart.NewWriter(buf)
+		for i := 0; i < 8; i++ {
+			w, _ := mw.CreateFormFile("files", fmt.Sprintf("file-%d.txt", i))
+			w.Write([]byte("Hello World"))
+		}
+		mw.Close()
+
+		r, err := http.NewRequest(http.MethodPost, "http://localhost/upload", buf)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		r.Header.Set("Content-Type", mw.FormDataContentType())
+
+		limited := &Client{Multipart: &MultipartLimits{Concurrency: 2}}
+		defer limited.CloseIdleConnections()
+
+		verdicts, _, err := limited.ScanMultipart(context.Background(), slowSrv.url("/avscan"), r)
+		if err != nil || len(verdicts) != 8 {
+			t.Fatalf("Wanted the verdicts of 8 files, got:%d & %v", len(verdicts), err)
+		}
+
+		if max := atomic.LoadInt32(&maxInFlight); max > 2 {
+			t.Logf("Wanted at most %d files scanned at the same time, got:%d", 2, max)
+			t.Fail()
+		}
+	})
+
+	t.Run("Client ScanMultipart not multipart", func(t *testing.T) {
+
+		r, err := http.NewRequest(http.MethodPost, "http://localhost/upload", strings.NewReader("{}"))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		r.Header.Set("Content-Type", "application/json")
+
+		if _, _, err := client.ScanMultipart(context.Background(), srv.url("/avscan"), r); err != ErrNotMultipart {
+			t.Logf("Wanted the error:%v, got:%v", ErrNotMultipart, err)
+			t.Fail()
+		}
+	})
+
+}
@@ -0,0 +1,246 @@
+package icapclient
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ServiceOptions represents the options of an ICAP service, as advertised by the server in the OPTIONS response
+type ServiceOptions struct {
+	Methods          map[string]bool // the ICAP methods the service supports, for example: RESPMOD
+	Preview          int             // the number of the body bytes the service asks to preview, -1 if it doesn't support previews
+	Allow204         bool            // whether the service responds with 204 No Content when there is no modification
+	TransferPreview  []string        // the file extensions to preview the body of, "*" stands for the ones in none of the lists
+	TransferIgnore   []string        // the file extensions not to send to the service at all
+	TransferComplete []string        // the file extensions to send the body of in full, without preview
+	TTL              time.Duration   // how long the options are valid for, 0 means for ever
+	MaxConnections   int             // the maximum number of connections the service accepts, 0 means no limit
+	ISTag            string          // the tag of the service state, it changes whenever the service does
+	Service          string          // the description of the service
+	ServiceID        string          // the ID of the service
+	OptBodyType      string          // the type of the OPTIONS body if there is one
+	Date             time.Time       // when the server sent the options, zero if not told
+}
+
+// Options parses the option headers of the response, the response is expected to be one for an OPTIONS request
+//
+// The headers not sent by the server are left at their defaults, the ones with invalid values fail the parsing. The
+// Preview, Options-TTL & Max-Connections are invalid if negative or larger than 2147483647(math.MaxInt32)
+func (r *Response) Options() (*ServiceOptions, error) {
+	return parseServiceOptions(r.Header)
+}
+
+// parseServiceOptions parses the option headers of an OPTIONS response, the ones in optionValues & the Date
+func parseServiceOptions(hdr http.Header) (*ServiceOptions, error) {
+
+	opts := &ServiceOptions{
+		Methods:          map[string]bool{},
+		Preview:          -1,
+		TransferPreview:  headerList(hdr, TransferPreviewHeader),
+		TransferIgnore:   headerList(hdr, TransferIgnoreHeader),
+		TransferComplete: headerList(hdr, TransferCompleteHeader),
+		ISTag:            hdr.Get(ISTagHeader),
+		Service:          hdr.Get(ServiceHeader),
+		ServiceID:        hdr.Get(ServiceIDHeader),
+		OptBodyType:      hdr.Get(OptBodyTypeHeader),
+	}
+
+	for _, method := range headerList(hdr, MethodsHeader) {
+		method = strings.ToUpper(method)
+		if method != MethodREQMOD && method != MethodRESPMOD {
+			return nil, &ProtocolError{Line: MethodsHeader + ": " + hdr.Get(MethodsHeader), Err: ErrInvalidOptionsHeader}
+		}
+		opts.Methods[method] = true
+	}
+
+	for _, val := range headerList(hdr, AllowHeader) {
+		if _, err := strconv.Atoi(val); err != nil {
+			return nil, &ProtocolError{Line: AllowHeader + ": " + hdr.Get(AllowHeader), Err: ErrInvalidOptionsHeader}
+		}
+		if val == "204" {
+			opts.Allow204 = true
+		}
+	}
+
+	for _, name := range []string{TransferPreviewHeader, TransferIgnoreHeader, TransferCompleteHeader} {
+		for _, ext := range headerList(hdr, name) {
+			if ext != "*" && !isToken(ext) {
+				return nil, &ProtocolError{Line: name + ": " + hdr.Get(name), Err: ErrInvalidOptionsHeader}
+			}
+		}
+	}
+
+	var err error
+
+	if opts.Preview, err = optionInt(hdr, PreviewHeader, -1); err != nil {
+		return nil, err
+	}
+
+	ttl, err := optionInt(hdr, OptionsTTLHeader, 0)
+
+	if err != nil {
+		return nil, err
+	}
+
+	opts.TTL = time.Duration(ttl) * time.Second
+
+	if opts.MaxConnections, err = optionInt(hdr, MaxConnectionsHeader, 0); err != nil {
+		return nil, err
+	}
+
+	if _, ok := hdr[MaxConnectionsHeader]; ok && opts.MaxConnections == 0 { // a server accepting no connection at all makes no sense
+		return nil, &ProtocolError{Line: MaxConnectionsHeader + ": " + hdr.Get(MaxConnectionsHeader), Err: ErrInvalidOptionsHeader}
+	}
+
+	if date := hdr.Get(DateHeader); date != "" {
+		if opts.Date, err = http.ParseTime(date); err != nil {
+			return nil, &ProtocolError{Line: DateHeader + ": " + date, Err: ErrInvalidOptionsHeader}
+		}
+	}
+
+	return opts, nil
+}
+
+// optionInt parses the non negative integer value of an option header up to maxOptionValue, def is returned if the
+// header is not there
+func optionInt(hdr http.Header, name string, def int) (int, error) {
+
+	val := strings.TrimSpace(hdr.Get(name))
+
+	if val == "" {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(val)
+
+	if err != nil || n < 0 || n > maxOptionValue { // the larger values are no use, a preview that large can't be held
+		return 0, &ProtocolError{Line: name + ": " + val, Err: ErrInvalidOptionsHeader}
+	}
+
+	return n, nil
+}
+
+// headerList returns the comma separated values of a header as a list, for example: Transfer-Preview: *, pdf
+func headerList(hdr http.Header, name string) []string {
+
+	list := []string{}
+
+	for _, val := range hdr[http.CanonicalHeaderKey(name)] {
+		for _, item := range strings.Split(val, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+
+	return list
+}
+
+// optionsCache keeps the options of the ICAP services per service URL, fetching them once for all the calls made
+// till they expire or the ISTag of the service changes
+type optionsCache struct {
+	mu      sync.Mutex
+	entries map[string]*optionsEntry
+}
+
+// optionsEntry is the cached options of an ICAP service, being fetched till ready is closed
+type optionsEntry struct {
+	ready   chan struct{}
+	opts    *ServiceOptions
+	err     error
+	expires time.Time // zero if the options never expire
+}
+
+// newOptionsCache is the factory function for optionsCache
+func newOptionsCache() *optionsCache {
+	return &optionsCache{
+		entries: map[string]*optionsEntry{},
+	}
+}
+
+// get returns the options of the service, fetching them if not cached or expired,
+// the calls for a service being fetched wait for the fetch instead of making one of their own
+func (oc *optionsCache) get(key string, fetch func() (*ServiceOptions, error)) (*ServiceOptions, error) {
+
+	oc.mu.Lock()
+
+	entry, ok := oc.entries[key]
+
+	if ok {
+		select {
+		case <-entry.ready:
+			if entry.err == nil && (entry.expires.IsZero() || time.Now().Before(entry.expires)) {
+				oc.mu.Unlock()
+				return entry.opts, nil
+			}
+			ok = false // expired, fetching them again
+		default:
+		}
+	}
+
+	if !ok {
+		entry = &optionsEntry{ready: make(chan struct{})}
+		oc.entries[key] = entry
+		oc.mu.Unlock()
+
+		entry.opts, entry.err = fetch()
+		if entry.err == nil && entry.opts.TTL > 0 {
+			entry.expires = time.Now().Add(entry.opts.TTL)
+		}
+		close(entry.ready)
+
+		if entry.err != nil { // not caching the failures, the next call is to try again
+			oc.drop(key, entry)
+		}
+
+		return entry.opts, entry.err
+	}
+
+	oc.mu.Unlock()
+
+	<-entry.ready
+
+	return entry.opts, entry.err
+}
+
+// checkISTag drops the cached options of the service if the ISTag it responded with is not the same anymore
+func (oc *optionsCache) checkISTag(key, istag string) {
+
+	if istag == "" {
+		return
+	}
+
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+
+	entry, ok := oc.entries[key]
+
+	if !ok {
+		return
+	}
+
+	select {
+	case <-entry.ready:
+		if entry.err == nil && entry.opts.ISTag != istag {
+			delete(oc.entries, key)
+		}
+	default:
+	}
+}
+
+// drop removes the entry of the service, unless it is replaced already
+func (oc *optionsCache) drop(key string, entry *optionsEntry) {
+	oc.mu.Lock()
+	if oc.entries[key] == entry {
+		delete(oc.entries, key)
+	}
+	oc.mu.Unlock()
+}
+
+// optionsKey returns the key of the ICAP service the request is for in the options cache
+func optionsKey(req *Request) string {
+	return req.URL.Scheme + "://" + req.URL.Host + req.URL.Path
+}
@@ -0,0 +1,623 @@
+package icapclient
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/textproto"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeICAPRequest is a request received by the fakeICAPServer
+type fakeICAPRequest struct {
+	method  string
+	path    string
+	header  textproto.MIMEHeader
+	httpHdr string // the encapsulated http headers
+	body    []byte // the entire body, the preview & the rest of it
+}
+
+// fakeICAPServer is a keep-alive ICAP server answering the requests with what the handler returns, dropping the
+// connection if it returns nothing
+type fakeICAPServer struct {
+	lstnr    net.Listener
+	handler  func(req *fakeICAPRequest) string
+	mu       sync.Mutex
+	requests []*fakeICAPRequest
+	conns    int // the number of the connections accepted
+}
+
+// newFakeICAPServer is the factory function for fakeICAPServer
+func newFakeICAPServer(t *testing.T, handler func(req *fakeICAPRequest) string) *fakeICAPServer {
+
+	lstnr, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	s := &fakeICAPServer{
+		lstnr:   lstnr,
+		handler: handler,
+	}
+
+	go func() {
+		for {
+			conn, err := lstnr.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conns++
+			s.mu.Unlock()
+			go s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+// url returns the url of the service at the path
+func (s *fakeICAPServer) url(path string) string {
+	return "icap://" + s.lstnr.Addr().String() + path
+}
+
+// close stops the server
+func (s *fakeICAPServer) close() {
+	s.lstnr.Close()
+}
+
+// received returns the requests received with the method
+func (s *fakeICAPServer) received(method string) []*fakeICAPRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reqs := []*fakeICAPRequest{}
+	for _, req := range s.requests {
+		if req.method == method {
+			reqs = append(reqs, req)
+		}
+	}
+
+	return reqs
+}
+
+// accepted returns the number of the connections accepted
+func (s *fakeICAPServer) accepted() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.conns
+}
+
+// serve serves the requests on the connection till it is closed
+func (s *fakeICAPServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	br := bufio.NewReader(conn)
+
+	for {
+		req, err := s.readRequest(br, conn)
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.requests = append(s.requests, req)
+		s.mu.Unlock()
+
+		resp := s.handler(req)
+
+		if resp == "" { // the connection is dropped without a response
+			return
+		}
+
+		if _, err := io.WriteString(conn, resp); err != nil || strings.Contains(resp, "Connection: close") {
+			return
+		}
+	}
+}
+
+// readRequest reads an ICAP request, asking for the rest of the body with 100 Continue if only a preview of it is sent
+func (s *fakeICAPServer) readRequest(br *bufio.Reader, conn net.Conn) (*fakeICAPRequest, error) {
+
+	tp := textproto.NewReader(br)
+
+	line, err := tp.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+
+	ss := strings.SplitN(line, " ", 3)
+	if len(ss) != 3 {
+		return nil, errors.New("invalid request line: " + line)
+	}
+
+	req := &fakeICAPRequest{method: ss[0]}
+
+	if i := strings.Index(ss[1], "://"); i >= 0 {
+		req.path = ss[1][i+3:]
+		if j := strings.Index(req.path, "/"); j >= 0 {
+			req.path = req.path[j:]
+		}
+	}
+
+	if req.header, err = tp.ReadMIMEHeader(); err != nil {
+		return nil, err
+	}
+
+	entities, err := parseEncapsulated(req.header.Get(EncapsulatedHeader))
+	if err != nil {
+		return nil, err
+	}
+
+	hdr := make([]byte, entities[len(entities)-1].offset)
+	if _, err := io.ReadFull(br, hdr); err != nil {
+		return nil, err
+	}
+	req.httpHdr = string(hdr)
+
+	if entities[len(entities)-1].name == "null-body" {
+		return req, nil
+	}
+
+	cr := newChunkedReader(br)
+	if req.body, err = ioutil.ReadAll(cr); err != nil {
+		return nil, err
+	}
+
+	if _, ieof := cr.extension("ieof"); req.header.Get(PreviewHeader) != "" && !ieof {
+		if _, err := io.WriteString(conn, "ICAP/1.0 100 Continue\r\n\r\n"); err != nil {
+			return nil, err
+		}
+
+		rest, err := ioutil.ReadAll(newChunkedReader(br))
+		if err != nil {
+			return nil, err
+		}
+		req.body = append(req.body, rest...)
+	}
+
+	return req, nil
+}
+
+// optionsResponse returns an OPTIONS response with the headers
+func optionsResponse(headers ...string) string {
+	return "ICAP/1.0 200 OK\r\n" + strings.Join(headers, "\r\n") + "\r\nEncapsulated: null-body=0\r\n\r\n"
+}
+
+// noContentResponse returns a 204 No Content response with the ISTag
+func noContentResponse(istag string) string {
+	return fmt.Sprintf("ICAP/1.0 204 No Content\r\nISTag: %s\r\nEncapsulated: null-body=0\r\n\r\n", istag)
+}
+
+func TestOptions(t *testing.T) {
+
+	t.Run("parseServiceOptions", func(t *testing.T) {
+
+		hdr := http.Header{}
+		hdr.Set("Methods", "RESPMOD, REQMOD")
+		hdr.Set("Allow", "204")
+		hdr.Set("Preview", "1024")
+		hdr.Set("Transfer-Preview", "*")
+		hdr.Set("Transfer-Ignore", "jpg, gif,png")
+		hdr.Set("Transfer-Complete", "exe,bat")
+		hdr.Set("Options-TTL", "3600")
+		hdr.Set("Max-Connections", "100")
+		hdr.Set("ISTag", `"5BDEEEA9-12E4-2"`)
+		hdr.Set("Service", "FOO Tech Server 1.0")
+		hdr.Set("Service-ID", "av")
+		hdr.Set("Opt-body-type", "Plain")
+
+		opts, err := parseServiceOptions(hdr)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		wanted := &ServiceOptions{
+			Methods:          map[string]bool{MethodRESPMOD: true, MethodREQMOD: true},
+			Preview:          1024,
+			Allow204:         true,
+			TransferPreview:  []string{"*"},
+			TransferIgnore:   []string{"jpg", "gif", "png"},
+			TransferComplete: []string{"exe", "bat"},
+			TTL:              time.Hour,
+			MaxConnections:   100,
+			ISTag:            `"5BDEEEA9-12E4-2"`,
+			Service:          "FOO Tech Server 1.0",
+			ServiceID:        "av",
+			OptBodyType:      "Plain",
+		}
+
+		if fmt.Sprint(opts) != fmt.Sprint(wanted) {
+			t.Logf("Wanted the options: %v, got: %v", wanted, opts)
+			t.Fail()
+		}
+
+		opts, err = parseServiceOptions(http.Header{})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if opts.Preview != -1 || opts.Allow204 || opts.TTL != 0 || len(opts.Methods) != 0 {
+			t.Logf("Wanted no preview, no 204 & no expiry for no options, got: %v", opts)
+			t.Fail()
+		}
+
+		for _, name := range []string{PreviewHeader, OptionsTTLHeader, MaxConnectionsHeader} {
+			hdr := http.Header{}
+			hdr.Set(name, "-5")
+
+			if _, err := parseServiceOptions(hdr); err == nil {
+				t.Logf("Wanted an error for the invalid %s header, got none", name)
+				t.Fail()
+			}
+		}
+	})
+
+	t.Run("Response Options", func(t *testing.T) {
+
+		respStr := "ICAP/1.0 200 OK\r\n" +
+			"Date: Mon, 10 Jan 2000 09:55:21 GMT\r\n" +
+			"Methods: RESPMOD\r\n" +
+			"Service: FOO Tech Server 1.0\r\n" +
+			"ISTag: \"W3E4R7U9-L2E4-2\"\r\n" +
+			"Encapsulated: null-body=0\r\n" +
+			"Max-Connections: 1000\r\n" +
+			"Options-TTL: 7200\r\n" +
+			"Allow: 204\r\n" +
+			"Preview: 2048\r\n" +
+			"Transfer-Complete: asp, bat, exe, com\r\n" +
+			"Transfer-Ignore: html\r\n" +
+			"Transfer-Preview: *\r\n\r\n"
+
+		resp, err := ReadResponse(bufio.NewReader(strings.NewReader(respStr)))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		opts, err := resp.Options()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		wanted := &ServiceOptions{
+			Methods:          map[string]bool{MethodRESPMOD: true},
+			Preview:          2048,
+			Allow204:         true,
+			TransferPreview:  []string{"*"},
+			TransferIgnore:   []string{"html"},
+			TransferComplete: []string{"asp", "bat", "exe", "com"},
+			TTL:              2 * time.Hour,
+			MaxConnections:   1000,
+			ISTag:            `"W3E4R7U9-L2E4-2"`,
+			Service:          "FOO Tech Server 1.0",
+			Date:             time.Date(2000, time.January, 10, 9, 55, 21, 0, time.UTC),
+		}
+
+		if !reflect.DeepEqual(opts, wanted) {
+			t.Logf("Wanted the options: %+v, got: %+v", wanted, opts)
+			t.Fail()
+		}
+
+		type testSample struct {
+			header string
+			value  string
+		}
+
+		sampleTable := []testSample{
+			{header: MethodsHeader, value: "RESPMOD, GET"},
+			{header: AllowHeader, value: "two-o-four"},
+			{header: PreviewHeader, value: "1k"},
+			{header: PreviewHeader, value: "-1"},
+			{header: PreviewHeader, value: "9223372036854775807"},
+			{header: PreviewHeader, value: "2147483648"},
+			{header: OptionsTTLHeader, value: "9223372036854775807"},
+			{header: MaxConnectionsHeader, value: "2147483648"},
+			{header: OptionsTTLHeader, value: "1h"},
+			{header: MaxConnectionsHeader, value: "0"},
+			{header: MaxConnectionsHeader, value: "many"},
+			{header: TransferPreviewHeader, value: "*, .p df"},
+			{header: TransferIgnoreHeader, value: "jpg; gif"},
+			{header: TransferCompleteHeader, value: "ex\"e"},
+			{header: DateHeader, value: "yesterday"},
+		}
+
+		for _, sample := range sampleTable {
+			resp := &Response{Header: http.Header{}}
+			resp.Header.Set(sample.header, sample.value)
+
+			if _, err := resp.Options(); !errors.Is(err, ErrInvalidOptionsHeader) {
+				t.Logf("Wanted error:%s for %s: %s, got:%v", ErrInvalidOptionsHeader, sample.header, sample.value, err)
+				t.Fail()
+			}
+		}
+	})
+
+	t.Run("Client negotiates & caches the options", func(t *testing.T) {
+
+		var mu sync.Mutex
+		istag := `"TAG-1"`
+		ttl := "3600"
+
+		srv := newFakeICAPServer(t, func(req *fakeICAPRequest) string {
+			mu.Lock()
+			defer mu.Unlock()
+
+			if req.method == MethodOPTIONS {
+				return optionsResponse("Methods: RESPMOD", "Allow: 204", "Preview: 4", "Options-TTL: "+ttl,
+					"ISTag: "+istag)
+			}
+			return noContentResponse(istag)
+		})
+		defer srv.close()
+
+		client := &Client{NegotiateOptions: true}
+		defer client.CloseIdleConnections()
+
+		respmod := func() (*Response, error) {
+			httpResp := &http.Response{
+				Status:     "200 OK",
+				StatusCode: http.StatusOK,
+				Proto:      "HTTP/1.1",
+				ProtoMajor: 1,
+				ProtoMinor: 1,
+				Header:     http.Header{},
+				Body:       ioutil.NopCloser(strings.NewReader("Hello World")),
+			}
+
+			req, err := NewRequest(MethodRESPMOD, srv.url("/respmod"), nil, httpResp)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			return client.Do(req)
+		}
+
+		for i := 0; i < 3; i++ {
+			if _, err := respmod(); err != nil {
+				t.Fatal(err.Error())
+			}
+		}
+
+		if n := len(srv.received(MethodOPTIONS)); n != 1 {
+			t.Logf("Wanted the OPTIONS to be fetched:%d times, got:%d", 1, n)
+			t.Fail()
+		}
+
+		for _, req := range srv.received(MethodRESPMOD) {
+			if req.header.Get(PreviewHeader) != "4" || req.header.Get(AllowHeader) != "204" {
+				t.Logf("Wanted the preview:%s & Allow:%s applied, got:%s & %s", "4", "204", req.header.Get(PreviewHeader),
+					req.header.Get(AllowHeader))
+				t.Fail()
+			}
+
+			if string(req.body) != "Hello World" {
+				t.Logf("Wanted the server to get the entire body:%s, got:%s", "Hello World", string(req.body))
+				t.Fail()
+			}
+		}
+
+		mu.Lock()
+		istag = `"TAG-2"` // the service changes, so the options are to be fetched again after the next call
+		mu.Unlock()
+
+		for i := 0; i < 2; i++ {
+			if _, err := respmod(); err != nil {
+				t.Fatal(err.Error())
+			}
+		}
+
+		if n := len(srv.received(MethodOPTIONS)); n != 2 {
+			t.Logf("Wanted the OPTIONS to be fetched again on the ISTag change, got fetched:%d times", n)
+			t.Fail()
+		}
+
+		mu.Lock()
+		ttl = "1"
+		istag = `"TAG-3"`
+		mu.Unlock()
+
+		if _, err := respmod(); err != nil { // dropping the options for the ISTag change, the next ones expire in a second
+			t.Fatal(err.Error())
+		}
+
+		if _, err := respmod(); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		time.Sleep(1100 * time.Millisecond)
+
+		if _, err := respmod(); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if n := len(srv.received(MethodOPTIONS)); n != 4 {
+			t.Logf("Wanted the OPTIONS to be fetched again once expired, got fetched:%d times", n)
+			t.Fail()
+		}
+
+		httpReq, _ := http.NewRequest(http.MethodGet, "http://someurl.com", nil)
+
+		req, err := NewRequest(MethodREQMOD, srv.url("/respmod"), httpReq, nil)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if _, err := client.Do(req); !errors.Is(err, ErrMethodNotAllowed) {
+			t.Logf("Wanted error:%s for the method the service doesn't support, got:%v", ErrMethodNotAllowed, err)
+			t.Fail()
+		}
+
+		if n := len(srv.received(MethodREQMOD)); n != 0 {
+			t.Logf("Wanted no REQMOD call to the service, got:%d", n)
+			t.Fail()
+		}
+	})
+
+	t.Run("Client applies the options to the call, not to the request", func(t *testing.T) {
+
+		var allow204 int32
+
+		srv := newFakeICAPServer(t, func(req *fakeICAPRequest) string {
+			if req.method == MethodOPTIONS {
+				if atomic.LoadInt32(&allow204) == 1 {
+					return optionsResponse("Methods: RESPMOD", "Allow: 204", "Preview: 4", "ISTag: TAG")
+				}
+				return optionsResponse("Methods: RESPMOD", "Preview: 4", "ISTag: TAG")
+			}
+			return noContentResponse("TAG")
+		})
+		defer srv.close()
+
+		httpResp := &http.Response{
+			Status:     "200 OK",
+			StatusCode: http.StatusOK,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     http.Header{},
+		}
+
+		req, err := NewRequest(MethodRESPMOD, srv.url("/respmod"), nil, httpResp)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		for i, wantedAllow := range []string{"", "204"} { // the same request made again once the service allows 204
+			atomic.StoreInt32(&allow204, int32(i))
+			httpResp.Body = ioutil.NopCloser(strings.NewReader("Hello World"))
+
+			client := &Client{NegotiateOptions: true}
+
+			if _, err := client.Do(req); err != nil {
+				t.Fatal(err.Error())
+			}
+			client.CloseIdleConnections()
+
+			received := srv.received(MethodRESPMOD)
+			last := received[len(received)-1]
+
+			if last.header.Get(AllowHeader) != wantedAllow || last.header.Get(PreviewHeader) != "4" {
+				t.Logf("Wanted the call %d with the Allow:%q & the preview:%s, got:%q & %q", i, wantedAllow, "4",
+					last.header.Get(AllowHeader), last.header.Get(PreviewHeader))
+				t.Fail()
+			}
+
+			if req.Header.Get(AllowHeader) != "204" || req.PreviewBytes != 0 {
+				t.Logf("Wanted the request left with the Allow:%s & no preview, got:%q & %d", "204",
+					req.Header.Get(AllowHeader), req.PreviewBytes)
+				t.Fail()
+			}
+		}
+	})
+
+	t.Run("Client cuts down the preview advertised", func(t *testing.T) {
+
+		srv := newFakeICAPServer(t, func(req *fakeICAPRequest) string {
+			if req.method == MethodOPTIONS {
+				return optionsResponse("Methods: RESPMOD", "Preview: 2000000000", "ISTag: TAG")
+			}
+			return noContentResponse("TAG")
+		})
+		defer srv.close()
+
+		client := &Client{NegotiateOptions: true}
+		defer client.CloseIdleConnections()
+
+		httpResp := &http.Response{
+			Status:        "200 OK",
+			StatusCode:    http.StatusOK,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        http.Header{},
+			ContentLength: -1,
+			Body:          ioutil.NopCloser(strings.NewReader("Hello World")),
+		}
+
+		req, err := NewRequest(MethodRESPMOD, srv.url("/respmod"), nil, httpResp)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if _, err := client.Do(req); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		received := srv.received(MethodRESPMOD)
+
+		if len(received) != 1 || received[0].header.Get(PreviewHeader) != strconv.Itoa(MaxPreviewSize) ||
+			string(received[0].body) != "Hello World" {
+			t.Fatalf("Wanted a call with the preview:%d & the body:%s, got:%d calls", MaxPreviewSize, "Hello World", len(received))
+		}
+	})
+
+	t.Run("Client fetches the options once for concurrent calls", func(t *testing.T) {
+
+		srv := newFakeICAPServer(t, func(req *fakeICAPRequest) string {
+			if req.method == MethodOPTIONS {
+				time.Sleep(20 * time.Millisecond)
+				return optionsResponse("Methods: REQMOD", "ISTag: TAG")
+			}
+			return noContentResponse("TAG")
+		})
+		defer srv.close()
+
+		client := &Client{NegotiateOptions: true}
+		defer client.CloseIdleConnections()
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 10)
+
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				httpReq, _ := http.NewRequest(http.MethodPost, "http://someurl.com", strings.NewReader("Hello World"))
+
+				req, err := NewRequest(MethodREQMOD, srv.url("/reqmod"), httpReq, nil)
+				if err != nil {
+					errs <- err
+					return
+				}
+
+				if _, err := client.Do(req); err != nil {
+					errs <- err
+				}
+			}()
+		}
+
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			t.Log(err.Error())
+			t.Fail()
+		}
+
+		if n := len(srv.received(MethodOPTIONS)); n != 1 {
+			t.Logf("Wanted the OPTIONS to be fetched:%d times, got:%d", 1, n)
+			t.Fail()
+		}
+
+		for _, req := range srv.received(MethodREQMOD) {
+			if _, ok := req.header[PreviewHeader]; ok || req.header.Get(AllowHeader) != "" {
+				t.Logf("Wanted no preview & no Allow: 204 for the service not supporting them, got:%v", req.header)
+				t.Fail()
+				break
+			}
+		}
+	})
+
+}
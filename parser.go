@@ -2,7 +2,6 @@ package icapclient
 
 import (
 	"fmt"
-	"regexp"
 	"strconv"
 	"strings"
 )
@@ -21,71 +20,38 @@ func getStatusWithCode(str1, str2 string) (int, string, error) {
 	return statusCode, status, nil
 }
 
-// getHeaderVal parses the header and its value from a tcp message string
-func getHeaderVal(str string) (string, string) {
+// encapsulatedHeaderValue generates the Encapsulated header value from the lengths of the encapsulated http headers,
+// for example: req-hdr=0, res-hdr=137, res-body=296
+func encapsulatedHeaderValue(method string, reqHdrLen, respHdrLen int, hasBody bool) string {
 
-	headerVals := strings.SplitN(str, ":", 2)
-	header := headerVals[0]
-	val := ""
-
-	if len(headerVals) >= 2 {
-		val = strings.TrimSpace(headerVals[1])
+	if method == MethodOPTIONS { // the OPTIONS requests don't encapsulate any http message
+		return "null-body=0"
 	}
 
-	return header, val
-
-}
-
-// isRequestLine determines if the tcp message string is a request line, i.e the first line of the message or not
-func isRequestLine(str string) bool {
-	return strings.Contains(str, ICAPVersion) || strings.Contains(str, HTTPVersion)
-}
+	entities := []string{}
+	offset := 0
 
-// setEncapsulatedHeaderValue generates the Encapsulated  values and assigns to the ICAP request string
-func setEncapsulatedHeaderValue(icapReqStr *string, httpReqStr, httpRespStr string) {
-	encpVal := " "
-
-	if strings.HasPrefix(*icapReqStr, MethodOPTIONS) { // if the request method is OPTIONS
-		if httpReqStr == "" && httpRespStr == "" { // the most common case for OPTIONS method, no Encapsulated body
-			encpVal += "null-body=0"
-		} else {
-			encpVal += "opt-body=0" // if there is an Encapsulated body
-		}
+	if reqHdrLen > 0 {
+		entities = append(entities, "req-hdr=0")
+		offset = reqHdrLen
 	}
 
-	if strings.HasPrefix(*icapReqStr, MethodREQMOD) || strings.HasPrefix(*icapReqStr, MethodRESPMOD) { // if the request method is RESPMOD or REQMOD
-		re := regexp.MustCompile(DoubleCRLF)                // looking for the match of the string \r\n\r\n, as that is the expression that seperates each blocks, i.e headers and bodies
-		reqIndices := re.FindAllStringIndex(httpReqStr, -1) // getting the offsets of the matches, tells us the starting/ending point of headers and bodies
-
-		reqEndsAt := 0 // this is needed to calculate the response headers by adding the last offset of the request block
-		if reqIndices != nil {
-			encpVal += "req-hdr=0"
-			reqEndsAt = reqIndices[0][1]
-			if len(reqIndices) > 1 { // indicating there is a body present for the request block, as length would have been 1 for a single match of \r\n\r\n
-				encpVal += fmt.Sprintf(", req-body=%d", reqIndices[0][1]) // assigning the starting point of the body
-				reqEndsAt = reqIndices[1][1]
-			} else if httpRespStr == "" {
-				encpVal += fmt.Sprintf(", null-body=%d", reqIndices[0][1])
-			}
-			if httpRespStr != "" {
-				encpVal += ", "
-			}
-		}
-
-		respIndices := re.FindAllStringIndex(httpRespStr, -1)
+	if respHdrLen > 0 {
+		entities = append(entities, fmt.Sprintf("res-hdr=%d", offset))
+		offset += respHdrLen
+	}
 
-		if respIndices != nil {
-			encpVal += fmt.Sprintf("res-hdr=%d", reqEndsAt)
-			if len(respIndices) > 1 {
-				encpVal += fmt.Sprintf(", res-body=%d", reqEndsAt+respIndices[0][1])
-			} else {
-				encpVal += fmt.Sprintf(", null-body=%d", reqEndsAt+respIndices[0][1])
-			}
+	body := "null-body"
+	if hasBody {
+		body = "req-body"
+		if method == MethodRESPMOD {
+			body = "res-body"
 		}
-
 	}
 
-	*icapReqStr = fmt.Sprintf(*icapReqStr, encpVal) // formatting the ICAP request Encapsulated header with the value
+	entities = append(entities, fmt.Sprintf("%s=%d", body, offset))
+
+	return strings.Join(entities, ", ")
 }
 
 // replaceRequestURIWithActualURL replaces the just the escaped portion of the url with the entire URL in the dumped request message
@@ -96,84 +62,80 @@ func replaceRequestURIWithActualURL(str *string, uri, url string) {
 	*str = strings.Replace(*str, uri, url, 1)
 }
 
-// addFullBodyInPreviewIndicator adds 0; ieof\r\n\r\n which indicates the entire body fitted in preview
-func addFullBodyInPreviewIndicator(str *string) {
-	*str = strings.TrimSuffix(*str, DoubleCRLF)
-	*str += fullBodyEndIndicatorPreviewMode
+// encapsulatedEntity is an entity of the Encapsulated header, i.e a section of the encapsulated message & the offset it starts at
+type encapsulatedEntity struct {
+	name   string
+	offset int
 }
 
-// splitBodyAndHeader separates header and body from a http message
-func splitBodyAndHeader(str string) (string, string, bool) {
-	ss := strings.SplitN(str, DoubleCRLF, 2)
-
-	if len(ss) < 2 || ss[1] == "" {
-		return "", "", false
-	}
-
-	headerStr := ss[0]
-	bodyStr := ss[1]
-
-	return headerStr, bodyStr, true
+// the rank of the Encapsulated header entities, in the order they must appear in, all the body entities share the last one
+var encapsulatedEntityRanks = map[string]int{
+	"req-hdr":   0,
+	"res-hdr":   1,
+	"req-body":  2,
+	"res-body":  2,
+	"null-body": 2,
+	"opt-body":  2,
 }
 
-// bodyAlreadyChunked determines if the http body is already chunked from the origin server or not
-func bodyAlreadyChunked(str string) bool {
-	_, bodyStr, ok := splitBodyAndHeader(str)
-
-	if !ok {
-		return false
-	}
+// parseEncapsulated parses the Encapsulated header value as RFC 3507 defines it, for example: res-hdr=0, res-body=223
+//
+// The header entities must be known, in order, with increasing offsets & end with a single body entity
+func parseEncapsulated(val string) ([]encapsulatedEntity, error) {
 
-	r := regexp.MustCompile("\\r\\n0(\\r\\n)+$")
-	return r.MatchString(bodyStr)
-
-}
+	entities := []encapsulatedEntity{}
 
-// parsePreviewBodyBytes parses the preview portion of the body and only keeps that in the message
-func parsePreviewBodyBytes(str *string, pb int) {
+	if strings.TrimSpace(val) == "" {
+		return nil, &EncapsulatedError{Value: val, Reason: "empty value"}
+	}
 
-	headerStr, bodyStr, ok := splitBodyAndHeader(*str)
+	for _, item := range strings.Split(val, ",") {
+		item = strings.TrimSpace(item)
+		kv := strings.SplitN(item, "=", 2)
 
-	if !ok {
-		return
-	}
+		if len(kv) != 2 {
+			return nil, &EncapsulatedError{Value: val, Entity: item, Reason: "entity not in name=offset form"}
+		}
 
-	bodyStr = bodyStr[:pb]
+		name := strings.ToLower(strings.TrimSpace(kv[0]))
 
-	*str = headerStr + DoubleCRLF + bodyStr
-}
+		rank, known := encapsulatedEntityRanks[name]
 
-// addHexaBodyByteNotations adds the hexadecimal byte notaions in the messages
-// for example: Hello World, becomes
-// b
-// Hello World
-// 0
-func addHexaBodyByteNotations(bodyStr *string) {
+		if !known {
+			return nil, &EncapsulatedError{Value: val, Entity: item, Reason: "unknown entity"}
+		}
 
-	bodyBytes := []byte(*bodyStr)
+		offset, err := strconv.Atoi(strings.TrimSpace(kv[1]))
 
-	*bodyStr = fmt.Sprintf("%x%s%s%s", len(bodyBytes), CRLF, *bodyStr, bodyEndIndicator)
-}
+		if err != nil || offset < 0 {
+			return nil, &EncapsulatedError{Value: val, Entity: item, Reason: "invalid offset"}
+		}
 
-// mergeHeaderAndBody merges the header and body of the http message togather
-func mergeHeaderAndBody(src *string, headerStr, bodyStr string) {
-	*src = headerStr + DoubleCRLF + bodyStr
-}
+		if offset > maxEncapsulatedHeaderSize { // the headers are read in the memory up to the offsets
+			return nil, &EncapsulatedError{Value: val, Entity: item, Reason: "offset too large"}
+		}
 
-func chunkBodyByBytes(bdyByte []byte, cl int) []byte {
+		if len(entities) > 0 {
+			prev := entities[len(entities)-1]
 
-	newBytes := []byte{}
+			if encapsulatedEntityRanks[prev.name] >= rank {
+				return nil, &EncapsulatedError{Value: val, Entity: item, Reason: "entity out of order or repeated"}
+			}
 
-	for i := 0; i < len(bdyByte); i += cl {
-		end := i + cl
-		if end > len(bdyByte) {
-			end = len(bdyByte)
+			if offset < prev.offset {
+				return nil, &EncapsulatedError{Value: val, Entity: item, Reason: "offset smaller than the previous one"}
+			}
 		}
 
-		newBytes = append(newBytes, []byte(fmt.Sprintf("%x\r\n", len(bdyByte[i:end]))+string(bdyByte[i:end]))...)
+		entities = append(entities, encapsulatedEntity{
+			name:   name,
+			offset: offset,
+		})
 	}
 
-	newBytes = append(newBytes, []byte(bodyEndIndicator)...)
+	if last := entities[len(entities)-1]; encapsulatedEntityRanks[last.name] != encapsulatedEntityRanks["null-body"] {
+		return nil, &EncapsulatedError{Value: val, Reason: "no body entity at the end"}
+	}
 
-	return newBytes
+	return entities, nil
 }
@@ -0,0 +1,197 @@
+package icapclient
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// connPool keeps the idle ICAP server connections keyed by the server address, so that they can be reused by the upcoming calls
+type connPool struct {
+	mu             sync.Mutex
+	idle           map[string][]*idleConn
+	open           map[string]int // the number of open connections for an address, idle ones included
+	maxConns       map[string]int // the Max-Connections value advertised by the ICAP server for an address
+	released       chan struct{}  // gets closed & replaced every time a connection is given back, wakes up the callers waiting for a free slot
+	maxIdlePerHost int
+	idleTimeout    time.Duration
+}
+
+// idleConn is a connection resting in the pool
+type idleConn struct {
+	conn  net.Conn
+	since time.Time
+}
+
+// newConnPool is the factory function for connPool
+func newConnPool(maxIdlePerHost int, idleTimeout time.Duration) *connPool {
+	return &connPool{
+		idle:           make(map[string][]*idleConn),
+		open:           make(map[string]int),
+		maxConns:       make(map[string]int),
+		released:       make(chan struct{}),
+		maxIdlePerHost: maxIdlePerHost,
+		idleTimeout:    idleTimeout,
+	}
+}
+
+// get returns a healthy idle connection for the address if there is one, dials a new one otherwise
+func (p *connPool) get(ctx context.Context, addr string, dial func(context.Context) (net.Conn, error)) (net.Conn, error) {
+	for {
+		p.mu.Lock()
+
+		if conn := p.popIdle(addr); conn != nil {
+			p.mu.Unlock()
+
+			if connAlive(conn) {
+				logDebug("Reusing an idle connection to ", addr)
+				return conn, nil
+			}
+
+			logDebug("Dropping a stale idle connection to ", addr)
+			p.discard(addr, conn)
+			continue
+		}
+
+		if max := p.maxConns[addr]; max > 0 && p.open[addr] >= max { // the server doesn't want any more connections, so waiting for one to be given back
+			released := p.released
+			p.mu.Unlock()
+
+			logfDebug("Waiting for a free connection as %s allows only %d connections", addr, max)
+
+			select {
+			case <-released:
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		p.open[addr]++
+		p.mu.Unlock()
+
+		conn, err := dial(ctx)
+
+		if err != nil {
+			p.mu.Lock()
+			p.open[addr]--
+			p.broadcast()
+			p.mu.Unlock()
+			return nil, err
+		}
+
+		return conn, nil
+	}
+}
+
+// put gives a connection back to the pool so that it can be reused, or closes it if the pool is full for the address
+func (p *connPool) put(addr string, conn net.Conn) {
+	p.mu.Lock()
+
+	maxIdle := p.maxIdlePerHost
+	if max := p.maxConns[addr]; max > 0 && max < maxIdle {
+		maxIdle = max
+	}
+
+	if len(p.idle[addr]) >= maxIdle {
+		p.mu.Unlock()
+		p.discard(addr, conn)
+		return
+	}
+
+	p.idle[addr] = append(p.idle[addr], &idleConn{
+		conn:  conn,
+		since: time.Now(),
+	})
+	p.broadcast()
+
+	p.mu.Unlock()
+}
+
+// discard closes a connection taken from the pool and frees its slot
+func (p *connPool) discard(addr string, conn net.Conn) {
+	conn.Close()
+
+	p.mu.Lock()
+	p.open[addr]--
+	p.broadcast()
+	p.mu.Unlock()
+}
+
+// setMaxConns records the Max-Connections value advertised by the ICAP server for the address
+func (p *connPool) setMaxConns(addr string, max int) {
+	p.mu.Lock()
+	p.maxConns[addr] = max
+	p.mu.Unlock()
+}
+
+// closeIdle closes all the idle connections in the pool
+func (p *connPool) closeIdle() {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = make(map[string][]*idleConn)
+	for addr, conns := range idle {
+		p.open[addr] -= len(conns)
+	}
+	p.broadcast()
+	p.mu.Unlock()
+
+	for _, conns := range idle {
+		for _, ic := range conns {
+			ic.conn.Close()
+		}
+	}
+}
+
+// popIdle takes out the most recently used idle connection of the address, closing the ones sitting idle for too long, the lock must be held
+func (p *connPool) popIdle(addr string) net.Conn {
+	conns := p.idle[addr]
+
+	for len(conns) > 0 {
+		ic := conns[len(conns)-1]
+		conns = conns[:len(conns)-1]
+
+		if p.idleTimeout > 0 && time.Since(ic.since) > p.idleTimeout { // the older ones behind are expired as well
+			for _, expired := range append(conns, ic) {
+				expired.conn.Close()
+				p.open[addr]--
+			}
+			conns = nil
+			p.broadcast()
+			break
+		}
+
+		p.idle[addr] = conns
+		return ic.conn
+	}
+
+	delete(p.idle, addr)
+	return nil
+}
+
+// broadcast wakes up everyone waiting for a free connection slot, the lock must be held
+func (p *connPool) broadcast() {
+	close(p.released)
+	p.released = make(chan struct{})
+}
+
+// connAlive determines if an idle connection is still usable, i.e the server has neither closed it nor sent anything unexpected on it
+func connAlive(conn net.Conn) bool {
+	if err := conn.SetReadDeadline(time.Now().Add(time.Millisecond)); err != nil {
+		return false
+	}
+
+	var b [1]byte
+	_, err := conn.Read(b[:])
+
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return false
+	}
+
+	if ne, ok := err.(net.Error); ok && ne.Timeout() { // nothing to read, which is exactly what is expected from an idle connection
+		return true
+	}
+
+	return false
+}
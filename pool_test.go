@@ -0,0 +1,196 @@
+package icapclient
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPool(t *testing.T) {
+
+	lstnr, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer lstnr.Close()
+
+	serverConns := make(chan net.Conn, 10)
+	go func() {
+		for {
+			conn, err := lstnr.Accept()
+			if err != nil {
+				return
+			}
+			serverConns <- conn
+		}
+	}()
+
+	addr := lstnr.Addr().String()
+	dials := 0
+	dial := func(ctx context.Context) (net.Conn, error) {
+		dials++
+		return (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	}
+
+	t.Run("Reuse idle connection", func(t *testing.T) {
+		dials = 0
+		pool := newConnPool(2, time.Minute)
+
+		conn, err := pool.get(context.Background(), addr, dial)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		<-serverConns
+
+		pool.put(addr, conn)
+
+		reused, err := pool.get(context.Background(), addr, dial)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if reused != conn {
+			t.Log("Wanted the idle connection to be reused, got a new one")
+			t.Fail()
+		}
+
+		if dials != 1 {
+			t.Logf("Wanted dials:%d, got:%d", 1, dials)
+			t.Fail()
+		}
+
+		pool.discard(addr, reused)
+	})
+
+	t.Run("Drop connection closed by the server", func(t *testing.T) {
+		dials = 0
+		pool := newConnPool(2, time.Minute)
+
+		conn, err := pool.get(context.Background(), addr, dial)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		srvConn := <-serverConns
+
+		pool.put(addr, conn)
+		srvConn.Close()
+		time.Sleep(10 * time.Millisecond)
+
+		fresh, err := pool.get(context.Background(), addr, dial)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		<-serverConns
+
+		if fresh == conn {
+			t.Log("Wanted the closed connection to be dropped, got it back")
+			t.Fail()
+		}
+
+		if pool.open[addr] != 1 {
+			t.Logf("Wanted open connections:%d, got:%d", 1, pool.open[addr])
+			t.Fail()
+		}
+
+		pool.discard(addr, fresh)
+	})
+
+	t.Run("Drop connection idle for too long", func(t *testing.T) {
+		dials = 0
+		pool := newConnPool(2, time.Millisecond)
+
+		conn, err := pool.get(context.Background(), addr, dial)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		<-serverConns
+
+		pool.put(addr, conn)
+		time.Sleep(5 * time.Millisecond)
+
+		fresh, err := pool.get(context.Background(), addr, dial)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		<-serverConns
+
+		if fresh == conn || dials != 2 {
+			t.Logf("Wanted the expired connection to be dropped & a new one dialed, got dials:%d", dials)
+			t.Fail()
+		}
+
+		pool.discard(addr, fresh)
+	})
+
+	t.Run("Max idle connections per host", func(t *testing.T) {
+		dials = 0
+		pool := newConnPool(1, time.Minute)
+
+		conn1, err := pool.get(context.Background(), addr, dial)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		<-serverConns
+
+		conn2, err := pool.get(context.Background(), addr, dial)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		<-serverConns
+
+		pool.put(addr, conn1)
+		pool.put(addr, conn2)
+
+		if len(pool.idle[addr]) != 1 || pool.open[addr] != 1 {
+			t.Logf("Wanted idle connections:%d & open connections:%d, got:%d & %d", 1, 1, len(pool.idle[addr]),
+				pool.open[addr])
+			t.Fail()
+		}
+
+		pool.closeIdle()
+
+		if pool.open[addr] != 0 {
+			t.Logf("Wanted open connections after closing the idle ones:%d, got:%d", 0, pool.open[addr])
+			t.Fail()
+		}
+	})
+
+	t.Run("Respect Max-Connections", func(t *testing.T) {
+		dials = 0
+		pool := newConnPool(2, time.Minute)
+		pool.setMaxConns(addr, 1)
+
+		conn, err := pool.get(context.Background(), addr, dial)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		<-serverConns
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		if _, err := pool.get(ctx, addr, dial); err != context.DeadlineExceeded {
+			t.Logf("Wanted error:%v while the only connection is in use, got:%v", context.DeadlineExceeded, err)
+			t.Fail()
+		}
+
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			pool.put(addr, conn)
+		}()
+
+		reused, err := pool.get(context.Background(), addr, dial)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if reused != conn || dials != 1 {
+			t.Logf("Wanted the released connection to be reused, got dials:%d", dials)
+			t.Fail()
+		}
+
+		pool.discard(addr, reused)
+	})
+
+}
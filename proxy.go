@@ -0,0 +1,133 @@
+package icapclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httputil"
+)
+
+// proxyScanKey is the context key of the error of the REQMOD scan of a request blocked by a ProxyScanner
+type proxyScanKey struct{}
+
+// ProxyScanner hooks the ICAP services into an httputil.ReverseProxy: the requests go through REQMOD at the Director or
+// the Rewrite stage & the responses through RESPMOD at the ModifyResponse stage, the options of the services are
+// negotiated for the preview & the 204 No Content. The messages adapted by the services replace the original ones
+//
+//	scanner := &ic.ProxyScanner{Client: client, REQMODURL: reqmodURL, RESPMODURL: respmodURL}
+//
+//	proxy := httputil.NewSingleHostReverseProxy(target)
+//	proxy.Director = scanner.Director(proxy.Director)
+//	proxy.ModifyResponse = scanner.ModifyResponse
+//	proxy.ErrorHandler = scanner.ErrorHandler
+//
+// The requests blocked or failed to scan are not sent upstream, their context is cancelled with the error of the scan
+// kept in it, for the ErrorHandler to write a 403 Forbidden for the *BlockedError & a 502 Bad Gateway for the others
+type ProxyScanner struct {
+	Client        *Client // the client making the ICAP calls (default: a client with the default settings)
+	REQMODURL     string  // the url of the REQMOD service, the requests are not scanned if empty
+	RESPMODURL    string  // the url of the RESPMOD service, the responses are not scanned if empty
+	BlockResponse bool    // replace the responses blocked with a synthetic 403 Forbidden response instead of returning a *BlockedError
+}
+
+// Director returns the Director of the reverse proxy running the director given, if any, & then scanning the request
+func (s *ProxyScanner) Director(director func(*http.Request)) func(*http.Request) {
+	return func(req *http.Request) {
+		if director != nil {
+			director(req)
+		}
+		s.scanRequest(req)
+	}
+}
+
+// Rewrite returns the Rewrite of the reverse proxy running the rewrite given, if any, & then scanning the outbound request
+func (s *ProxyScanner) Rewrite(rewrite func(*httputil.ProxyRequest)) func(*httputil.ProxyRequest) {
+	return func(pr *httputil.ProxyRequest) {
+		if rewrite != nil {
+			rewrite(pr)
+		}
+		s.scanRequest(pr.Out)
+	}
+}
+
+// ModifyResponse scans the upstream response with RESPMOD, replacing it with the one adapted by the service if any,
+// a *BlockedError is returned for the responses blocked unless BlockResponse is set
+func (s *ProxyScanner) ModifyResponse(resp *http.Response) error {
+
+	t := s.transport()
+
+	outResp, v, err := t.scanResponse(resp.Request, resp)
+
+	if err != nil {
+		return err
+	}
+
+	if v != nil && !v.Allowed() {
+		resp.Body.Close()
+
+		blocked, err := t.block(resp.Request, v)
+		if err != nil {
+			return err
+		}
+
+		*resp = *blocked
+		return nil
+	}
+
+	*resp = *outResp
+
+	return nil
+}
+
+// ErrorHandler is the ErrorHandler of the reverse proxy, writing a 403 Forbidden for the messages blocked & a
+// 502 Bad Gateway for the other errors, as the reverse proxy does by default
+func (s *ProxyScanner) ErrorHandler(w http.ResponseWriter, req *http.Request, err error) {
+
+	if scanErr, ok := req.Context().Value(proxyScanKey{}).(error); ok { // the request is not sent as its scan failed
+		err = scanErr
+	}
+
+	var blockedErr *BlockedError
+
+	if errors.As(err, &blockedErr) {
+		http.Error(w, blockedErr.Verdict.String(), http.StatusForbidden)
+		return
+	}
+
+	logDebug("The reverse proxy failed: ", err)
+	w.WriteHeader(http.StatusBadGateway)
+}
+
+// scanRequest scans the request with REQMOD, replacing it in place with the one to send on, the request blocked or
+// failed to scan gets its context cancelled with the error kept in it, so that it is not sent
+func (s *ProxyScanner) scanRequest(req *http.Request) {
+
+	outReq, v, err := s.transport().scanRequest(req)
+
+	if err == nil && v != nil && !v.Allowed() {
+		err = &BlockedError{Verdict: v}
+	}
+
+	if err != nil {
+		closeRequestBody(req)
+
+		ctx, cancel := context.WithCancel(context.WithValue(req.Context(), proxyScanKey{}, err))
+		cancel()
+
+		*req = *req.WithContext(ctx)
+		return
+	}
+
+	*req = *outReq
+}
+
+// transport returns the transport scanning the messages for the scanner
+func (s *ProxyScanner) transport() *ScanTransport {
+	return &ScanTransport{
+		Client:        s.Client,
+		REQMODURL:     s.REQMODURL,
+		RESPMODURL:    s.RESPMODURL,
+		BlockResponse: s.BlockResponse,
+		negotiate:     true,
+	}
+}
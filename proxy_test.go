@@ -0,0 +1,179 @@
+package icapclient
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestProxyScanner(t *testing.T) {
+
+	var originCalls int32
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&originCalls, 1)
+		body, _ := ioutil.ReadAll(r.Body)
+		switch r.URL.Path {
+		case "/eicar":
+			fmt.Fprint(w, "X5O!P%@AP[4\\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*")
+		case "/greeting":
+			fmt.Fprint(w, "Hello World")
+		default:
+			fmt.Fprintf(w, "%s %s %s", r.Method, r.URL.Path, body)
+		}
+	}))
+	defer origin.Close()
+
+	target, err := url.Parse(origin.URL)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	srv := newFakeICAPServer(t, func(req *fakeICAPRequest) string {
+		if req.method == MethodOPTIONS {
+			return optionsResponse("Methods: REQMOD, RESPMOD", "Allow: 204", "Preview: 4", "ISTag: TAG", "Transfer-Preview: *")
+		}
+		body := string(req.body)
+		switch {
+		case strings.Contains(body, "EICAR"):
+			return "ICAP/1.0 200 OK\r\nISTag: TAG\r\nX-Infection-Found: Type=0; Resolution=2; Threat=EICAR-Test-File;\r\n" +
+				"Encapsulated: res-hdr=0, null-body=26\r\n\r\nHTTP/1.1 403 Forbidden\r\n\r\n"
+		case req.path == "/reqmod" && strings.Contains(body, "rewrite"):
+			reqHdr := "POST /adapted HTTP/1.1\r\nHost: " + target.Host + "\r\n\r\n"
+			return fmt.Sprintf("ICAP/1.0 200 OK\r\nISTag: TAG\r\nEncapsulated: req-hdr=0, req-body=%d\r\n\r\n%s"+
+				"9\r\nrewritten\r\n0\r\n\r\n", len(reqHdr), reqHdr)
+		case req.path == "/respmod" && strings.Contains(body, "Hello World"):
+			resHdr := "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\n\r\n"
+			return fmt.Sprintf("ICAP/1.0 200 OK\r\nISTag: TAG\r\nEncapsulated: res-hdr=0, res-body=%d\r\n\r\n%s"+
+				"d\r\nGoodbye World\r\n0\r\n\r\n", len(resHdr), resHdr)
+		}
+		return noContentResponse("TAG")
+	})
+	defer srv.close()
+
+	client := &Client{}
+	defer client.CloseIdleConnections()
+
+	type testSample struct {
+		method            string
+		path              string
+		body              string
+		blockResponse     bool
+		wantedStatus      int
+		wantedBody        string
+		wantedOriginCalls int32
+	}
+
+	sampleTable := []testSample{
+		{
+			method:            http.MethodPost,
+			path:              "/upload",
+			body:              "some content scanned & sent on",
+			wantedStatus:      http.StatusOK,
+			wantedBody:        "POST /upload some content scanned & sent on",
+			wantedOriginCalls: 1,
+		},
+		{
+			method:            http.MethodPost,
+			path:              "/upload",
+			body:              "rewrite me",
+			wantedStatus:      http.StatusOK,
+			wantedBody:        "POST /adapted rewritten",
+			wantedOriginCalls: 1,
+		},
+		{
+			method:            http.MethodGet,
+			path:              "/greeting",
+			wantedStatus:      http.StatusOK,
+			wantedBody:        "Goodbye World",
+			wantedOriginCalls: 1,
+		},
+		{
+			method:       http.MethodPost,
+			path:         "/upload",
+			body:         "X5O!P%@AP[4\\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*",
+			wantedStatus: http.StatusForbidden,
+			wantedBody:   "infected: EICAR-Test-File\n",
+		},
+		{
+			method:            http.MethodGet,
+			path:              "/eicar",
+			wantedStatus:      http.StatusForbidden,
+			wantedBody:        "infected: EICAR-Test-File\n",
+			wantedOriginCalls: 1,
+		},
+		{
+			method:            http.MethodGet,
+			path:              "/eicar",
+			blockResponse:     true,
+			wantedStatus:      http.StatusForbidden,
+			wantedBody:        "infected: EICAR-Test-File",
+			wantedOriginCalls: 1,
+		},
+	}
+
+	proxies := map[string]func(scanner *ProxyScanner) *httputil.ReverseProxy{
+		"Director": func(scanner *ProxyScanner) *httputil.ReverseProxy {
+			proxy := httputil.NewSingleHostReverseProxy(target)
+			proxy.Director = scanner.Director(proxy.Director)
+			return proxy
+		},
+		"Rewrite": func(scanner *ProxyScanner) *httputil.ReverseProxy {
+			return &httputil.ReverseProxy{
+				Rewrite: scanner.Rewrite(func(pr *httputil.ProxyRequest) {
+					pr.SetURL(target)
+				}),
+			}
+		},
+	}
+
+	for name, newProxy := range proxies {
+		t.Run("ProxyScanner "+name, func(t *testing.T) {
+
+			for _, sample := range sampleTable {
+				scanner := &ProxyScanner{
+					Client:        client,
+					REQMODURL:     srv.url("/reqmod"),
+					RESPMODURL:    srv.url("/respmod"),
+					BlockResponse: sample.blockResponse,
+				}
+
+				proxy := newProxy(scanner)
+				proxy.ModifyResponse = scanner.ModifyResponse
+				proxy.ErrorHandler = scanner.ErrorHandler
+
+				calls := atomic.LoadInt32(&originCalls)
+
+				r := httptest.NewRequest(sample.method, sample.path, strings.NewReader(sample.body))
+				w := httptest.NewRecorder()
+
+				proxy.ServeHTTP(w, r)
+
+				if w.Code != sample.wantedStatus || w.Body.String() != sample.wantedBody {
+					t.Logf("Wanted %d:%q for %s %s, got:%d:%q", sample.wantedStatus, sample.wantedBody, sample.method,
+						sample.path, w.Code, w.Body.String())
+					t.Fail()
+				}
+
+				if got := atomic.LoadInt32(&originCalls) - calls; got != sample.wantedOriginCalls {
+					t.Logf("Wanted the origin called %d times for %s %s, got:%d", sample.wantedOriginCalls, sample.method,
+						sample.path, got)
+					t.Fail()
+				}
+			}
+
+			received := srv.received(MethodRESPMOD)
+			if last := received[len(received)-1]; last.header.Get(PreviewHeader) != "4" {
+				t.Logf("Wanted the responses scanned with a preview of 4, got:%q", last.header.Get(PreviewHeader))
+				t.Fail()
+			}
+		})
+	}
+
+}
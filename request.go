@@ -1,27 +1,34 @@
 package icapclient
 
 import (
+	"bytes"
 	"context"
-	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
 	"strings"
 )
 
 // Request represents the icap client request data
 type Request struct {
-	Method                string
-	URL                   *url.URL
-	Header                http.Header
-	HTTPRequest           *http.Request
-	HTTPResponse          *http.Response
-	ChunkLength           int
-	PreviewBytes          int
-	ctx                   *context.Context
-	previewSet            bool
-	bodyFittedInPreview   bool
-	remainingPreviewBytes []byte
+	Method              string
+	URL                 *url.URL
+	Header              http.Header
+	HTTPRequest         *http.Request
+	HTTPResponse        *http.Response
+	ChunkLength         int // the size of the chunks the body is sent in (default: 512)
+	PreviewBytes        int
+	GetBody             func() (io.ReadCloser, error) // returns a new copy of the encapsulated body for the retries, the GetBody of the HTTPRequest is used for REQMOD if not set
+	ctx                 *context.Context
+	previewSet          bool
+	bodyFittedInPreview bool
+	scktDriver          *Driver     // the socket driver of the call in progress for the request
+	server              *poolServer // the server of the server pool the call in progress is made with
+	pinned              bool        // the call is to be made with the url of the request even if the client has a server pool
+	negotiate           bool        // the options of the service are negotiated for the call even if the client doesn't negotiate them
+	origin              *Request    // the request the call is made for, before the options are applied to the call
+	sentHTTPHeader      []byte      // the encapsulated http headers of the message to adapt, as sent last
 }
 
 // NewRequest is the factory function for Request
@@ -52,109 +59,61 @@ func NewRequest(method, urlStr string, httpReq *http.Request, httpResp *http.Res
 
 // DumpRequest returns the given request in its ICAP/1.x wire
 // representation.
+//
+// The encapsulated body is read in memory to be dumped & then put back, so the request can still be sent afterwards.
+// The client streams the requests to the server instead of dumping them.
 func DumpRequest(req *Request) ([]byte, error) {
 
-	// Making the ICAP message block
-
-	reqStr := fmt.Sprintf("%s %s %s%s", req.Method, req.URL.String(), ICAPVersion, CRLF)
-
-	for headerName, vals := range req.Header {
-		for _, val := range vals {
-			reqStr += fmt.Sprintf("%s: %s%s", headerName, val, CRLF)
-		}
-	}
-
-	reqStr += "Encapsulated: %s" + CRLF // will populate the Encapsulated header value after making the http Request & Response messages
-	reqStr += CRLF
-
-	// Making the HTTP Request message block
-
-	httpReqStr := ""
-	if req.HTTPRequest != nil {
-		b, err := httputil.DumpRequestOut(req.HTTPRequest, true)
+	if body := req.body(); body != nil {
+		b, err := ioutil.ReadAll(body)
 
 		if err != nil {
 			return nil, err
 		}
 
-		httpReqStr += string(b)
-		replaceRequestURIWithActualURL(&httpReqStr, req.HTTPRequest.URL.EscapedPath(), req.HTTPRequest.URL.String())
-
-		if req.Method == MethodREQMOD {
-			if req.previewSet {
-				parsePreviewBodyBytes(&httpReqStr, req.PreviewBytes)
-			}
-
-			if !bodyAlreadyChunked(httpReqStr) {
-				headerStr, bodyStr, ok := splitBodyAndHeader(httpReqStr)
-				if ok {
-					addHexaBodyByteNotations(&bodyStr)
-					mergeHeaderAndBody(&httpReqStr, headerStr, bodyStr)
-				}
-			}
-
-		}
-
-		if httpReqStr != "" { // if the HTTP Request message block doesn't end with a \r\n\r\n, then going to add one by force for better calculation of byte offsets
-			for !strings.HasSuffix(httpReqStr, DoubleCRLF) {
-				httpReqStr += CRLF
-			}
-		}
-
+		req.setBody(&readCloser{Reader: bytes.NewReader(b), Closer: body})
+		defer req.setBody(&readCloser{Reader: bytes.NewReader(b), Closer: body})
 	}
 
-	// Making the HTTP Response message block
+	buf := &bytes.Buffer{}
 
-	httpRespStr := ""
-	if req.HTTPResponse != nil {
-		b, err := httputil.DumpResponse(req.HTTPResponse, true)
-
-		if err != nil {
-			return nil, err
-		}
-
-		httpRespStr += string(b)
-
-		if req.previewSet {
-			parsePreviewBodyBytes(&httpRespStr, req.PreviewBytes)
-		}
+	if err := newRequestEncoder(buf, req).encode(); err != nil {
+		return nil, err
+	}
 
-		if !bodyAlreadyChunked(httpRespStr) {
-			headerStr, bodyStr, ok := splitBodyAndHeader(httpRespStr)
-			if ok {
-				addHexaBodyByteNotations(&bodyStr)
-				mergeHeaderAndBody(&httpRespStr, headerStr, bodyStr)
-			}
-		}
+	return buf.Bytes(), nil
+}
 
-		if httpRespStr != "" && !strings.HasSuffix(httpRespStr, DoubleCRLF) { // if the HTTP Response message block doesn't end with a \r\n\r\n, then going to add one by force for better calculation of byte offsets
-			httpRespStr += CRLF
-		}
+// forCall returns the copy of the request a call is made with, with a header of its own, the encapsulated http
+// messages are shared
+func (r *Request) forCall() *Request {
+	call := *r
+	call.Header = r.Header.Clone()
+	call.origin = r
 
-	}
-
-	if encpVal := req.Header.Get(EncapsulatedHeader); encpVal != "" {
-		reqStr = fmt.Sprintf(reqStr, encpVal)
-	} else {
-		//populating the Encapsulated header of the ICAP message portion
-		setEncapsulatedHeaderValue(&reqStr, httpReqStr, httpRespStr)
-	}
+	return &call
+}
 
-	// determining if the http message needs the full body fitted in the preview portion indicator or not
-	if httpRespStr != "" && req.previewSet && req.bodyFittedInPreview {
-		addFullBodyInPreviewIndicator(&httpRespStr)
-	}
+// resetOptions takes the options applied off the call, leaving the header & the preview as the request it is made for
+func (r *Request) resetOptions() {
 
-	if req.Method == MethodREQMOD && req.previewSet && req.bodyFittedInPreview {
-		addFullBodyInPreviewIndicator(&httpReqStr)
+	if r.origin == nil {
+		return
 	}
 
-	data := []byte(reqStr + httpReqStr + httpRespStr)
-
-	return data, nil
+	r.Header = r.origin.Header.Clone()
+	r.PreviewBytes, r.previewSet, r.bodyFittedInPreview = r.origin.PreviewBytes, r.origin.previewSet, r.origin.bodyFittedInPreview
 }
 
-// SetContext sets a context for the ICAP request
-func (r *Request) SetContext(ctx context.Context) { // TODO: make context take control over the whole operation
+// SetContext sets a context for the ICAP request, the context governs the entire exchange, see Client.DoContext
+func (r *Request) SetContext(ctx context.Context) {
 	r.ctx = &ctx
 }
+
+// chunkLength returns the size of the chunks the body is to be sent in
+func (r *Request) chunkLength() int {
+	if r.ChunkLength > 0 {
+		return r.ChunkLength
+	}
+	return defaultChunkLength
+}
@@ -22,7 +22,7 @@ func TestRequest(t *testing.T) {
 			httpReq   *http.Request
 			httpResp  *http.Response
 			err       error
-			errStr    string
+			errField  string
 		}
 
 		sampleTable := []testSample{
@@ -32,7 +32,7 @@ func TestRequest(t *testing.T) {
 				httpReq:   nil,
 				httpResp:  nil,
 				err:       nil,
-				errStr:    "",
+				errField:  "",
 			},
 			{
 				urlStr:    "icap://localhost:1344/something",
@@ -40,7 +40,7 @@ func TestRequest(t *testing.T) {
 				httpReq:   nil,
 				httpResp:  &http.Response{},
 				err:       nil,
-				errStr:    "",
+				errField:  "",
 			},
 			{
 				urlStr:    "icap://localhost:1344/something",
@@ -48,62 +48,81 @@ func TestRequest(t *testing.T) {
 				httpReq:   &http.Request{},
 				httpResp:  nil,
 				err:       nil,
-				errStr:    "",
+				errField:  "",
+			},
+			{
+				urlStr:    "icaps://localhost:11344/something",
+				reqMethod: MethodOPTIONS,
+				httpReq:   nil,
+				httpResp:  nil,
+				err:       nil,
+				errField:  "",
 			},
 			{
 				urlStr:    "icap://localhost:1344/something",
 				reqMethod: "invalid",
 				httpReq:   nil,
 				httpResp:  nil,
-				err:       errors.New(ErrMethodNotRegistered),
-				errStr:    ErrMethodNotRegistered,
+				err:       ErrMethodNotRegistered,
+				errField:  "Method",
 			},
 			{
 				urlStr:    "http://localhost:1344/something",
 				reqMethod: MethodOPTIONS,
 				httpReq:   nil,
 				httpResp:  nil,
-				err:       errors.New(ErrInvalidScheme),
-				errStr:    ErrInvalidScheme,
+				err:       ErrInvalidScheme,
+				errField:  "URL",
 			},
 			{
 				urlStr:    "icap://",
 				reqMethod: MethodOPTIONS,
 				httpReq:   nil,
 				httpResp:  nil,
-				err:       errors.New(ErrInvalidHost),
-				errStr:    ErrInvalidHost,
+				err:       ErrInvalidHost,
+				errField:  "URL",
 			},
 			{
 				urlStr:    "icap://localhost:1344/something",
 				reqMethod: MethodREQMOD,
 				httpReq:   nil,
 				httpResp:  nil,
-				err:       errors.New(ErrREQMODWithNoReq),
-				errStr:    ErrREQMODWithNoReq,
+				err:       ErrREQMODWithNoReq,
+				errField:  "HTTPRequest",
 			},
 			{
 				urlStr:    "icap://localhost:1344/something",
 				reqMethod: MethodREQMOD,
 				httpReq:   &http.Request{},
 				httpResp:  &http.Response{},
-				err:       errors.New(ErrREQMODWithResp),
-				errStr:    ErrREQMODWithResp,
+				err:       ErrREQMODWithResp,
+				errField:  "HTTPResponse",
 			},
 			{
 				urlStr:    "icap://localhost:1344/something",
 				reqMethod: MethodRESPMOD,
 				httpReq:   &http.Request{},
 				httpResp:  nil,
-				err:       errors.New(ErrRESPMODWithNoResp),
-				errStr:    ErrRESPMODWithNoResp,
+				err:       ErrRESPMODWithNoResp,
+				errField:  "HTTPResponse",
 			},
 		}
 
 		for _, sample := range sampleTable {
-			if _, err := NewRequest(sample.reqMethod, sample.urlStr, sample.httpReq, sample.httpResp); !reflect.DeepEqual(err,
-				sample.err) {
-				t.Logf("Wanted error: %v, got: %v", sample.err, err)
+			_, err := NewRequest(sample.reqMethod, sample.urlStr, sample.httpReq, sample.httpResp)
+
+			if sample.err == nil {
+				if err != nil {
+					t.Logf("Wanted no error, got: %v", err)
+					t.Fail()
+				}
+				continue
+			}
+
+			var validationErr *ValidationError
+
+			if !errors.Is(err, sample.err) || !errors.As(err, &validationErr) || validationErr.Field != sample.errField {
+				t.Logf("Wanted error: %v for the field: %s, got: %v", sample.err, sample.errField, err)
 				t.Fail()
 			}
 		}
@@ -121,7 +140,7 @@ func TestRequest(t *testing.T) {
 		}
 
 		wanted := "OPTIONS icap://localhost:1344/something ICAP/1.0\r\n" +
-			"Encapsulated:  null-body=0\r\n\r\n"
+			"Encapsulated: null-body=0\r\n\r\n"
 
 		got := string(b)
 
@@ -143,7 +162,7 @@ func TestRequest(t *testing.T) {
 		}
 
 		wanted := "REQMOD icap://localhost:1344/something ICAP/1.0\r\n" +
-			"Encapsulated:  req-hdr=0, null-body=109\r\n\r\n" +
+			"Encapsulated: req-hdr=0, null-body=109\r\n\r\n" +
 			"GET http://someurl.com HTTP/1.1\r\n" +
 			"Host: someurl.com\r\n" +
 			"User-Agent: Go-http-client/1.1\r\n" +
@@ -166,7 +185,7 @@ func TestRequest(t *testing.T) {
 		}
 
 		wanted = "REQMOD icap://localhost:1344/something ICAP/1.0\r\n" +
-			"Encapsulated:  req-hdr=0, req-body=130\r\n\r\n" +
+			"Encapsulated: req-hdr=0, req-body=130\r\n\r\n" +
 			"POST http://someurl.com HTTP/1.1\r\n" +
 			"Host: someurl.com\r\n" +
 			"User-Agent: Go-http-client/1.1\r\n" +
@@ -209,13 +228,12 @@ func TestRequest(t *testing.T) {
 		}
 
 		wanted := "RESPMOD icap://localhost:1344/something ICAP/1.0\r\n" +
-			"Encapsulated:  req-hdr=0, req-body=130, res-hdr=145, res-body=210\r\n\r\n" +
+			"Encapsulated: req-hdr=0, res-hdr=130, res-body=195\r\n\r\n" +
 			"POST http://someurl.com HTTP/1.1\r\n" +
 			"Host: someurl.com\r\n" +
 			"User-Agent: Go-http-client/1.1\r\n" +
 			"Content-Length: 11\r\n" +
 			"Accept-Encoding: gzip\r\n\r\n" +
-			"Hello World\r\n\r\n" +
 			"HTTP/1.0 200 OK\r\n" +
 			"Content-Length: 11\r\n" +
 			"Content-Type: plain/text\r\n\r\n" +
@@ -330,7 +348,7 @@ func TestRequest(t *testing.T) {
 			bodyStr               string
 			allocatedPreviewBytes int
 			previewHeaderValue    []string
-			remainingPreviewBytes []byte
+			wantedPreviewBody     string
 			bodyFittedInPreview   bool
 		}
 
@@ -341,7 +359,7 @@ func TestRequest(t *testing.T) {
 				bodyStr:               "Hello World! Bye Bye World!",
 				allocatedPreviewBytes: 11,
 				previewHeaderValue:    []string{"11"},
-				remainingPreviewBytes: []byte(`! Bye Bye World!`),
+				wantedPreviewBody:     "b\r\nHello World\r\n0\r\n\r\n",
 				bodyFittedInPreview:   false,
 			},
 			{
@@ -350,7 +368,7 @@ func TestRequest(t *testing.T) {
 				bodyStr:               "Hello!",
 				allocatedPreviewBytes: 6,
 				previewHeaderValue:    []string{"6"},
-				remainingPreviewBytes: nil,
+				wantedPreviewBody:     "6\r\nHello!\r\n0; ieof\r\n\r\n",
 				bodyFittedInPreview:   true,
 			},
 			{
@@ -359,7 +377,7 @@ func TestRequest(t *testing.T) {
 				bodyStr:               "Hello World! Bye Bye World!",
 				allocatedPreviewBytes: 11,
 				previewHeaderValue:    []string{"11"},
-				remainingPreviewBytes: []byte(`! Bye Bye World!`),
+				wantedPreviewBody:     "b\r\nHello World\r\n0\r\n\r\n",
 				bodyFittedInPreview:   false,
 			},
 			{
@@ -368,7 +386,7 @@ func TestRequest(t *testing.T) {
 				bodyStr:               "Hello!",
 				allocatedPreviewBytes: 6,
 				previewHeaderValue:    []string{"6"},
-				remainingPreviewBytes: nil,
+				wantedPreviewBody:     "6\r\nHello!\r\n0; ieof\r\n\r\n",
 				bodyFittedInPreview:   true,
 			},
 		}
@@ -406,6 +424,16 @@ func TestRequest(t *testing.T) {
 				t.Fail()
 			}
 
+			b, err := DumpRequest(req)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			if !strings.HasSuffix(string(b), sample.wantedPreviewBody) {
+				t.Logf("Wanted the request to end with the preview body: %q, got: %q", sample.wantedPreviewBody, string(b))
+				t.Fail()
+			}
+
 			var bdyBytes []byte
 
 			if sample.reqMethod == MethodREQMOD {
@@ -426,12 +454,6 @@ func TestRequest(t *testing.T) {
 				t.Fail()
 			}
 
-			if !reflect.DeepEqual(req.remainingPreviewBytes, sample.remainingPreviewBytes) {
-				t.Logf("Wanted remaining preview bytes: %s, got: %s", string(sample.remainingPreviewBytes),
-					string(req.remainingPreviewBytes))
-				t.Fail()
-			}
-
 			if req.bodyFittedInPreview != sample.bodyFittedInPreview {
 				t.Logf("Wanted body fitted in preview as: %v, got: %v", sample.bodyFittedInPreview, req.bodyFittedInPreview)
 				t.Fail()
@@ -2,13 +2,19 @@ package icapclient
 
 import (
 	"bufio"
-	"errors"
+	"bytes"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"net/textproto"
 	"strconv"
 	"strings"
 )
 
 // Response represents the icap server response data
+//
+// The body of the ContentRequest or the ContentResponse is streamed right from the connection & de-chunked as it is read,
+// so it must be read till the end or closed for the connection to be given back or closed
 type Response struct {
 	StatusCode      int
 	Status          string
@@ -16,6 +22,14 @@ type Response struct {
 	Header          http.Header
 	ContentRequest  *http.Request
 	ContentResponse *http.Response
+	NotScanned      bool             // the call was not made as the service asks not to be sent the body(Transfer-Ignore) or it failed open, the response stands in for it
+	Breaker         *BreakerDecision // the decision of the circuit breaker if the response stands in for a call failed open
+	body            *bodyReader      // the encapsulated body being streamed, shared with the ContentRequest or the ContentResponse
+	method          string           // the ICAP method of the call the response is for, empty if not known
+	folded          http.Header      // the values of the headers folded over several lines, with the lines kept apart by "\n"
+	reqHdr          []byte           // the encapsulated http request headers as sent by the server, nil if there are none
+	respHdr         []byte           // the encapsulated http response headers as sent by the server, nil if there are none
+	sentHTTPHeader  []byte           // the encapsulated http headers of the message the call sent to adapt, nil if not known
 }
 
 var (
@@ -36,88 +50,266 @@ var (
 )
 
 // ReadResponse converts a Reader to a icapclient Response
+//
+// The encapsulated http headers are cut out of the message using the offsets of the Encapsulated header,
+// the body is left in the Reader to be streamed as it is read
 func ReadResponse(b *bufio.Reader) (*Response, error) {
+	return readResponse(b, nil)
+}
+
+// readResponse reads the ICAP response from the Reader, done is called once the encapsulated body is read or closed
+func readResponse(b *bufio.Reader, done func(fullyRead bool)) (*Response, error) {
+
+	tp := textproto.NewReader(b)
+
+	statusLine, err := tp.ReadLine() // for example: ICAP/1.0 200 OK
+
+	if err != nil {
+		return nil, err
+	}
 
-	resp := &Response{
-		Header: make(map[string][]string),
+	ss := strings.SplitN(statusLine, " ", 3)
+
+	if len(ss) < 3 || !strings.HasPrefix(ss[0], "ICAP/") { // must contain 3 words, for example: "ICAP/1.0 200 OK"
+		return nil, &ProtocolError{Line: statusLine, Err: ErrInvalidTCPMsg}
 	}
 
-	scheme := ""
-	httpMsg := ""
-	for currentMsg, err := b.ReadString('\n'); err == nil || currentMsg != ""; currentMsg, err = b.ReadString('\n') { // keep reading the buffer message which is the http response message
+	resp := &Response{}
+
+	resp.StatusCode, resp.Status, err = getStatusWithCode(ss[1], ss[2])
 
-		if isRequestLine(currentMsg) { // if the current message line if the first line of the message portion(request line)
-			ss := strings.Split(currentMsg, " ")
+	if err != nil {
+		return nil, err
+	}
 
-			if len(ss) < 3 { // must contain 3 words, for example: "ICAP/1.0 200 OK" or "GET /something HTTP/1.1"
-				return nil, errors.New(ErrInvalidTCPMsg + ":" + currentMsg)
-			}
+	resp.Header, resp.folded, err = readHeader(tp)
 
-			// preparing the scheme below
+	if err != nil {
+		return nil, err
+	}
 
-			if ss[0] == ICAPVersion {
-				scheme = SchemeICAP
-				resp.StatusCode, resp.Status, err = getStatusWithCode(ss[1], strings.Join(ss[2:], " "))
-				if err != nil {
-					return nil, err
-				}
-				continue
-			}
+	if pb := resp.Header.Get(PreviewHeader); pb != "" {
+		resp.PreviewBytes, _ = strconv.Atoi(pb)
+	}
 
-			if ss[0] == HTTPVersion {
-				scheme = SchemeHTTPResp
-				httpMsg = ""
-			}
+	encpVal := resp.Header.Get(EncapsulatedHeader)
 
-			if strings.TrimSpace(ss[2]) == HTTPVersion { // for a http request message if the scheme version is always at last, for example: GET /something HTTP/1.1
-				scheme = SchemeHTTPReq
-				httpMsg = ""
-			}
+	if encpVal == "" { // no http message encapsulated, for example: 100 Continue
+		return resp, nil
+	}
+
+	entities, err := parseEncapsulated(encpVal)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// reading the encapsulated http headers section by section, each section ends where the next one starts
+
+	if entities[0].offset > 0 {
+		if _, err := io.CopyN(ioutil.Discard, b, int64(entities[0].offset)); err != nil {
+			return nil, err
 		}
+	}
 
-		// preparing the header for ICAP & contents for HTTP messages below
+	for i, entity := range entities[:len(entities)-1] {
+		section := make([]byte, entities[i+1].offset-entity.offset)
 
-		if scheme == SchemeICAP {
-			if currentMsg == LF || currentMsg == CRLF { // don't want to count the Line Feed as header
-				continue
-			}
-			header, val := getHeaderVal(currentMsg)
-			if header == PreviewHeader {
-				pb, _ := strconv.Atoi(val)
-				resp.PreviewBytes = pb
-			}
-			resp.Header.Add(header, val)
+		if _, err := io.ReadFull(b, section); err != nil {
+			return nil, err
 		}
 
-		if scheme == SchemeHTTPReq {
-			httpMsg += strings.TrimSpace(currentMsg) + CRLF
-			bufferEmpty := b.Buffered() == 0
-			if currentMsg == CRLF || bufferEmpty { // a CRLF indicates the end of a http message and the buffer check is just in case the buffer eneded with one last message instead of a CRLF
-				var erR error
-				resp.ContentRequest, erR = http.ReadRequest(bufio.NewReader(strings.NewReader(httpMsg)))
-				if erR != nil {
-					return nil, erR
-				}
-				continue
-			}
+		switch entity.name {
+		case "req-hdr":
+			resp.reqHdr = section
+		case "res-hdr":
+			resp.respHdr = section
 		}
+	}
 
-		if scheme == SchemeHTTPResp {
-			httpMsg += strings.TrimSpace(currentMsg) + CRLF
-			bufferEmpty := b.Buffered() == 0
-			if currentMsg == CRLF || bufferEmpty {
-				var erR error
-				resp.ContentResponse, erR = http.ReadResponse(bufio.NewReader(strings.NewReader(httpMsg)), resp.ContentRequest)
-				if erR != nil {
-					return nil, erR
-				}
-				continue
-			}
+	var body io.ReadCloser = http.NoBody
 
+	if last := entities[len(entities)-1]; last.name != "null-body" {
+		resp.body = &bodyReader{
+			cr:   newChunkedReader(b),
+			done: done,
 		}
+		body = resp.body
+	}
 
+	if resp.reqHdr != nil {
+		resp.ContentRequest, err = http.ReadRequest(bufio.NewReader(bytes.NewReader(resp.reqHdr)))
+		if err != nil {
+			return nil, err
+		}
+		resp.ContentRequest.Body = http.NoBody
+	}
+
+	if resp.respHdr != nil {
+		resp.ContentResponse, err = http.ReadResponse(bufio.NewReader(bytes.NewReader(resp.respHdr)), resp.ContentRequest)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case resp.ContentResponse != nil:
+		resp.ContentResponse.Body = body
+		if resp.body != nil {
+			resp.body.trailer = &resp.ContentResponse.Trailer
+		}
+	case resp.ContentRequest != nil:
+		resp.ContentRequest.Body = body
+		if resp.body != nil {
+			resp.body.trailer = &resp.ContentRequest.Trailer
+		}
+	case resp.body != nil: // a body with no http message to carry it, for example: opt-body, read through so that the message ends
+		resp.body.done = nil // the body ends with the response, the connection is not to be let go of before that
+		_, err := io.Copy(ioutil.Discard, resp.body)
+		resp.body = nil
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	return resp, nil
 
 }
+
+// closeBody closes the encapsulated body of the response if there is one
+func (r *Response) closeBody() {
+	if r.body != nil {
+		r.body.Close()
+	}
+}
+
+// onBodyDone calls f once the encapsulated body of the response is read till the end or closed,
+// false is returned if there is no body left to read
+func (r *Response) onBodyDone(f func()) bool {
+
+	if r.body == nil || r.body.finished {
+		return false
+	}
+
+	done := r.body.done
+	r.body.done = func(fullyRead bool) {
+		if done != nil {
+			done(fullyRead)
+		}
+		f()
+	}
+
+	return true
+}
+
+// readHeader reads the ICAP headers, the values folded over several lines are returned with their lines kept apart
+// as well, as some vendors tell a list with them, for example: X-Violations-Found
+func readHeader(tp *textproto.Reader) (http.Header, http.Header, error) {
+
+	var block bytes.Buffer
+
+	values := http.Header{}
+	folded := http.Header{}
+	key := ""
+
+	for {
+		line, err := tp.ReadLine()
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		block.WriteString(line + CRLF)
+
+		if line == "" {
+			break
+		}
+
+		if (line[0] == ' ' || line[0] == '\t') && key != "" { // a continuation line of the last header
+			vals := values[key]
+			vals[len(vals)-1] += LF + strings.TrimSpace(line)
+			folded[key] = nil
+			continue
+		}
+
+		key = ""
+
+		if i := strings.IndexByte(line, ':'); i > 0 {
+			key = textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(line[:i]))
+			values[key] = append(values[key], strings.TrimSpace(line[i+1:]))
+		}
+	}
+
+	mimeHeader, err := textproto.NewReader(bufio.NewReader(&block)).ReadMIMEHeader() // the standard header, the folded values joined by spaces
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for key := range folded {
+		folded[key] = values[key]
+	}
+
+	return http.Header(mimeHeader), folded, nil
+}
+
+// bodyReader streams the encapsulated body of an ICAP response right from the connection, de-chunking it as it is read
+type bodyReader struct {
+	cr       *chunkedReader
+	trailer  *http.Header // the trailer of the http message carrying the body, filled in once the body is read till the end
+	done     func(fullyRead bool)
+	err      error
+	finished bool
+}
+
+// Read reads the de-chunked body
+func (b *bodyReader) Read(p []byte) (int, error) {
+
+	if b.err != nil {
+		return 0, b.err
+	}
+
+	n, err := b.cr.Read(p)
+
+	if err == io.EOF && b.trailer != nil && b.cr.trailer != nil { // the last chunk & the trailers following it are read
+		if *b.trailer == nil {
+			*b.trailer = http.Header{}
+		}
+		for key, vals := range b.cr.trailer {
+			(*b.trailer)[key] = vals
+		}
+	}
+
+	if err != nil {
+		b.err = err
+		b.finish(err == io.EOF)
+	}
+
+	return n, err
+}
+
+// Close closes the body, the connection can't be reused if the body is not read till the end
+func (b *bodyReader) Close() error {
+
+	if b.err == nil {
+		b.err = ErrBodyClosed
+	}
+
+	b.finish(false)
+
+	return nil
+}
+
+// finish lets the connection know the body is done with, only once
+func (b *bodyReader) finish(fullyRead bool) {
+
+	if b.finished {
+		return
+	}
+
+	b.finished = true
+
+	if b.done != nil {
+		b.done(fullyRead)
+	}
+}
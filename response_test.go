@@ -2,7 +2,10 @@ package icapclient
 
 import (
 	"bufio"
+	"fmt"
+	"io/ioutil"
 	"net/http"
+	"net/http/httputil"
 	"reflect"
 	"strings"
 	"testing"
@@ -10,7 +13,7 @@ import (
 
 func TestResponse(t *testing.T) {
 
-	t.Run("ReadResponse REQMOD", func(t *testing.T) { // FIXME: headers and content request aren't being tested properly
+	t.Run("ReadResponse REQMOD", func(t *testing.T) {
 
 		type testSample struct {
 			headers      http.Header
@@ -19,6 +22,7 @@ func TestResponse(t *testing.T) {
 			previewBytes int
 			respStr      string
 			httpReqStr   string
+			httpBodyStr  string
 		}
 
 		sampleTable := []testSample{
@@ -67,15 +71,15 @@ func TestResponse(t *testing.T) {
 					"Accept: text/html, text/plain, image/gif\r\n" +
 					"Accept-Encoding: gzip, compress\r\n" +
 					"Pragma: no-cache\r\n" +
-					"Content-Length: 45\r\n\r\n" +
-					"2d\r\n" +
+					"Content-Length: 45\r\n\r\n",
+				httpBodyStr: "2d\r\n" +
 					"I am posting this information.  ICAP powered!\r\n" +
 					"0\r\n\r\n",
 			},
 		}
 
 		for _, sample := range sampleTable {
-			resp, err := ReadResponse(bufio.NewReader(strings.NewReader(sample.respStr + sample.httpReqStr)))
+			resp, err := ReadResponse(bufio.NewReader(strings.NewReader(sample.respStr + sample.httpReqStr + sample.httpBodyStr)))
 			if err != nil {
 				t.Fatal(err.Error())
 			}
@@ -110,6 +114,23 @@ func TestResponse(t *testing.T) {
 				t.Fatal(err.Error())
 			}
 
+			wantedBody, err := ioutil.ReadAll(httputil.NewChunkedReader(strings.NewReader(sample.httpBodyStr)))
+			if err != nil && sample.httpBodyStr != "" {
+				t.Fatal(err.Error())
+			}
+
+			body, err := ioutil.ReadAll(resp.ContentRequest.Body)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			if string(body) != string(wantedBody) {
+				t.Logf("Wanted http request body: %q, got: %q", string(wantedBody), string(body))
+				t.Fail()
+			}
+
+			wantedHTTPReq.Body, resp.ContentRequest.Body = nil, nil // the bodies are compared already
+
 			if !reflect.DeepEqual(resp.ContentRequest, wantedHTTPReq) {
 				t.Logf("Wanted http request: %v, got: %v", wantedHTTPReq, resp.ContentRequest)
 				t.Fail()
@@ -127,7 +148,7 @@ func TestResponse(t *testing.T) {
 			previewBytes int
 			respStr      string
 			httpRespStr  string
-			httpReqStr   string
+			httpBodyStr  string
 		}
 
 		sampleTable := []testSample{
@@ -136,7 +157,7 @@ func TestResponse(t *testing.T) {
 					"Date":         []string{"Mon, 10 Jan 2000  09:55:21 GMT"},
 					"Server":       []string{"ICAP-Server-Software/1.0"},
 					"Istag":        []string{"\"W3E4R7U9-L2E4-2\""},
-					"Encapsulated": []string{"req-hdr=0, res-body=222"},
+					"Encapsulated": []string{"res-hdr=0, res-body=223"},
 				},
 				status:       "OK",
 				statusCode:   200,
@@ -146,22 +167,22 @@ func TestResponse(t *testing.T) {
 					"Server: ICAP-Server-Software/1.0\r\n" +
 					"Connection: close\r\n" +
 					"ISTag: \"W3E4R7U9-L2E4-2\"\r\n" +
-					"Encapsulated: req-hdr=0, res-body=222\r\n\r\n",
+					"Encapsulated: res-hdr=0, res-body=223\r\n\r\n",
 				httpRespStr: "HTTP/1.1 200 OK\r\n" +
 					"Date: Mon, 10 Jan 2000  09:55:21 GMT\r\n" +
 					"Via: 1.0 icap.example.org (ICAP Example RespMod Service 1.1)\r\n" +
 					"Server: Apache/1.3.6 (Unix)\r\n" +
 					"ETag: \"63840-1ab7-378d415b\"\r\n" +
 					"Content-Type: text/plain\r\n" +
-					"Content-Length: 92\r\n\r\n" +
-					"5c\r\n" +
+					"Content-Length: 91\r\n\r\n",
+				httpBodyStr: "5b\r\n" +
 					"This is data that was returned by an origin server, but with value added by an ICAP server.\r\n" +
 					"0\r\n\r\n",
 			},
 		}
 
 		for _, sample := range sampleTable {
-			resp, err := ReadResponse(bufio.NewReader(strings.NewReader(sample.respStr + sample.httpRespStr)))
+			resp, err := ReadResponse(bufio.NewReader(strings.NewReader(sample.respStr + sample.httpRespStr + sample.httpBodyStr)))
 			if err != nil {
 				t.Fatal(err.Error())
 			}
@@ -196,6 +217,23 @@ func TestResponse(t *testing.T) {
 				t.Fatal(err.Error())
 			}
 
+			wantedBody, err := ioutil.ReadAll(httputil.NewChunkedReader(strings.NewReader(sample.httpBodyStr)))
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			body, err := ioutil.ReadAll(resp.ContentResponse.Body)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			if string(body) != string(wantedBody) {
+				t.Logf("Wanted http response body: %q, got: %q", string(wantedBody), string(body))
+				t.Fail()
+			}
+
+			wantedHTTPResp.Body, resp.ContentResponse.Body = nil, nil // the bodies are compared already
+
 			if !reflect.DeepEqual(resp.ContentResponse, wantedHTTPResp) {
 				t.Logf("Wanted http response: %v, got: %v", wantedHTTPResp, resp.ContentResponse)
 				t.Fail()
@@ -205,4 +243,188 @@ func TestResponse(t *testing.T) {
 
 	})
 
+	t.Run("ReadResponse streams binary body", func(t *testing.T) {
+		bodyStr := "%PDF-1.4\x00\xff\xfe  \r\nICAP/1.0 200 OK\r\nHTTP/1.1 200 OK\r\n\r\n  trailing spaces  \n"
+		httpRespStr := "HTTP/1.1 200 OK\r\n" +
+			"Content-Type: application/pdf\r\n\r\n"
+
+		respStr := "ICAP/1.0 200 OK\r\n" +
+			"ISTag: \"W3E4R7U9-L2E4-2\"\r\n" +
+			fmt.Sprintf("Encapsulated: res-hdr=0, res-body=%d\r\n\r\n", len(httpRespStr)) +
+			httpRespStr +
+			fmt.Sprintf("%x\r\n%s\r\n", 10, bodyStr[:10]) +
+			fmt.Sprintf("%x\r\n%s\r\n", len(bodyStr)-10, bodyStr[10:]) +
+			"0\r\n\r\n"
+
+		type testSample struct {
+			readAll         bool
+			wantedFullyRead bool
+		}
+
+		sampleTable := []testSample{
+			{
+				readAll:         true,
+				wantedFullyRead: true,
+			},
+			{
+				readAll:         false,
+				wantedFullyRead: false,
+			},
+		}
+
+		for _, sample := range sampleTable {
+			doneCalls := 0
+			fullyRead := false
+
+			b := bufio.NewReader(strings.NewReader(respStr + "ICAP/1.0 100 Continue\r\n\r\n"))
+
+			resp, err := readResponse(b, func(fr bool) {
+				doneCalls++
+				fullyRead = fr
+			})
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			if sample.readAll {
+				body, err := ioutil.ReadAll(resp.ContentResponse.Body)
+				if err != nil {
+					t.Fatal(err.Error())
+				}
+
+				if string(body) != bodyStr {
+					t.Logf("Wanted the body intact: %q, got: %q", bodyStr, string(body))
+					t.Fail()
+				}
+			}
+
+			resp.ContentResponse.Body.Close()
+
+			if doneCalls != 1 || fullyRead != sample.wantedFullyRead {
+				t.Logf("Wanted the body to be done with once & fully read as: %v, got: %d times & %v", sample.wantedFullyRead,
+					doneCalls, fullyRead)
+				t.Fail()
+			}
+
+			if !sample.readAll {
+				continue
+			}
+
+			next, err := ReadResponse(b) // the message must be consumed exactly, leaving the next one intact
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			if next.StatusCode != http.StatusContinue {
+				t.Logf("Wanted the next message with status code: %d, got: %d", http.StatusContinue, next.StatusCode)
+				t.Fail()
+			}
+		}
+	})
+
+	t.Run("ReadResponse with malformed Encapsulated header", func(t *testing.T) {
+
+		respStr := "ICAP/1.0 200 OK\r\n" +
+			"ISTag: \"W3E4R7U9-L2E4-2\"\r\n" +
+			"Encapsulated: res-hdr=0, req-hdr=30, res-body=60\r\n\r\n"
+
+		_, err := ReadResponse(bufio.NewReader(strings.NewReader(respStr)))
+
+		encErr, ok := err.(*EncapsulatedError)
+
+		if !ok {
+			t.Fatalf("Wanted an *EncapsulatedError, got: %v", err)
+		}
+
+		if encErr.Entity != "req-hdr=30" || encErr.Value != "res-hdr=0, req-hdr=30, res-body=60" {
+			t.Logf("Wanted the error to point at the entity: %s, got: %s", "req-hdr=30", encErr.Entity)
+			t.Fail()
+		}
+	})
+
+	t.Run("ReadResponse with Encapsulated offsets too large", func(t *testing.T) {
+
+		respStr := "ICAP/1.0 200 OK\r\n" +
+			"ISTag: \"W3E4R7U9-L2E4-2\"\r\n" +
+			"Encapsulated: res-hdr=0, res-body=9000000000000000000\r\n\r\n" +
+			"HTTP/1.1 200 OK\r\n\r\n"
+
+		_, err := ReadResponse(bufio.NewReader(strings.NewReader(respStr)))
+
+		encErr, ok := err.(*EncapsulatedError)
+
+		if !ok {
+			t.Fatalf("Wanted an *EncapsulatedError, got: %v", err)
+		}
+
+		if encErr.Entity != "res-body=9000000000000000000" {
+			t.Logf("Wanted the error to point at the entity: %s, got: %s", "res-body=9000000000000000000", encErr.Entity)
+			t.Fail()
+		}
+	})
+
+	t.Run("ReadResponse OPTIONS with opt-body", func(t *testing.T) {
+
+		optBody := "method-list: REQMOD RESPMOD\r\n"
+
+		respStr := "ICAP/1.0 200 OK\r\n" +
+			"Methods: RESPMOD\r\n" +
+			"Opt-body-type: Plain\r\n" +
+			"Encapsulated: opt-body=0\r\n\r\n" +
+			fmt.Sprintf("%x\r\n%s\r\n0\r\n\r\n", len(optBody), optBody) +
+			"ICAP/1.0 100 Continue\r\n\r\n"
+
+		b := bufio.NewReader(strings.NewReader(respStr))
+
+		resp, err := ReadResponse(b)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if resp.StatusCode != http.StatusOK || resp.ContentRequest != nil || resp.ContentResponse != nil {
+			t.Logf("Wanted status code: %d with no http message, got: %d", http.StatusOK, resp.StatusCode)
+			t.Fail()
+		}
+
+		next, err := ReadResponse(b) // the opt-body must be read through, leaving the next message intact
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if next.StatusCode != http.StatusContinue {
+			t.Logf("Wanted the next message with status code: %d, got: %d", http.StatusContinue, next.StatusCode)
+			t.Fail()
+		}
+	})
+
+	t.Run("ReadResponse with body trailers", func(t *testing.T) {
+
+		httpRespStr := "HTTP/1.1 200 OK\r\nTrailer: X-Checksum\r\n\r\n"
+
+		respStr := "ICAP/1.0 200 OK\r\n" +
+			fmt.Sprintf("Encapsulated: res-hdr=0, res-body=%d\r\n\r\n", len(httpRespStr)) +
+			httpRespStr +
+			"b; name=value\r\nHello World\r\n0\r\nX-Checksum: abc\r\n\r\n"
+
+		resp, err := ReadResponse(bufio.NewReader(strings.NewReader(respStr)))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		body, err := ioutil.ReadAll(resp.ContentResponse.Body)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if string(body) != "Hello World" {
+			t.Logf("Wanted the body: %s, got: %s", "Hello World", string(body))
+			t.Fail()
+		}
+
+		if got := resp.ContentResponse.Trailer.Get("X-Checksum"); got != "abc" {
+			t.Logf("Wanted the trailer X-Checksum: %s, got: %s", "abc", got)
+			t.Fail()
+		}
+	})
+
 }
@@ -0,0 +1,365 @@
+package icapclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+)
+
+// RetryPolicy tells the client how to retry the calls failing for a transient reason, for example:
+// a timeout, a dropped connection or the 503 Service Overloaded status
+//
+// Retrying a call with a body sends the body all over again, so the body must be rewound with the GetBody of the
+// request. The calls whose body can't be rewound are only retried if the body is not read yet, as when the dial fails
+type RetryPolicy struct {
+	MaxAttempts       int                                  // the maximum number of attempts of a call, the first one included (default: 1, no retries)
+	InitialBackoff    time.Duration                        // the wait before the first retry (default: 100ms)
+	MaxBackoff        time.Duration                        // the maximum wait between two attempts (default: 5s)
+	Multiplier        float64                              // the factor the wait grows with after every retry (default: 2)
+	Jitter            float64                              // the fraction of the wait randomly taken off it, from 0 to 1, negative for none (default: 0.2)
+	AttemptTimeout    time.Duration                        // the timeout of every attempt, reading the body of the response included (default: none)
+	RetryableStatuses []int                                // the ICAP statuses to retry (default: 408, 429, 500, 502, 503 & 504)
+	RetryableErrors   []error                              // the errors to retry, checked with errors.Is (default: the timeouts, the network errors & the dropped connections)
+	Retryable         func(resp *Response, err error) bool // decides if the attempt is to be retried instead of the statuses & the errors above if set
+}
+
+// retry tells if the attempt with the response or the error is to be retried
+func (p *RetryPolicy) retry(resp *Response, err error) bool {
+
+	if p.Retryable != nil {
+		return p.Retryable(resp, err)
+	}
+
+	if err != nil {
+		var serviceErr *ServiceError
+		if errors.As(err, &serviceErr) {
+			return p.retryStatus(serviceErr.StatusCode)
+		}
+
+		return p.retryError(err)
+	}
+
+	return p.retryStatus(resp.StatusCode)
+}
+
+// retryStatus tells if the ICAP status is to be retried
+func (p *RetryPolicy) retryStatus(code int) bool {
+
+	if p.RetryableStatuses == nil {
+		return retryableStatus(code)
+	}
+
+	for _, c := range p.RetryableStatuses {
+		if c == code {
+			return true
+		}
+	}
+
+	return false
+}
+
+// retryError tells if the error is to be retried
+func (p *RetryPolicy) retryError(err error) bool {
+
+	if p.RetryableErrors == nil {
+		return transientError(err)
+	}
+
+	for _, e := range p.RetryableErrors {
+		if errors.Is(err, e) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// backoff returns the wait before the retry following the attempt
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+
+	initial := p.InitialBackoff
+	if initial <= 0 {
+		initial = defaultInitialBackoff
+	}
+
+	max := p.MaxBackoff
+	if max <= 0 {
+		max = defaultMaxBackoff
+	}
+
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = defaultBackoffMultiplier
+	}
+
+	jitter := p.Jitter
+	if jitter == 0 {
+		jitter = defaultBackoffJitter
+	}
+
+	wait := math.Min(float64(initial)*math.Pow(multiplier, float64(attempt-1)), float64(max))
+
+	if jitter > 0 {
+		wait -= wait * math.Min(jitter, 1) * rand.Float64()
+	}
+
+	return time.Duration(wait)
+}
+
+// retryableStatus tells if the ICAP status tells the server is overloaded, timed out or failed on its side
+func retryableStatus(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+
+	return false
+}
+
+// transientError tells if the error is a timeout, a network error or the connection dropped by the server
+func transientError(err error) bool {
+
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var netErr net.Error
+
+	return errors.Is(err, ErrTimeout) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &netErr)
+}
+
+// doWithRetry makes the call to the ICAP server, retrying it as the retry policy of the client tells & failing over to
+// the next server of the server pool of the client if there is one
+func (c *Client) doWithRetry(req *Request) (*Response, error) {
+
+	policy := c.Retry
+	servers := c.Servers
+	srv := req.server
+
+	if srv == nil {
+		servers = nil
+	}
+
+	maxAttempts := 1
+	if policy != nil && policy.MaxAttempts > 1 {
+		maxAttempts = policy.MaxAttempts
+	}
+
+	if servers != nil && maxAttempts < len(servers.servers) { // every server gets a chance at the call
+		maxAttempts = len(servers.servers)
+	}
+
+	defer req.setServer(nil)
+
+	if maxAttempts == 1 {
+		ctx := req.ctx
+		defer func() { req.ctx = ctx }()
+
+		resp, err := c.attempt(req, c.retryPolicy().AttemptTimeout, servers, srv)
+
+		if servers != nil && (ctx == nil || (*ctx).Err() == nil) { // the calls given up by the caller tell nothing about the server
+			servers.report(srv, c.retryPolicy().retry(resp, err))
+		}
+
+		return resp, err
+	}
+
+	wait := policy != nil // failing over to the next server is immediate, the retries are after the backoff
+	policy = c.retryPolicy()
+
+	ctx := req.ctx
+	body := &trackedBody{}
+
+	defer func() { // the context & the body of the request are restored once the attempts are done
+		req.ctx = ctx
+		if body.ReadCloser != nil {
+			req.setBody(body.ReadCloser)
+		}
+	}()
+
+	parent := context.Background()
+	if ctx != nil {
+		parent = *ctx
+	}
+
+	fromGetBody := false // the body is got from the GetBody of the request, so it is to be closed by the client
+	tried := map[*poolServer]bool{}
+
+	for attempt := 1; ; attempt++ {
+
+		if b := req.body(); b != nil {
+			*body = trackedBody{ReadCloser: b}
+			req.setBody(body)
+		}
+
+		req.ctx = ctx
+		resp, err := c.attempt(req, policy.AttemptTimeout, servers, srv)
+
+		if parent.Err() != nil {
+			return resp, err
+		}
+
+		retry := policy.retry(resp, err)
+
+		if servers != nil {
+			servers.report(srv, retry)
+		}
+
+		if attempt >= maxAttempts || !retry {
+			return resp, err
+		}
+
+		getBody := req.getBody()
+
+		if body.ReadCloser != nil && body.read && getBody == nil { // the body is sent already & can't be sent again
+			return resp, err
+		}
+
+		if servers != nil {
+			tried[srv] = true
+
+			next, pickErr := servers.pick(c, req, tried)
+			if pickErr != nil { // none of the servers is left to fail over to
+				return resp, err
+			}
+
+			logDebug("Failing over to the ICAP server: ", next.url.String())
+			srv = next
+			req.setServer(srv)
+
+			req.resetOptions() // the options negotiated with the server failed over from don't apply to this one
+			if _, optErr := c.applyOptions(req); optErr != nil {
+				servers.report(srv, policy.retry(nil, optErr))
+				servers.release(srv)
+				return resp, err
+			}
+		}
+
+		if resp != nil {
+			resp.closeBody()
+		}
+
+		logDebug("Retrying the call after the attempt ", attempt, " failed: ", err)
+
+		if wait {
+			t := time.NewTimer(policy.backoff(attempt))
+
+			select {
+			case <-t.C:
+			case <-parent.Done():
+				t.Stop()
+				if servers != nil {
+					servers.release(srv)
+				}
+				return nil, parent.Err()
+			}
+		}
+
+		if body.ReadCloser == nil || !body.read { // none of the body is read, so it is sent as is
+			if body.ReadCloser != nil {
+				req.setBody(body.ReadCloser)
+			}
+			continue
+		}
+
+		b, err := getBody()
+
+		if err != nil {
+			if servers != nil {
+				servers.release(srv)
+			}
+			return nil, err
+		}
+
+		if fromGetBody {
+			body.ReadCloser.Close()
+		}
+
+		req.setBody(b)
+		fromGetBody = true
+
+		if req.previewSet { // the preview is taken out of the new body all over again
+			if err := req.SetPreview(req.PreviewBytes); err != nil {
+				if servers != nil {
+					servers.release(srv)
+				}
+				return nil, err
+			}
+		}
+	}
+}
+
+// attempt makes a single attempt of the call with the timeout, the timeout keeps applying to the body of the response
+// till it is read till the end or closed. The server of the pool picked for the attempt is released once the call is done
+func (c *Client) attempt(req *Request, timeout time.Duration, servers *ServerPool, srv *poolServer) (*Response, error) {
+
+	cancel := func() {}
+
+	if timeout > 0 {
+		parent := context.Background()
+		if req.ctx != nil {
+			parent = *req.ctx
+		}
+
+		var ctx context.Context
+		ctx, cancel = context.WithTimeout(parent, timeout)
+		req.ctx = &ctx
+	}
+
+	done := func() {
+		cancel()
+		if servers != nil {
+			servers.release(srv)
+		}
+	}
+
+	resp, err := c.do(req)
+
+	if err != nil || !resp.onBodyDone(done) {
+		done()
+	}
+
+	return resp, err
+}
+
+// retryPolicy returns the retry policy of the client, the default one deciding what to retry if none is set
+func (c *Client) retryPolicy() *RetryPolicy {
+	if c.Retry != nil {
+		return c.Retry
+	}
+
+	return &RetryPolicy{}
+}
+
+// getBody returns the function to get a new copy of the body with, the GetBody of the request,
+// the one of the encapsulated http request for REQMOD otherwise
+func (r *Request) getBody() func() (io.ReadCloser, error) {
+
+	if r.GetBody != nil {
+		return r.GetBody
+	}
+
+	if r.Method == MethodREQMOD && r.HTTPRequest != nil {
+		return r.HTTPRequest.GetBody
+	}
+
+	return nil
+}
+
+// trackedBody keeps track of whether the body is read or not
+type trackedBody struct {
+	io.ReadCloser
+	read bool
+}
+
+// Read reads from the body, marking it read
+func (b *trackedBody) Read(p []byte) (int, error) {
+	b.read = true
+	return b.ReadCloser.Read(p)
+}
@@ -0,0 +1,309 @@
+package icapclient
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetry(t *testing.T) {
+
+	t.Run("RetryPolicy backoff", func(t *testing.T) {
+
+		type testSample struct {
+			policy    *RetryPolicy
+			attempt   int
+			wantedMin time.Duration
+			wantedMax time.Duration
+		}
+
+		sampleTable := []testSample{
+			{policy: &RetryPolicy{Jitter: -1}, attempt: 1, wantedMin: 100 * time.Millisecond, wantedMax: 100 * time.Millisecond},
+			{policy: &RetryPolicy{Jitter: -1}, attempt: 3, wantedMin: 400 * time.Millisecond, wantedMax: 400 * time.Millisecond},
+			{policy: &RetryPolicy{Jitter: -1}, attempt: 10, wantedMin: 5 * time.Second, wantedMax: 5 * time.Second},
+			{
+				policy:    &RetryPolicy{InitialBackoff: time.Second, Multiplier: 3, MaxBackoff: time.Minute, Jitter: -1},
+				attempt:   2,
+				wantedMin: 3 * time.Second,
+				wantedMax: 3 * time.Second,
+			},
+			{policy: &RetryPolicy{}, attempt: 2, wantedMin: 160 * time.Millisecond, wantedMax: 200 * time.Millisecond},
+			{policy: &RetryPolicy{Jitter: 1}, attempt: 1, wantedMin: 0, wantedMax: 100 * time.Millisecond},
+		}
+
+		for _, sample := range sampleTable {
+			for i := 0; i < 100; i++ {
+				if wait := sample.policy.backoff(sample.attempt); wait < sample.wantedMin || wait > sample.wantedMax {
+					t.Logf("Wanted the backoff after the attempt %d between %s & %s, got:%s", sample.attempt,
+						sample.wantedMin, sample.wantedMax, wait)
+					t.Fail()
+					break
+				}
+			}
+		}
+	})
+
+	t.Run("RetryPolicy retry", func(t *testing.T) {
+
+		type testSample struct {
+			policy      *RetryPolicy
+			resp        *Response
+			err         error
+			wantedRetry bool
+		}
+
+		sampleTable := []testSample{
+			{policy: &RetryPolicy{}, resp: &Response{StatusCode: http.StatusServiceUnavailable}, wantedRetry: true},
+			{policy: &RetryPolicy{}, resp: &Response{StatusCode: http.StatusRequestTimeout}, wantedRetry: true},
+			{policy: &RetryPolicy{}, resp: &Response{StatusCode: http.StatusMethodNotAllowed}, wantedRetry: false},
+			{policy: &RetryPolicy{}, resp: &Response{StatusCode: http.StatusNoContent}, wantedRetry: false},
+			{policy: &RetryPolicy{}, err: &ServiceError{StatusCode: http.StatusServiceUnavailable}, wantedRetry: true},
+			{policy: &RetryPolicy{}, err: &TimeoutError{Phase: PhaseRead, Err: errors.New("i/o timeout")}, wantedRetry: true},
+			{policy: &RetryPolicy{}, err: io.ErrUnexpectedEOF, wantedRetry: true},
+			{policy: &RetryPolicy{}, err: &ProtocolError{Line: "HTTP/1.1 200 OK", Err: ErrInvalidTCPMsg}, wantedRetry: false},
+			{policy: &RetryPolicy{}, err: &ValidationError{Field: "URL", Err: ErrInvalidScheme}, wantedRetry: false},
+			{
+				policy:      &RetryPolicy{RetryableStatuses: []int{http.StatusInternalServerError}},
+				resp:        &Response{StatusCode: http.StatusServiceUnavailable},
+				wantedRetry: false,
+			},
+			{
+				policy:      &RetryPolicy{RetryableErrors: []error{ErrInvalidTCPMsg}},
+				err:         &ProtocolError{Line: "HTTP/1.1 200 OK", Err: ErrInvalidTCPMsg},
+				wantedRetry: true,
+			},
+			{
+				policy:      &RetryPolicy{Retryable: func(resp *Response, err error) bool { return resp.StatusCode == http.StatusOK }},
+				resp:        &Response{StatusCode: http.StatusOK},
+				wantedRetry: true,
+			},
+		}
+
+		for _, sample := range sampleTable {
+			if retry := sample.policy.retry(sample.resp, sample.err); retry != sample.wantedRetry {
+				t.Logf("Wanted the retry for the response:%v & the error:%v to be:%v, got:%v", sample.resp, sample.err,
+					sample.wantedRetry, retry)
+				t.Fail()
+			}
+		}
+	})
+
+	t.Run("Client Do with Retry", func(t *testing.T) {
+
+		var calls int32
+
+		srv := newFakeICAPServer(t, func(req *fakeICAPRequest) string {
+			n := atomic.AddInt32(&calls, 1)
+
+			switch {
+			case strings.HasPrefix(req.path, "/overloaded") && n < 3:
+				return "ICAP/1.0 503 Service Overloaded\r\nEncapsulated: null-body=0\r\n\r\n"
+			case strings.HasPrefix(req.path, "/dropping") && n < 2:
+				return ""
+			case strings.HasPrefix(req.path, "/stalling") && n < 2:
+				time.Sleep(300 * time.Millisecond)
+			case strings.HasPrefix(req.path, "/method"):
+				return "ICAP/1.0 405 Method Not Allowed\r\nEncapsulated: null-body=0\r\n\r\n"
+			}
+
+			return noContentResponse("TAG")
+		})
+		defer srv.close()
+
+		type testSample struct {
+			path             string
+			method           string
+			preview          int
+			rewindable       bool
+			attemptTimeout   time.Duration
+			statusErrors     bool
+			wantedStatusCode int
+			wantedCalls      int
+			wantedErr        bool
+		}
+
+		sampleTable := []testSample{
+			{
+				path:             "/overloaded",
+				method:           MethodRESPMOD,
+				preview:          -1,
+				rewindable:       true,
+				wantedStatusCode: http.StatusNoContent,
+				wantedCalls:      3,
+			},
+			{
+				path:             "/overloaded/preview",
+				method:           MethodRESPMOD,
+				preview:          4,
+				rewindable:       true,
+				wantedStatusCode: http.StatusNoContent,
+				wantedCalls:      3,
+			},
+			{
+				path:             "/overloaded/status-errors",
+				method:           MethodRESPMOD,
+				preview:          -1,
+				rewindable:       true,
+				statusErrors:     true,
+				wantedStatusCode: http.StatusNoContent,
+				wantedCalls:      3,
+			},
+			{
+				path:             "/overloaded/reqmod",
+				method:           MethodREQMOD,
+				preview:          -1,
+				wantedStatusCode: http.StatusNoContent,
+				wantedCalls:      3,
+			},
+			{
+				path:             "/overloaded/not-rewindable",
+				method:           MethodRESPMOD,
+				preview:          -1,
+				wantedStatusCode: http.StatusServiceUnavailable,
+				wantedCalls:      1,
+			},
+			{
+				path:             "/dropping",
+				method:           MethodRESPMOD,
+				preview:          -1,
+				rewindable:       true,
+				wantedStatusCode: http.StatusNoContent,
+				wantedCalls:      2,
+			},
+			{
+				path:             "/stalling",
+				method:           MethodRESPMOD,
+				preview:          -1,
+				rewindable:       true,
+				attemptTimeout:   100 * time.Millisecond,
+				wantedStatusCode: http.StatusNoContent,
+				wantedCalls:      2,
+			},
+			{
+				path:             "/method",
+				method:           MethodRESPMOD,
+				preview:          -1,
+				rewindable:       true,
+				statusErrors:     true,
+				wantedStatusCode: http.StatusMethodNotAllowed,
+				wantedCalls:      1,
+				wantedErr:        true,
+			},
+		}
+
+		for _, sample := range sampleTable {
+			atomic.StoreInt32(&calls, 0)
+			received := len(srv.received(sample.method))
+
+			client := &Client{
+				StatusErrors: sample.statusErrors,
+				Retry: &RetryPolicy{
+					MaxAttempts:    3,
+					InitialBackoff: time.Millisecond,
+					AttemptTimeout: sample.attemptTimeout,
+				},
+			}
+
+			var httpReq *http.Request
+			var httpResp *http.Response
+
+			if sample.method == MethodREQMOD { // the GetBody of the http request is set as the body is a strings.Reader
+				httpReq, _ = http.NewRequest(http.MethodPost, "http://someurl.com/upload", strings.NewReader("Hello World"))
+			} else {
+				httpReq, _ = http.NewRequest(http.MethodGet, "http://someurl.com/report.pdf", nil)
+				httpResp = &http.Response{
+					Status:     "200 OK",
+					StatusCode: http.StatusOK,
+					Proto:      "HTTP/1.1",
+					ProtoMajor: 1,
+					ProtoMinor: 1,
+					Header:     http.Header{},
+					Body:       ioutil.NopCloser(strings.NewReader("Hello World")),
+				}
+			}
+
+			req, err := NewRequest(sample.method, srv.url(sample.path), httpReq, httpResp)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			if sample.rewindable {
+				req.GetBody = func() (io.ReadCloser, error) {
+					return ioutil.NopCloser(bytes.NewReader([]byte("Hello World"))), nil
+				}
+			}
+
+			if sample.preview >= 0 {
+				if err := req.SetPreview(sample.preview); err != nil {
+					t.Fatal(err.Error())
+				}
+			}
+
+			resp, err := client.Do(req)
+
+			var serviceErr *ServiceError
+
+			switch {
+			case sample.wantedErr && (!errors.As(err, &serviceErr) || serviceErr.StatusCode != sample.wantedStatusCode):
+				t.Logf("Wanted a *ServiceError with the status code:%d for %s, got:%v", sample.wantedStatusCode, sample.path, err)
+				t.Fail()
+			case !sample.wantedErr && (err != nil || resp.StatusCode != sample.wantedStatusCode):
+				t.Logf("Wanted the status code:%d for %s, got:%v", sample.wantedStatusCode, sample.path, err)
+				t.Fail()
+			}
+
+			if n := atomic.LoadInt32(&calls); int(n) != sample.wantedCalls {
+				t.Logf("Wanted %d calls to the service for %s, got:%d", sample.wantedCalls, sample.path, n)
+				t.Fail()
+			}
+
+			for _, r := range srv.received(sample.method)[received:] {
+				if string(r.body) != "Hello World" {
+					t.Logf("Wanted every attempt for %s to send the entire body:%s, got:%s", sample.path, "Hello World", string(r.body))
+					t.Fail()
+				}
+			}
+
+			client.CloseIdleConnections()
+		}
+	})
+
+	t.Run("Client Do with AttemptTimeout only", func(t *testing.T) {
+
+		srv := newFakeICAPServer(t, func(req *fakeICAPRequest) string {
+			time.Sleep(300 * time.Millisecond)
+			return noContentResponse("TAG")
+		})
+		defer srv.close()
+
+		client := &Client{Retry: &RetryPolicy{AttemptTimeout: 50 * time.Millisecond}} // a single attempt
+		defer client.CloseIdleConnections()
+
+		httpReq, _ := http.NewRequest(http.MethodGet, "http://someurl.com/upload", nil)
+
+		req, err := NewRequest(MethodREQMOD, srv.url("/stalling"), httpReq, nil)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		start := time.Now()
+		_, err = client.Do(req)
+
+		if err == nil || time.Since(start) > 250*time.Millisecond {
+			t.Logf("Wanted the attempt to time out after %s, got:%v after %s", 50*time.Millisecond, err, time.Since(start))
+			t.Fail()
+		}
+
+		if req.ctx != nil {
+			t.Log("Wanted the context of the request restored after the attempt")
+			t.Fail()
+		}
+	})
+
+}
@@ -0,0 +1,284 @@
+package icapclient
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ScanTransport is an http.RoundTripper scanning the traffic of the Base with the ICAP services, every request goes
+// through REQMOD before it is sent & every response through RESPMOD before it is returned. Swapping the Transport of
+// an http.Client with it gets the traffic of the client scanned
+//
+// The message adapted by the service replaces the original one. The one the service doesn't modify(204 No Content)
+// is sent on as it is, the part of the body read for the scan is kept in the memory to be sent on along with the rest.
+// The calls are made without Allow: 204, so that the services only skip sending the content back after a preview &
+// no more than the preview(up to MaxPreviewSize) is ever kept, whatever the size of the body. A service responding
+// with 204 No Content after more than that gets an error that is ErrReplayTooLarge. The messages with a verdict not allowed get a *BlockedError, or a synthetic 403 Forbidden response if BlockResponse
+// is set. The errors of the calls are returned as they are, the ICAP error statuses as a *ServiceError, set a circuit
+// breaker with the client to fail open
+type ScanTransport struct {
+	Client        *Client           // the client making the ICAP calls (default: a client with the default settings)
+	Base          http.RoundTripper // the transport sending the requests (default: http.DefaultTransport)
+	REQMODURL     string            // the url of the REQMOD service, the requests are not scanned if empty
+	RESPMODURL    string            // the url of the RESPMOD service, the responses are not scanned if empty
+	BlockResponse bool              // return a synthetic 403 Forbidden response for the messages blocked instead of a *BlockedError
+	negotiate     bool              // the options of the services are negotiated even if the client doesn't negotiate them
+}
+
+// RoundTrip scans the request, sends it with the Base & then scans the response
+func (t *ScanTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	outReq, v, err := t.scanRequest(req)
+
+	if err != nil {
+		closeRequestBody(req)
+		return nil, err
+	}
+
+	if v != nil && !v.Allowed() {
+		closeRequestBody(req)
+		return t.block(req, v)
+	}
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	httpResp, err := base.RoundTrip(outReq)
+
+	if err != nil {
+		return nil, err
+	}
+
+	outResp, v, err := t.scanResponse(outReq, httpResp)
+
+	if err != nil {
+		httpResp.Body.Close()
+		return nil, err
+	}
+
+	if v != nil && !v.Allowed() {
+		httpResp.Body.Close()
+		return t.block(req, v)
+	}
+
+	return outResp, nil
+}
+
+// scanRequest scans the request with REQMOD, returning the request to send on, the original one or the one adapted
+// by the service, the verdict is nil if the request is not scanned
+func (t *ScanTransport) scanRequest(req *http.Request) (*http.Request, *Verdict, error) {
+
+	if t.REQMODURL == "" {
+		return req, nil, nil
+	}
+
+	scanReq := req.Clone(req.Context()) // the request given must not be modified
+	replay := teeBody(&scanReq.Body)
+
+	icapReq, err := NewRequest(MethodREQMOD, t.REQMODURL, scanReq, nil)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	icapReq.Header[AllowHeader] = nil // no 204 but after a preview, the key kept with no values so that the default is not set either
+	icapReq.SetContext(req.Context())
+	icapReq.negotiate = t.negotiate
+
+	resp, err := t.client().Do(icapReq)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	v := t.client().classifier().Classify(resp, nil)
+
+	if v.Status == VerdictError { // the service failed, it tells nothing about the content
+		return nil, nil, newServiceError(resp)
+	}
+
+	if !v.Allowed() {
+		resp.closeBody()
+		return nil, v, nil
+	}
+
+	if resp.ContentRequest == nil { // not modified, sending on the original request
+		if err := replay(); err != nil {
+			return nil, nil, err
+		}
+		return scanReq, v, nil
+	}
+
+	if req.Body != nil {
+		req.Body.Close()
+	}
+
+	outReq := resp.ContentRequest.WithContext(req.Context())
+	outReq.RequestURI = "" // the adapted request is to be sent as a client request
+
+	if outReq.URL.Scheme == "" {
+		outReq.URL.Scheme = req.URL.Scheme
+	}
+
+	if outReq.URL.Host == "" {
+		outReq.URL.Host = outReq.Host
+		if outReq.URL.Host == "" {
+			outReq.URL.Host = req.URL.Host
+		}
+	}
+
+	return outReq, v, nil
+}
+
+// scanResponse scans the response with RESPMOD, returning the response to return, the original one or the one adapted
+// by the service, the verdict is nil if the response is not scanned
+func (t *ScanTransport) scanResponse(req *http.Request, httpResp *http.Response) (*http.Response, *Verdict, error) {
+
+	if t.RESPMODURL == "" {
+		return httpResp, nil, nil
+	}
+
+	replay := teeBody(&httpResp.Body)
+
+	icapReq, err := NewRequest(MethodRESPMOD, t.RESPMODURL, req, httpResp)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	icapReq.Header[AllowHeader] = nil // no 204 but after a preview, the key kept with no values so that the default is not set either
+	icapReq.SetContext(req.Context())
+	icapReq.negotiate = t.negotiate
+
+	resp, err := t.client().Do(icapReq)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	v := t.client().classifier().Classify(resp, nil)
+
+	if v.Status == VerdictError { // the service failed, it tells nothing about the content
+		return nil, nil, newServiceError(resp)
+	}
+
+	if !v.Allowed() {
+		resp.closeBody()
+		return nil, v, nil
+	}
+
+	if resp.ContentResponse == nil { // not modified, returning the original response
+		if err := replay(); err != nil {
+			return nil, nil, err
+		}
+		return httpResp, v, nil
+	}
+
+	httpResp.Body.Close()
+
+	outResp := resp.ContentResponse
+	outResp.Request = req
+
+	return outResp, v, nil
+}
+
+// block returns the result of a message blocked
+func (t *ScanTransport) block(req *http.Request, v *Verdict) (*http.Response, error) {
+
+	if !t.BlockResponse {
+		return nil, &BlockedError{Verdict: v}
+	}
+
+	return newForbiddenResponse(req, v), nil
+}
+
+// client returns the client making the ICAP calls
+func (t *ScanTransport) client() *Client {
+	if t.Client != nil {
+		return t.Client
+	}
+
+	return defaultClient
+}
+
+// teeBody keeps what is read of the body in the memory up to maxReplaySize, the replay function returned puts it back
+// in front of the rest of the body, failing with ErrReplayTooLarge if more than that is read
+func teeBody(body *io.ReadCloser) func() error {
+
+	if *body == nil || *body == http.NoBody {
+		return func() error { return nil }
+	}
+
+	orig := *body
+	buf := &cappedBuffer{max: maxReplaySize}
+
+	*body = &readCloser{Reader: io.TeeReader(orig, buf), Closer: orig}
+
+	return func() error {
+		if buf.overflowed {
+			return ErrReplayTooLarge
+		}
+
+		*body = &readCloser{Reader: io.MultiReader(&buf.buf, orig), Closer: orig}
+		return nil
+	}
+}
+
+// cappedBuffer keeps what is written to it up to max bytes, dropping all of it once more is written
+type cappedBuffer struct {
+	buf        bytes.Buffer
+	max        int
+	overflowed bool
+}
+
+// Write keeps the data if it fits, the writes never fail so that the reads of the tee go on
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+
+	if b.overflowed {
+		return len(p), nil
+	}
+
+	if b.buf.Len()+len(p) > b.max {
+		b.overflowed = true
+		b.buf = bytes.Buffer{} // the data kept is no use any more
+		return len(p), nil
+	}
+
+	return b.buf.Write(p)
+}
+
+// newForbiddenResponse returns the synthetic 403 Forbidden response standing in for a message blocked, telling why
+func newForbiddenResponse(req *http.Request, v *Verdict) *http.Response {
+
+	body := v.String()
+
+	resp := &http.Response{
+		Status:        strconv.Itoa(http.StatusForbidden) + " " + http.StatusText(http.StatusForbidden),
+		StatusCode:    http.StatusForbidden,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{},
+		ContentLength: int64(len(body)),
+		Body:          ioutil.NopCloser(strings.NewReader(body)),
+		Request:       req,
+	}
+
+	resp.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+
+	return resp
+}
+
+// closeRequestBody closes the body of the request, as the transports do whether they send the request or not
+func closeRequestBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
@@ -0,0 +1,214 @@
+package icapclient
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestScanTransport(t *testing.T) {
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		switch r.URL.Path {
+		case "/eicar":
+			fmt.Fprint(w, "X5O!P%@AP[4\\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*")
+		case "/greeting":
+			fmt.Fprint(w, "Hello World")
+		case "/large":
+			w.Write(bytes.Repeat([]byte("a"), maxReplaySize+1))
+		default:
+			fmt.Fprintf(w, "%s %s %s", r.Method, r.URL.Path, body)
+		}
+	}))
+	defer origin.Close()
+
+	infected := "ICAP/1.0 200 OK\r\nISTag: TAG\r\nX-Infection-Found: Type=0; Resolution=2; Threat=EICAR-Test-File;\r\n" +
+		"Encapsulated: res-hdr=0, null-body=26\r\n\r\nHTTP/1.1 403 Forbidden\r\n\r\n"
+
+	srv := newFakeICAPServer(t, func(req *fakeICAPRequest) string {
+		switch {
+		case strings.Contains(string(req.body), "EICAR"):
+			return infected
+		case strings.Contains(string(req.body), "break"):
+			return "ICAP/1.0 500 Server Error\r\nISTag: TAG\r\nEncapsulated: null-body=0\r\n\r\n"
+		case req.path == "/reqmod" && strings.Contains(string(req.body), "rewrite"):
+			reqHdr := "POST /adapted HTTP/1.1\r\nHost: " + strings.TrimPrefix(origin.URL, "http://") + "\r\n\r\n"
+			return fmt.Sprintf("ICAP/1.0 200 OK\r\nISTag: TAG\r\nEncapsulated: req-hdr=0, req-body=%d\r\n\r\n%s"+
+				"9\r\nrewritten\r\n0\r\n\r\n", len(reqHdr), reqHdr)
+		case req.path == "/respmod" && strings.Contains(string(req.body), "Hello World"):
+			resHdr := "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\n\r\n"
+			return fmt.Sprintf("ICAP/1.0 200 OK\r\nISTag: TAG\r\nEncapsulated: res-hdr=0, res-body=%d\r\n\r\n%s"+
+				"d\r\nGoodbye World\r\n0\r\n\r\n", len(resHdr), resHdr)
+		}
+		return noContentResponse("TAG")
+	})
+	defer srv.close()
+
+	t.Run("ScanTransport RoundTrip", func(t *testing.T) {
+
+		client := &Client{}
+		defer client.CloseIdleConnections()
+
+		type testSample struct {
+			method        string
+			path          string
+			body          string
+			blockResponse bool
+			wantedStatus  int
+			wantedBody    string
+			wantedErr     error
+			wantedService bool // a *ServiceError for the service failing
+		}
+
+		sampleTable := []testSample{
+			{
+				method:       http.MethodPost,
+				path:         "/upload",
+				body:         "some content scanned & sent on",
+				wantedStatus: http.StatusOK,
+				wantedBody:   "POST /upload some content scanned & sent on",
+			},
+			{
+				method:       http.MethodPost,
+				path:         "/upload",
+				body:         "rewrite me",
+				wantedStatus: http.StatusOK,
+				wantedBody:   "POST /adapted rewritten",
+			},
+			{
+				method:       http.MethodGet,
+				path:         "/greeting",
+				wantedStatus: http.StatusOK,
+				wantedBody:   "Goodbye World",
+			},
+			{
+				method:    http.MethodPost,
+				path:      "/upload",
+				body:      "X5O!P%@AP[4\\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*",
+				wantedErr: ErrBlocked,
+			},
+			{
+				method:    http.MethodGet,
+				path:      "/eicar",
+				wantedErr: ErrBlocked,
+			},
+			{
+				method:        http.MethodPost,
+				path:          "/upload",
+				body:          "break the service",
+				blockResponse: true,
+				wantedStatus:  http.StatusInternalServerError,
+				wantedService: true,
+			},
+			{
+				method:        http.MethodGet,
+				path:          "/eicar",
+				blockResponse: true,
+				wantedStatus:  http.StatusForbidden,
+				wantedBody:    "infected: EICAR-Test-File",
+			},
+		}
+
+		for _, sample := range sampleTable {
+			httpClient := &http.Client{
+				Transport: &ScanTransport{
+					Client:        client,
+					REQMODURL:     srv.url("/reqmod"),
+					RESPMODURL:    srv.url("/respmod"),
+					BlockResponse: sample.blockResponse,
+				},
+			}
+
+			httpReq, err := http.NewRequest(sample.method, origin.URL+sample.path, strings.NewReader(sample.body))
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			resp, err := httpClient.Do(httpReq)
+
+			if sample.wantedService { // the service fails, the content is not blocked
+				var serviceErr *ServiceError
+				if !errors.As(err, &serviceErr) || serviceErr.StatusCode != sample.wantedStatus || errors.Is(err, ErrBlocked) {
+					t.Logf("Wanted a *ServiceError with the status code:%d for %s %s, got:%v", sample.wantedStatus,
+						sample.method, sample.path, err)
+					t.Fail()
+				}
+				continue
+			}
+
+			if sample.wantedErr != nil {
+				var blockedErr *BlockedError
+				if !errors.Is(err, sample.wantedErr) || !errors.As(err, &blockedErr) ||
+					blockedErr.Verdict.Status != VerdictInfected {
+					t.Logf("Wanted the error:%v for %s %s, got:%v", sample.wantedErr, sample.method, sample.path, err)
+					t.Fail()
+				}
+				continue
+			}
+
+			if err != nil {
+				t.Logf("Wanted no error for %s %s, got:%v", sample.method, sample.path, err)
+				t.Fail()
+				continue
+			}
+
+			body, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			if err != nil || resp.StatusCode != sample.wantedStatus || string(body) != sample.wantedBody {
+				t.Logf("Wanted %d:%q for %s %s, got:%d:%q & %v", sample.wantedStatus, sample.wantedBody, sample.method,
+					sample.path, resp.StatusCode, string(body), err)
+				t.Fail()
+			}
+		}
+	})
+
+	t.Run("ScanTransport with a body larger than a preview", func(t *testing.T) {
+
+		client := &Client{}
+		defer client.CloseIdleConnections()
+
+		httpClient := &http.Client{Transport: &ScanTransport{Client: client, RESPMODURL: srv.url("/respmod")}}
+
+		calls := len(srv.received(MethodRESPMOD))
+
+		_, err := httpClient.Get(origin.URL + "/large") // the service skips sending it back though it is not allowed to
+
+		if !errors.Is(err, ErrReplayTooLarge) {
+			t.Logf("Wanted the error:%v for the body not kept, got:%v", ErrReplayTooLarge, err)
+			t.Fail()
+		}
+
+		received := srv.received(MethodRESPMOD)
+
+		if len(received) != calls+1 || received[calls].header.Get(AllowHeader) != "" {
+			t.Logf("Wanted a call without the Allow header, got:%d calls", len(received)-calls)
+			t.Fail()
+		}
+	})
+
+	t.Run("ScanTransport without the services", func(t *testing.T) {
+
+		calls := len(srv.received(MethodREQMOD)) + len(srv.received(MethodRESPMOD))
+
+		httpClient := &http.Client{Transport: &ScanTransport{}}
+
+		resp, err := httpClient.Get(origin.URL + "/eicar")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK || len(srv.received(MethodREQMOD))+len(srv.received(MethodRESPMOD)) != calls {
+			t.Logf("Wanted the traffic not scanned with no service urls, got:%d", resp.StatusCode)
+			t.Fail()
+		}
+	})
+
+}
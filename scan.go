@@ -0,0 +1,173 @@
+package icapclient
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// ScanReader scans the content read from the reader with the ICAP service at the url, as a RESPMOD call of a file
+// downloaded with the name & the content type, & returns the verdict of the scan
+//
+// The encapsulated http response is built with the Content-Type(sniffed out of the content if empty), the
+// Content-Disposition & the Content-Length if the length of the reader is known. The options of the service are
+// negotiated for the preview & the body is streamed. The content modified by the service, if any, is not returned,
+// use Do for that. The readers that can seek are rewound for the retries
+//
+// The error of the call is returned along with a verdict of VerdictError
+func (c *Client) ScanReader(ctx context.Context, serviceURL, filename, contentType string, r io.Reader) (*Verdict, error) {
+
+	req, err := newScanRequest(serviceURL, filename, contentType, r)
+
+	if err != nil {
+		return c.classifier().Classify(nil, err), err
+	}
+
+	if ctx != nil {
+		req.SetContext(ctx)
+	}
+
+	resp, err := c.Do(req)
+
+	v := c.classifier().Classify(resp, err)
+
+	if resp != nil {
+		resp.closeBody()
+	}
+
+	return v, err
+}
+
+// ScanFile scans the file at the path with the ICAP service at the url & returns the verdict of the scan,
+// the content type is told by the file extension, sniffed out of the content if the extension is not known
+func (c *Client) ScanFile(ctx context.Context, serviceURL, path string) (*Verdict, error) {
+
+	f, err := os.Open(path)
+
+	if err != nil {
+		return c.classifier().Classify(nil, err), err
+	}
+
+	defer f.Close()
+
+	return c.ScanReader(ctx, serviceURL, filepath.Base(path), mime.TypeByExtension(filepath.Ext(path)), f)
+}
+
+// newScanRequest builds the RESPMOD request scanning the content
+func newScanRequest(serviceURL, filename, contentType string, r io.Reader) (*Request, error) {
+
+	var getBody func() (io.ReadCloser, error)
+
+	if seeker, ok := r.(io.ReadSeeker); ok { // the content is read again from where it starts for the retries
+		if start, err := seeker.Seek(0, io.SeekCurrent); err == nil {
+			getBody = func() (io.ReadCloser, error) {
+				if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+					return nil, err
+				}
+				return ioutil.NopCloser(seeker), nil
+			}
+		}
+	}
+
+	httpResp, err := NewFileResponse(filename, contentType, r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := NewRequest(MethodRESPMOD, serviceURL, httpResp.Request, httpResp)
+
+	if err != nil {
+		return nil, err
+	}
+
+	req.GetBody = getBody
+	req.negotiate = true
+
+	return req, nil
+}
+
+// NewFileResponse returns the http response of a file downloaded with the name & the content type, with the content
+// read from the reader as its body, to be scanned with RESPMOD as ScanReader does. The Content-Type is sniffed out of
+// the content if empty, the Content-Length is set if the length of the reader is known, for example: *os.File &
+// *bytes.Reader. The Request of the response is a GET of the file name
+func NewFileResponse(filename, contentType string, r io.Reader) (*http.Response, error) {
+
+	length := contentLength(r)
+
+	httpReq, err := http.NewRequest(http.MethodGet, "http://localhost/"+url.PathEscape(filename), nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if contentType == "" { // sniffing out the content type, the bytes sniffed are put back in front of the rest of the content
+		br := bufio.NewReaderSize(r, sniffLength)
+		b, _ := br.Peek(sniffLength)
+		contentType = http.DetectContentType(b)
+		r = br
+	}
+
+	httpResp := &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{},
+		ContentLength: length,
+		Body:          ioutil.NopCloser(r),
+		Request:       httpReq,
+	}
+
+	httpResp.Header.Set("Content-Type", contentType)
+
+	if filename != "" {
+		httpResp.Header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	}
+
+	if length >= 0 {
+		httpResp.Header.Set("Content-Length", strconv.FormatInt(length, 10))
+	}
+
+	return httpResp, nil
+}
+
+// contentLength returns the length of the content left in the reader, -1 if it is not known
+func contentLength(r io.Reader) int64 {
+
+	switch v := r.(type) {
+	case interface{ Len() int }: // for example: *bytes.Reader, *bytes.Buffer & *strings.Reader
+		return int64(v.Len())
+	case *os.File:
+		info, err := v.Stat()
+		if err != nil || !info.Mode().IsRegular() {
+			return -1
+		}
+
+		offset, err := v.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return -1
+		}
+
+		return info.Size() - offset
+	}
+
+	return -1
+}
+
+// classifier returns the classifier of the verdicts of the client
+func (c *Client) classifier() *Classifier {
+	if c.Classifier != nil {
+		return c.Classifier
+	}
+
+	return DefaultClassifier
+}
@@ -0,0 +1,186 @@
+package icapclient
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestScan(t *testing.T) {
+
+	srv := newFakeICAPServer(t, func(req *fakeICAPRequest) string {
+		if req.method == MethodOPTIONS {
+			return optionsResponse("Methods: RESPMOD", "Allow: 204", "Preview: 4", "ISTag: TAG", "Transfer-Preview: *",
+				"Transfer-Ignore: jpg")
+		}
+		if strings.Contains(string(req.body), "EICAR") {
+			return "ICAP/1.0 200 OK\r\nISTag: TAG\r\nX-Infection-Found: Type=0; Resolution=2; Threat=EICAR-Test-File;\r\n" +
+				"Encapsulated: res-hdr=0, null-body=26\r\n\r\nHTTP/1.1 403 Forbidden\r\n\r\n"
+		}
+		return noContentResponse("TAG")
+	})
+	defer srv.close()
+
+	t.Run("Client ScanReader", func(t *testing.T) {
+
+		client := &Client{}
+		defer client.CloseIdleConnections()
+
+		type testSample struct {
+			filename          string
+			contentType       string
+			content           string
+			unknownLength     bool
+			wantedStatus      VerdictStatus
+			wantedThreats     []string
+			wantedCalled      bool
+			wantedContentType string
+			wantedLength      string
+		}
+
+		sampleTable := []testSample{
+			{
+				filename:          "report.txt",
+				contentType:       "text/plain",
+				content:           "Hello World",
+				wantedStatus:      VerdictClean,
+				wantedCalled:      true,
+				wantedContentType: "text/plain",
+				wantedLength:      "11",
+			},
+			{
+				filename:          "eicar.com",
+				contentType:       "application/octet-stream",
+				content:           "X5O!P%@AP[4\\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*",
+				wantedStatus:      VerdictInfected,
+				wantedThreats:     []string{"EICAR-Test-File"},
+				wantedCalled:      true,
+				wantedContentType: "application/octet-stream",
+				wantedLength:      "68",
+			},
+			{
+				filename:          "report.pdf",
+				content:           "%PDF-1.4 Hello World",
+				unknownLength:     true,
+				wantedStatus:      VerdictClean,
+				wantedCalled:      true,
+				wantedContentType: "application/pdf",
+			},
+			{
+				filename:     "photo.jpg",
+				contentType:  "image/jpeg",
+				content:      "Hello World",
+				wantedStatus: VerdictNotScanned,
+			},
+		}
+
+		for _, sample := range sampleTable {
+			calls := len(srv.received(MethodRESPMOD))
+
+			var reader io.Reader = strings.NewReader(sample.content)
+			if sample.unknownLength { // hiding the length of the content
+				reader = ioutil.NopCloser(reader)
+			}
+
+			v, err := client.ScanReader(context.Background(), srv.url("/avscan"), sample.filename, sample.contentType, reader)
+
+			if err != nil || v.Status != sample.wantedStatus || strings.Join(v.Threats, ",") != strings.Join(sample.wantedThreats, ",") {
+				t.Logf("Wanted the verdict for %s:%s with the threats:%v, got:%+v & %v", sample.filename, sample.wantedStatus,
+					sample.wantedThreats, v, err)
+				t.Fail()
+				continue
+			}
+
+			received := srv.received(MethodRESPMOD)
+
+			if called := len(received) > calls; called != sample.wantedCalled {
+				t.Logf("Wanted the service called for %s:%v, got:%v", sample.filename, sample.wantedCalled, called)
+				t.Fail()
+				continue
+			}
+
+			if !sample.wantedCalled {
+				continue
+			}
+
+			last := received[len(received)-1]
+
+			if string(last.body) != sample.content || last.header.Get(PreviewHeader) != "4" {
+				t.Logf("Wanted the service to get the entire body of %s with a preview of 4, got:%q & %q", sample.filename,
+					string(last.body), last.header.Get(PreviewHeader))
+				t.Fail()
+			}
+
+			wantedHeaders := []string{
+				"Content-Type: " + sample.wantedContentType,
+				`Content-Disposition: attachment; filename=` + sample.filename,
+			}
+			if sample.wantedLength != "" {
+				wantedHeaders = append(wantedHeaders, "Content-Length: "+sample.wantedLength)
+			}
+
+			for _, hdr := range wantedHeaders {
+				if !strings.Contains(last.httpHdr, hdr+"\r\n") {
+					t.Logf("Wanted the encapsulated http response of %s to have %q, got:%q", sample.filename, hdr, last.httpHdr)
+					t.Fail()
+				}
+			}
+		}
+	})
+
+	t.Run("Client ScanFile", func(t *testing.T) {
+
+		dir, err := ioutil.TempDir("", "icap-client")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "notes.txt")
+		if err := ioutil.WriteFile(path, []byte("Hello World, Hello World"), 0600); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		client := &Client{}
+		defer client.CloseIdleConnections()
+
+		v, err := client.ScanFile(context.Background(), srv.url("/avscan"), path)
+
+		if err != nil || v.Status != VerdictClean {
+			t.Fatalf("Wanted the verdict:%s, got:%+v & %v", VerdictClean, v, err)
+		}
+
+		received := srv.received(MethodRESPMOD)
+		last := received[len(received)-1]
+
+		if string(last.body) != "Hello World, Hello World" || !strings.Contains(last.httpHdr, "Content-Length: 24\r\n") ||
+			!strings.Contains(last.httpHdr, "Content-Type: text/plain") {
+			t.Logf("Wanted the service to get the file with its length & content type, got:%q", last.httpHdr)
+			t.Fail()
+		}
+
+		if v, err := client.ScanFile(context.Background(), srv.url("/avscan"), filepath.Join(dir, "missing.txt")); err == nil ||
+			v.Status != VerdictError || v.Err != err {
+			t.Logf("Wanted the verdict:%s with the error for a missing file, got:%+v & %v", VerdictError, v, err)
+			t.Fail()
+		}
+
+		lstnr, err := net.Listen("tcp", "127.0.0.1:0") // a service down
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		lstnr.Close()
+
+		if v, err := client.ScanFile(context.Background(), "icap://"+lstnr.Addr().String()+"/avscan", path); err == nil ||
+			v.Status != VerdictError {
+			t.Logf("Wanted the verdict:%s with the error for a service down, got:%+v & %v", VerdictError, v, err)
+			t.Fail()
+		}
+	})
+
+}
@@ -0,0 +1,383 @@
+package icapclient
+
+import (
+	"hash/fnv"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Strategy is how a ServerPool spreads the calls over its servers
+type Strategy int
+
+// the strategies of a ServerPool
+const (
+	RoundRobin       Strategy = iota // the servers take the calls in turns
+	LeastOutstanding                 // the server with the fewest calls in progress takes the call
+	Weighted                         // the servers take the calls in turns, as many of them as their weights
+	ConsistentHash                   // the same content key always goes to the same server, as long as it is healthy
+)
+
+// Server is an ICAP server of a ServerPool
+type Server struct {
+	URL    string // the url of the ICAP service on the server, for example: icap://scanner-1:1344/avscan
+	Weight int    // the share of the calls the server takes with the Weighted & ConsistentHash strategies (default: 1)
+}
+
+// ServerPool spreads the calls of a client over several ICAP servers running the same service, set it with the client
+// to make the calls with it instead of the url of the request
+//
+// A server failing MaxFails calls in a row for a transient reason, the ones RetryPolicy retries, is marked unhealthy &
+// gets no more calls till an OPTIONS probe to it succeeds. The probes are sent every ProbeInterval as the calls go on.
+// The calls failing for a transient reason fail over to the next healthy server of the pool
+type ServerPool struct {
+	Strategy      Strategy
+	MaxFails      int                       // the number of failures in a row to mark a server unhealthy after (default: 3)
+	ProbeInterval time.Duration             // the wait between two OPTIONS probes of an unhealthy server (default: 10s)
+	HashKey       func(req *Request) string // the content key for ConsistentHash (default: the url of the encapsulated http message)
+	mu            sync.Mutex
+	servers       []*poolServer
+	ring          []ringPoint // the virtual nodes of the servers for ConsistentHash, sorted by their hashes
+	next          int         // the turn of RoundRobin
+}
+
+// poolServer is a server of the pool & its state
+type poolServer struct {
+	url         *url.URL
+	weight      int
+	healthy     bool
+	fails       int       // the number of failures in a row
+	nextProbe   time.Time // when to probe the server next if it is unhealthy
+	probing     bool
+	outstanding int // the number of calls in progress
+	current     int // the current weight of the server for Weighted
+}
+
+// ringPoint is a virtual node of a server on the ConsistentHash ring
+type ringPoint struct {
+	hash   uint32
+	server *poolServer
+}
+
+// NewServerPool is the factory function for ServerPool
+func NewServerPool(strategy Strategy, servers ...Server) (*ServerPool, error) {
+
+	p := &ServerPool{
+		Strategy: strategy,
+	}
+
+	for _, s := range servers {
+		u, err := url.Parse(s.URL)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if valid, err := validURL(u); !valid {
+			return nil, err
+		}
+
+		weight := s.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+
+		srv := &poolServer{url: u, weight: weight, healthy: true}
+		p.servers = append(p.servers, srv)
+
+		for i := 0; i < weight*ringPointsPerWeight; i++ {
+			p.ring = append(p.ring, ringPoint{hash: hashKey(s.URL + "#" + strconv.Itoa(i)), server: srv})
+		}
+	}
+
+	if len(p.servers) == 0 {
+		return nil, &ValidationError{Field: "URL", Err: ErrInvalidHost}
+	}
+
+	sort.Slice(p.ring, func(i, j int) bool {
+		return p.ring[i].hash < p.ring[j].hash
+	})
+
+	return p, nil
+}
+
+// Healthy returns the urls of the healthy servers
+func (p *ServerPool) Healthy() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	urls := []string{}
+	for _, srv := range p.servers {
+		if srv.healthy {
+			urls = append(urls, srv.url.String())
+		}
+	}
+
+	return urls
+}
+
+// pick chooses the server to make the call with, out of the healthy ones not tried yet, the ones tried already are
+// chosen again once all of the healthy ones are tried. The unhealthy servers due are probed with the client meanwhile
+func (p *ServerPool) pick(c *Client, req *Request, tried map[*poolServer]bool) (*poolServer, error) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.probe(c)
+
+	eligible := make([]*poolServer, 0, len(p.servers))
+	for _, srv := range p.servers {
+		if srv.healthy && !tried[srv] {
+			eligible = append(eligible, srv)
+		}
+	}
+
+	if len(eligible) == 0 {
+		for _, srv := range p.servers {
+			if srv.healthy {
+				eligible = append(eligible, srv)
+			}
+		}
+	}
+
+	if len(eligible) == 0 {
+		return nil, ErrNoHealthyServer
+	}
+
+	var srv *poolServer
+
+	switch p.Strategy {
+	case LeastOutstanding:
+		srv = eligible[0]
+		for _, s := range eligible[1:] {
+			if s.outstanding < srv.outstanding {
+				srv = s
+			}
+		}
+	case Weighted: // the smooth weighted round-robin, the servers of higher weights don't take their calls all in a row
+		total := 0
+		for _, s := range eligible {
+			s.current += s.weight
+			total += s.weight
+			if srv == nil || s.current > srv.current {
+				srv = s
+			}
+		}
+		srv.current -= total
+	case ConsistentHash:
+		srv = p.lookup(p.hashKey(req), eligible)
+	default:
+		srv = eligible[p.next%len(eligible)]
+		p.next++
+	}
+
+	srv.outstanding++
+
+	return srv, nil
+}
+
+// lookup returns the first of the eligible servers on the ring, going clockwise from the key
+func (p *ServerPool) lookup(key string, eligible []*poolServer) *poolServer {
+
+	isEligible := make(map[*poolServer]bool, len(eligible))
+	for _, srv := range eligible {
+		isEligible[srv] = true
+	}
+
+	h := hashKey(key)
+	start := sort.Search(len(p.ring), func(i int) bool {
+		return p.ring[i].hash >= h
+	})
+
+	for i := 0; i < len(p.ring); i++ {
+		if point := p.ring[(start+i)%len(p.ring)]; isEligible[point.server] {
+			return point.server
+		}
+	}
+
+	return eligible[0]
+}
+
+// hashKey returns the content key of the request for ConsistentHash
+func (p *ServerPool) hashKey(req *Request) string {
+
+	if p.HashKey != nil {
+		return p.HashKey(req)
+	}
+
+	var httpReq *http.Request
+
+	switch {
+	case req.Method == MethodRESPMOD && req.HTTPResponse != nil && req.HTTPResponse.Request != nil:
+		httpReq = req.HTTPResponse.Request
+	default:
+		httpReq = req.HTTPRequest
+	}
+
+	if httpReq != nil && httpReq.URL != nil {
+		return httpReq.URL.String()
+	}
+
+	return req.filename()
+}
+
+// release lets the pool know the call with the server is done
+func (p *ServerPool) release(srv *poolServer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	srv.outstanding--
+}
+
+// report records the outcome of a call with the server, marking it unhealthy after MaxFails failures in a row
+func (p *ServerPool) report(srv *poolServer, failed bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !failed {
+		srv.fails = 0
+		return
+	}
+
+	srv.fails++
+
+	maxFails := p.MaxFails
+	if maxFails <= 0 {
+		maxFails = defaultMaxFails
+	}
+
+	if srv.healthy && srv.fails >= maxFails {
+		logDebug("Marking the ICAP server unhealthy after ", srv.fails, " failures in a row: ", srv.url.String())
+		srv.healthy = false
+		srv.nextProbe = time.Now().Add(p.probeInterval())
+	}
+}
+
+// probe sends the OPTIONS probes to the unhealthy servers due with the client, the servers responding with 200 OK
+// are marked healthy again, the pool must be locked
+func (p *ServerPool) probe(c *Client) {
+
+	now := time.Now()
+
+	for _, srv := range p.servers {
+		if srv.healthy || srv.probing || now.Before(srv.nextProbe) {
+			continue
+		}
+
+		srv.probing = true
+
+		go func(srv *poolServer) {
+			ok := c.probe(srv.url.String())
+
+			p.mu.Lock()
+			defer p.mu.Unlock()
+
+			srv.probing = false
+
+			if ok {
+				logDebug("The ICAP server is healthy again: ", srv.url.String())
+				srv.healthy = true
+				srv.fails = 0
+				return
+			}
+
+			srv.nextProbe = time.Now().Add(p.probeInterval())
+		}(srv)
+	}
+}
+
+// probeInterval returns the wait between two probes of an unhealthy server
+func (p *ServerPool) probeInterval() time.Duration {
+	if p.ProbeInterval > 0 {
+		return p.ProbeInterval
+	}
+
+	return defaultProbeInterval
+}
+
+// pickServer picks the server of the server pool of the client to make the call with, setting the url of the request
+// to its url & negotiating the options with it if the options are negotiated, the next server is picked if it fails to.
+// Every healthy server gets a single try, the error of the last one is returned once all of them fail
+func (c *Client) pickServer(req *Request) (*ServiceOptions, error) {
+
+	tried := map[*poolServer]bool{}
+	var lastErr error
+
+	for {
+		srv, err := c.Servers.pick(c, req, tried)
+
+		if err != nil {
+			if lastErr != nil { // the servers tried are unhealthy now, the error they failed with tells more
+				return nil, lastErr
+			}
+			return nil, err
+		}
+
+		if tried[srv] { // the server is picked again, so every healthy one is tried already
+			c.Servers.release(srv)
+			return nil, lastErr
+		}
+
+		req.setServer(srv)
+
+		opts, err := c.applyOptions(req)
+
+		if err == nil {
+			return opts, nil
+		}
+
+		c.Servers.release(srv)
+		req.setServer(nil)
+
+		failed := c.retryPolicy().retry(nil, err)
+		c.Servers.report(srv, failed)
+		tried[srv] = true
+		lastErr = err
+
+		if !failed {
+			return nil, err
+		}
+	}
+}
+
+// setServer sets the server of the server pool the call is made with, the url of the request becomes the url of the server
+func (r *Request) setServer(srv *poolServer) {
+	r.server = srv
+
+	if srv != nil {
+		u := *srv.url
+		r.URL = &u
+	}
+}
+
+// probe tells if the ICAP service at the url responds to OPTIONS with 200 OK
+func (c *Client) probe(urlStr string) bool {
+
+	req, err := NewRequest(MethodOPTIONS, urlStr, nil, nil)
+
+	if err != nil {
+		return false
+	}
+
+	req.pinned = true
+
+	resp, err := c.Do(req)
+
+	if err != nil {
+		return false
+	}
+
+	resp.closeBody()
+
+	return resp.StatusCode == http.StatusOK
+}
+
+// hashKey hashes the key onto the ConsistentHash ring
+func hashKey(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32()
+}
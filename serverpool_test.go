@@ -0,0 +1,389 @@
+package icapclient
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestServerPool(t *testing.T) {
+
+	t.Run("NewServerPool", func(t *testing.T) {
+
+		type testSample struct {
+			servers   []Server
+			wantedErr error
+		}
+
+		sampleTable := []testSample{
+			{servers: []Server{{URL: "icap://scanner-1:1344/avscan"}, {URL: "icaps://scanner-2/avscan", Weight: 2}}},
+			{servers: []Server{{URL: "http://scanner-1:1344/avscan"}}, wantedErr: ErrInvalidScheme},
+			{servers: []Server{{URL: "icap:///avscan"}}, wantedErr: ErrInvalidHost},
+			{servers: nil, wantedErr: ErrInvalidHost},
+		}
+
+		for _, sample := range sampleTable {
+			_, err := NewServerPool(RoundRobin, sample.servers...)
+
+			var validationErr *ValidationError
+
+			if sample.wantedErr == nil && err != nil {
+				t.Logf("Wanted no error for %v, got:%v", sample.servers, err)
+				t.Fail()
+			}
+
+			if sample.wantedErr != nil && (!errors.Is(err, sample.wantedErr) || !errors.As(err, &validationErr)) {
+				t.Logf("Wanted error:%v for %v, got:%v", sample.wantedErr, sample.servers, err)
+				t.Fail()
+			}
+		}
+	})
+
+	t.Run("ServerPool pick", func(t *testing.T) {
+
+		servers := []Server{
+			{URL: "icap://scanner-1/avscan", Weight: 1},
+			{URL: "icap://scanner-2/avscan", Weight: 2},
+			{URL: "icap://scanner-3/avscan", Weight: 3},
+		}
+
+		type testSample struct {
+			strategy Strategy
+			release  bool
+			wanted   []string // the hosts picked in order
+		}
+
+		sampleTable := []testSample{
+			{
+				strategy: RoundRobin,
+				release:  true,
+				wanted:   []string{"scanner-1", "scanner-2", "scanner-3", "scanner-1"},
+			},
+			{
+				strategy: Weighted,
+				release:  true,
+				wanted:   []string{"scanner-3", "scanner-2", "scanner-1", "scanner-3", "scanner-2", "scanner-3"},
+			},
+			{
+				strategy: LeastOutstanding,
+				release:  false,
+				wanted:   []string{"scanner-1", "scanner-2", "scanner-3", "scanner-1", "scanner-2"},
+			},
+		}
+
+		for _, sample := range sampleTable {
+			pool, err := NewServerPool(sample.strategy, servers...)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			picked := []string{}
+
+			for range sample.wanted {
+				srv, err := pool.pick(&Client{}, &Request{}, nil)
+				if err != nil {
+					t.Fatal(err.Error())
+				}
+				if sample.release {
+					pool.release(srv)
+				}
+				picked = append(picked, srv.url.Host)
+			}
+
+			if strings.Join(picked, ",") != strings.Join(sample.wanted, ",") {
+				t.Logf("Wanted the strategy %d to pick %v, got:%v", sample.strategy, sample.wanted, picked)
+				t.Fail()
+			}
+		}
+
+		pool, err := NewServerPool(ConsistentHash, servers...)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		hosts := map[string]bool{}
+
+		for _, file := range []string{"a.pdf", "b.pdf", "c.pdf", "d.pdf", "e.pdf", "f.pdf", "g.pdf", "h.pdf"} {
+			httpReq, _ := http.NewRequest(http.MethodGet, "http://someurl.com/"+file, nil)
+			req := &Request{Method: MethodREQMOD, HTTPRequest: httpReq}
+
+			first, _ := pool.pick(&Client{}, req, nil)
+			pool.release(first)
+
+			for i := 0; i < 5; i++ {
+				if srv, _ := pool.pick(&Client{}, req, nil); srv != first {
+					t.Logf("Wanted %s to always go to %s, got:%s", file, first.url.Host, srv.url.Host)
+					t.Fail()
+				} else {
+					pool.release(srv)
+				}
+			}
+
+			if srv, _ := pool.pick(&Client{}, req, map[*poolServer]bool{first: true}); srv == first {
+				t.Logf("Wanted %s to go to another server than %s once it is tried, got the same", file, first.url.Host)
+				t.Fail()
+			}
+
+			hosts[first.url.Host] = true
+		}
+
+		if len(hosts) < 2 {
+			t.Logf("Wanted the keys spread over the servers, got all of them on:%v", hosts)
+			t.Fail()
+		}
+	})
+
+	t.Run("Client Do with Servers", func(t *testing.T) {
+
+		var down int32 = 1
+
+		flaky := newFakeICAPServer(t, func(req *fakeICAPRequest) string {
+			if atomic.LoadInt32(&down) == 1 {
+				return ""
+			}
+			if req.method == MethodOPTIONS {
+				return optionsResponse("Methods: RESPMOD", "ISTag: TAG")
+			}
+			return noContentResponse("TAG")
+		})
+		defer flaky.close()
+
+		healthy := newFakeICAPServer(t, func(req *fakeICAPRequest) string {
+			return noContentResponse("TAG")
+		})
+		defer healthy.close()
+
+		pool, err := NewServerPool(RoundRobin, Server{URL: flaky.url("/avscan")}, Server{URL: healthy.url("/avscan")})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		pool.MaxFails = 1
+		pool.ProbeInterval = 20 * time.Millisecond
+
+		client := &Client{Servers: pool}
+		defer client.CloseIdleConnections()
+
+		do := func() (*Response, error) {
+			httpResp := &http.Response{
+				Status:     "200 OK",
+				StatusCode: http.StatusOK,
+				Proto:      "HTTP/1.1",
+				ProtoMajor: 1,
+				ProtoMinor: 1,
+				Header:     http.Header{},
+				Body:       ioutil.NopCloser(strings.NewReader("Hello World")),
+			}
+
+			req, err := NewRequest(MethodRESPMOD, "icap://localhost:1344/ignored", nil, httpResp)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			req.GetBody = func() (io.ReadCloser, error) {
+				return ioutil.NopCloser(bytes.NewReader([]byte("Hello World"))), nil
+			}
+
+			return client.Do(req)
+		}
+
+		resp, err := do() // the flaky server takes the first call, failing over to the healthy one
+		if err != nil || resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("Wanted the call to fail over with the status code:%d, got:%v", http.StatusNoContent, err)
+		}
+
+		if received := healthy.received(MethodRESPMOD); len(received) != 1 || string(received[0].body) != "Hello World" {
+			t.Logf("Wanted the healthy server to get the call with the entire body, got:%d calls", len(received))
+			t.Fail()
+		}
+
+		if urls := pool.Healthy(); len(urls) != 1 || urls[0] != healthy.url("/avscan") {
+			t.Logf("Wanted only %s healthy, got:%v", healthy.url("/avscan"), urls)
+			t.Fail()
+		}
+
+		atomic.StoreInt32(&down, 0)
+
+		deadline := time.Now().Add(2 * time.Second)
+
+		for len(pool.Healthy()) != 2 && time.Now().Before(deadline) { // the calls go on, the flaky server gets probed meanwhile
+			time.Sleep(10 * time.Millisecond)
+			if _, err := do(); err != nil {
+				t.Fatal(err.Error())
+			}
+		}
+
+		if len(pool.Healthy()) != 2 || len(flaky.received(MethodOPTIONS)) == 0 {
+			t.Logf("Wanted the flaky server healthy again after an OPTIONS probe, got:%v", pool.Healthy())
+			t.Fail()
+		}
+
+		atomic.StoreInt32(&down, 1)
+		healthy.close()
+		client.CloseIdleConnections() // the connections kept open would still be served
+
+		for i := 0; i < 3; i++ {
+			_, err = do()
+		}
+
+		if !errors.Is(err, ErrNoHealthyServer) {
+			t.Logf("Wanted error:%v once both of the servers are down, got:%v", ErrNoHealthyServer, err)
+			t.Fail()
+		}
+	})
+
+	t.Run("Client Do with a single server & no Retry", func(t *testing.T) {
+
+		down := newFakeICAPServer(t, func(req *fakeICAPRequest) string {
+			return "" // the connection is dropped
+		})
+		defer down.close()
+
+		pool, err := NewServerPool(RoundRobin, Server{URL: down.url("/avscan")})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		pool.MaxFails = 2
+		pool.ProbeInterval = time.Minute
+
+		client := &Client{Servers: pool}
+		defer client.CloseIdleConnections()
+
+		for i := 0; i < 3; i++ {
+			httpReq, _ := http.NewRequest(http.MethodGet, "http://someurl.com/upload", nil)
+
+			req, err := NewRequest(MethodREQMOD, "icap://localhost:1344/ignored", httpReq, nil)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			_, err = client.Do(req)
+
+			if wantedErr := i == 2; errors.Is(err, ErrNoHealthyServer) != wantedErr {
+				t.Logf("Wanted the call %d to get the error:%v:%v, got:%v", i, ErrNoHealthyServer, wantedErr, err)
+				t.Fail()
+			}
+		}
+
+		if urls := pool.Healthy(); len(urls) != 0 || len(down.received(MethodREQMOD)) != 2 {
+			t.Logf("Wanted the server unhealthy after %d failures, got:%v healthy after %d calls", 2, urls,
+				len(down.received(MethodREQMOD)))
+			t.Fail()
+		}
+	})
+
+	t.Run("Client Do with Servers failing the OPTIONS", func(t *testing.T) {
+
+		downs := []*fakeICAPServer{}
+		servers := []Server{}
+
+		for i := 0; i < 3; i++ {
+			down := newFakeICAPServer(t, func(req *fakeICAPRequest) string {
+				return "" // the connection is dropped
+			})
+			defer down.close()
+
+			downs = append(downs, down)
+			servers = append(servers, Server{URL: down.url("/avscan")})
+		}
+
+		pool, err := NewServerPool(RoundRobin, servers...)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		pool.MaxFails = 10
+		pool.ProbeInterval = time.Minute
+		pool.servers[2].healthy = false
+		pool.servers[2].nextProbe = time.Now().Add(time.Minute)
+
+		client := &Client{Servers: pool, NegotiateOptions: true}
+		defer client.CloseIdleConnections()
+
+		httpReq, _ := http.NewRequest(http.MethodGet, "http://someurl.com/upload", nil)
+
+		req, err := NewRequest(MethodREQMOD, "icap://localhost:1344/ignored", httpReq, nil)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if _, err := client.Do(req); err == nil || errors.Is(err, ErrNoHealthyServer) {
+			t.Logf("Wanted the error the servers failed with, got:%v", err)
+			t.Fail()
+		}
+
+		accepted := 0
+		for _, down := range downs {
+			accepted += down.accepted()
+		}
+
+		if accepted != 2 || len(pool.Healthy()) != 2 {
+			t.Logf("Wanted each of the %d healthy servers tried once, got:%d connections & %d healthy", 2, accepted,
+				len(pool.Healthy()))
+			t.Fail()
+		}
+	})
+
+	t.Run("Client Do negotiates the options with the server failed over to", func(t *testing.T) {
+
+		overloaded := newFakeICAPServer(t, func(req *fakeICAPRequest) string {
+			if req.method == MethodOPTIONS {
+				return optionsResponse("Methods: RESPMOD", "Preview: 4", "ISTag: TAG")
+			}
+			return "ICAP/1.0 503 Service Overloaded\r\nISTag: TAG\r\nEncapsulated: null-body=0\r\n\r\n"
+		})
+		defer overloaded.close()
+
+		healthy := newFakeICAPServer(t, func(req *fakeICAPRequest) string {
+			if req.method == MethodOPTIONS {
+				return optionsResponse("Methods: RESPMOD", "Allow: 204", "ISTag: TAG")
+			}
+			return noContentResponse("TAG")
+		})
+		defer healthy.close()
+
+		pool, err := NewServerPool(RoundRobin, Server{URL: overloaded.url("/avscan")}, Server{URL: healthy.url("/avscan")})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		client := &Client{Servers: pool, NegotiateOptions: true}
+		defer client.CloseIdleConnections()
+
+		httpResp := &http.Response{
+			Status:     "200 OK",
+			StatusCode: http.StatusOK,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(strings.NewReader("Hello World")),
+		}
+
+		req, err := NewRequest(MethodRESPMOD, "icap://localhost:1344/ignored", nil, httpResp)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		req.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(strings.NewReader("Hello World")), nil
+		}
+
+		resp, err := client.Do(req)
+		if err != nil || resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("Wanted the call to fail over with the status code:%d, got:%v", http.StatusNoContent, err)
+		}
+
+		received := healthy.received(MethodRESPMOD)
+
+		if len(received) != 1 || received[0].header.Get(PreviewHeader) != "" || received[0].header.Get(AllowHeader) != "204" ||
+			string(received[0].body) != "Hello World" {
+			t.Fatalf("Wanted the server failed over to get the entire body with no preview & Allow:%s, got:%d calls", "204",
+				len(received))
+		}
+	})
+}
@@ -142,6 +142,18 @@ func reqmodHandler(w icap.ResponseWriter, req *icap.Request) {
 	}
 }
 
+// waitForTestServer blocks until the test server started in the background is accepting connections
+func waitForTestServer() {
+	for i := 0; i < 100; i++ {
+		conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
+		if err == nil {
+			conn.Close()
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
 func testServerRunning() bool {
 	lstnr, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
@@ -0,0 +1,186 @@
+package icapclient
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// InfectionType is the type of a threat found, as X-Infection-Found tells
+type InfectionType int
+
+// the types of the threats found
+const (
+	InfectionVirus       InfectionType = 0 // a virus infection
+	InfectionMailPolicy  InfectionType = 1 // a mail policy violation
+	InfectionContainer   InfectionType = 2 // a container violation, for example: an archive too deep to be scanned
+	InfectionUnknownType InfectionType = -1
+)
+
+// Resolution is what the service did with the threat found
+type Resolution int
+
+// the resolutions of the threats found
+const (
+	ResolutionNotFixed Resolution = 0  // the file is left as it is
+	ResolutionRepaired Resolution = 1  // the file is repaired
+	ResolutionBlocked  Resolution = 2  // the file is blocked or deleted
+	ResolutionUnknown  Resolution = -1 // the service doesn't tell
+)
+
+// Infection is a threat found, as the X-Infection-Found header tells, for example: Type=0; Resolution=2; Threat=EICAR;
+type Infection struct {
+	Type       InfectionType
+	Resolution Resolution
+	Threat     string // the name of the threat
+}
+
+// Violation is a threat found in a file, as the X-Violations-Found header tells
+type Violation struct {
+	Filename   string     // the name of the file the threat is found in, the path in the archive for the files in archives
+	Threat     string     // the name or the description of the threat
+	ThreatID   string     // the id of the threat by the vendor
+	Resolution Resolution // what the service did with the file
+}
+
+// ParseInfectionFound parses the value of an X-Infection-Found header, the parameters are not case sensitive & the ones
+// missing are unknown, for example: Type=0; Resolution=2; Threat=EICAR Test String;
+func ParseInfectionFound(value string) (Infection, error) {
+
+	inf := Infection{Type: InfectionUnknownType, Resolution: ResolutionUnknown}
+
+	for _, param := range strings.Split(value, ";") {
+		if param = strings.TrimSpace(param); param == "" {
+			continue
+		}
+
+		kv := strings.SplitN(param, "=", 2)
+
+		if len(kv) != 2 {
+			return Infection{}, &ProtocolError{Line: value, Err: ErrInvalidThreatHeader}
+		}
+
+		key, val := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+
+		switch strings.ToLower(key) {
+		case "type":
+			n, err := strconv.Atoi(val)
+			if err != nil {
+				return Infection{}, &ProtocolError{Line: value, Err: ErrInvalidThreatHeader}
+			}
+			inf.Type = InfectionType(n)
+		case "resolution":
+			n, err := strconv.Atoi(val)
+			if err != nil {
+				return Infection{}, &ProtocolError{Line: value, Err: ErrInvalidThreatHeader}
+			}
+			inf.Resolution = Resolution(n)
+		case "threat":
+			inf.Threat = val
+		}
+	}
+
+	if inf.Threat == "" {
+		return Infection{}, &ProtocolError{Line: value, Err: ErrInvalidThreatHeader}
+	}
+
+	return inf, nil
+}
+
+// ParseViolationsFound parses the value of an X-Violations-Found header, the number of the violations on the first line
+// followed by the filename, the threat, the threat id & the resolution of each on a line of their own, for example:
+//
+//	X-Violations-Found: 1
+//		eicar.com
+//		EICAR Test String
+//		11101
+//		2
+//
+// The lines are to be kept apart by "\n" as Response.Violations does, the value with the lines joined by spaces can
+// only be parsed if none of the fields have a space
+func ParseViolationsFound(value string) ([]Violation, error) {
+
+	lines := []string{}
+
+	for _, line := range strings.Split(value, LF) {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	if len(lines) == 1 { // the lines are joined by spaces
+		lines = strings.Fields(lines[0])
+	}
+
+	if len(lines) == 0 {
+		return nil, &ProtocolError{Line: value, Err: ErrInvalidThreatHeader}
+	}
+
+	count, err := strconv.Atoi(lines[0])
+
+	if err != nil || (len(lines)-1)%violationLines != 0 || count != (len(lines)-1)/violationLines { // not multiplying the count the server tells, it might overflow
+		return nil, &ProtocolError{Line: value, Err: ErrInvalidThreatHeader}
+	}
+
+	violations := make([]Violation, 0, (len(lines)-1)/violationLines)
+
+	for i := 1; i < len(lines); i += violationLines {
+		resolution, err := strconv.Atoi(lines[i+3])
+
+		if err != nil {
+			return nil, &ProtocolError{Line: value, Err: ErrInvalidThreatHeader}
+		}
+
+		violations = append(violations, Violation{
+			Filename:   lines[i],
+			Threat:     lines[i+1],
+			ThreatID:   lines[i+2],
+			Resolution: Resolution(resolution),
+		})
+	}
+
+	return violations, nil
+}
+
+// Infections returns the threats found as the X-Infection-Found headers of the response tell
+func (r *Response) Infections() ([]Infection, error) {
+
+	infections := []Infection{}
+
+	for _, val := range r.Header[http.CanonicalHeaderKey(InfectionFoundHeader)] {
+		inf, err := ParseInfectionFound(val)
+
+		if err != nil {
+			return nil, err
+		}
+
+		infections = append(infections, inf)
+	}
+
+	return infections, nil
+}
+
+// Violations returns the threats found as the X-Violations-Found headers of the response tell
+func (r *Response) Violations() ([]Violation, error) {
+
+	key := http.CanonicalHeaderKey(ViolationsFoundHeader)
+
+	vals := r.folded[key] // the lines kept apart
+	if vals == nil {
+		vals = r.Header[key]
+	}
+
+	violations := []Violation{}
+
+	for _, val := range vals {
+		v, err := ParseViolationsFound(val)
+
+		if err != nil {
+			return nil, err
+		}
+
+		violations = append(violations, v...)
+	}
+
+	return violations, nil
+}
@@ -0,0 +1,163 @@
+package icapclient
+
+import (
+	"bufio"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestThreats(t *testing.T) {
+
+	t.Run("ParseInfectionFound", func(t *testing.T) {
+
+		type testSample struct {
+			value           string
+			wantedInfection Infection
+			wantedErr       error
+		}
+
+		sampleTable := []testSample{
+			{
+				value:           "Type=0; Resolution=2; Threat=EICAR;",
+				wantedInfection: Infection{Type: InfectionVirus, Resolution: ResolutionBlocked, Threat: "EICAR"},
+			},
+			{ // Symantec Protection Engine
+				value:           "Type=0; Resolution=2; Threat=EICAR Test String;",
+				wantedInfection: Infection{Type: InfectionVirus, Resolution: ResolutionBlocked, Threat: "EICAR Test String"},
+			},
+			{ // Sophos SAVDI
+				value:           "Type=0; Resolution=0; Threat=EICAR-AV-Test;",
+				wantedInfection: Infection{Type: InfectionVirus, Resolution: ResolutionNotFixed, Threat: "EICAR-AV-Test"},
+			},
+			{ // Trend Micro IWSVA, without the trailing semicolon
+				value:           "Type=0; Resolution=1; Threat=Eicar_test_file",
+				wantedInfection: Infection{Type: InfectionVirus, Resolution: ResolutionRepaired, Threat: "Eicar_test_file"},
+			},
+			{ // a container violation, with the parameters in another order & case
+				value:           "threat=Archive.ExceededDepth; type=2; resolution=2;",
+				wantedInfection: Infection{Type: InfectionContainer, Resolution: ResolutionBlocked, Threat: "Archive.ExceededDepth"},
+			},
+			{ // c-icap with ClamAV
+				value:           "Type=0; Resolution=2; Threat=Win.Test.EICAR_HDB-1;",
+				wantedInfection: Infection{Type: InfectionVirus, Resolution: ResolutionBlocked, Threat: "Win.Test.EICAR_HDB-1"},
+			},
+			{
+				value:           "Threat=Win.Test.EICAR_HDB-1;",
+				wantedInfection: Infection{Type: InfectionUnknownType, Resolution: ResolutionUnknown, Threat: "Win.Test.EICAR_HDB-1"},
+			},
+			{value: "Type=virus; Resolution=2; Threat=EICAR;", wantedErr: ErrInvalidThreatHeader},
+			{value: "Type=0; Resolution=2;", wantedErr: ErrInvalidThreatHeader},
+			{value: "EICAR", wantedErr: ErrInvalidThreatHeader},
+		}
+
+		for _, sample := range sampleTable {
+			inf, err := ParseInfectionFound(sample.value)
+
+			if !errors.Is(err, sample.wantedErr) || inf != sample.wantedInfection {
+				t.Logf("Wanted the infection:%+v & error:%v for %q, got:%+v & %v", sample.wantedInfection, sample.wantedErr,
+					sample.value, inf, err)
+				t.Fail()
+			}
+		}
+	})
+
+	t.Run("ParseViolationsFound", func(t *testing.T) {
+
+		type testSample struct {
+			value            string
+			wantedViolations []Violation
+			wantedErr        error
+		}
+
+		sampleTable := []testSample{
+			{ // Symantec Protection Engine
+				value: "1\neicar.com\nEICAR Test String\n11101\n2",
+				wantedViolations: []Violation{
+					{Filename: "eicar.com", Threat: "EICAR Test String", ThreatID: "11101", Resolution: ResolutionBlocked},
+				},
+			},
+			{ // the files in an archive
+				value: "2\neicar.zip/eicar.com\nEICAR Test String\n11101\n2\neicar.zip/report.doc\nW97M.Melissa.A\n1234\n0",
+				wantedViolations: []Violation{
+					{Filename: "eicar.zip/eicar.com", Threat: "EICAR Test String", ThreatID: "11101", Resolution: ResolutionBlocked},
+					{Filename: "eicar.zip/report.doc", Threat: "W97M.Melissa.A", ThreatID: "1234", Resolution: ResolutionNotFixed},
+				},
+			},
+			{ // the lines joined by spaces, as the folded header reads
+				value: "1 eicar.com EICAR-test-file 0 2",
+				wantedViolations: []Violation{
+					{Filename: "eicar.com", Threat: "EICAR-test-file", ThreatID: "0", Resolution: ResolutionBlocked},
+				},
+			},
+			{value: "0", wantedViolations: []Violation{}},
+			{value: "1 eicar.com EICAR Test String 11101 2", wantedErr: ErrInvalidThreatHeader},
+			{value: "2\neicar.com\nEICAR Test String\n11101\n2", wantedErr: ErrInvalidThreatHeader},
+			{value: "1\neicar.com\nEICAR Test String\n11101\nblocked", wantedErr: ErrInvalidThreatHeader},
+			{value: "", wantedErr: ErrInvalidThreatHeader},
+			{value: "-1", wantedErr: ErrInvalidThreatHeader},
+			{value: "4611686018427387905\na\nb\nc\n0", wantedErr: ErrInvalidThreatHeader}, // the count times the lines overflows
+		}
+
+		for _, sample := range sampleTable {
+			violations, err := ParseViolationsFound(sample.value)
+
+			if !errors.Is(err, sample.wantedErr) || (sample.wantedErr == nil && !reflect.DeepEqual(violations, sample.wantedViolations)) {
+				t.Logf("Wanted the violations:%+v & error:%v for %q, got:%+v & %v", sample.wantedViolations, sample.wantedErr,
+					sample.value, violations, err)
+				t.Fail()
+			}
+		}
+	})
+
+	t.Run("Response Infections & Violations", func(t *testing.T) {
+
+		respStr := "ICAP/1.0 200 OK\r\n" +
+			"ISTag: \"SPE-5.2.12\"\r\n" +
+			"X-Infection-Found: Type=0; Resolution=2; Threat=EICAR Test String;\r\n" +
+			"X-Violations-Found: 2\r\n" +
+			"\teicar.zip/eicar.com\r\n" +
+			"\tEICAR Test String\r\n" +
+			"\t11101\r\n" +
+			"\t2\r\n" +
+			"\teicar.zip/readme.txt\r\n" +
+			"\tContainer Violation\r\n" +
+			"\t0\r\n" +
+			"\t0\r\n" +
+			"Encapsulated: null-body=0\r\n\r\n"
+
+		resp, err := ReadResponse(bufio.NewReader(strings.NewReader(respStr)))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		infections, err := resp.Infections()
+
+		if wanted := []Infection{{Type: InfectionVirus, Resolution: ResolutionBlocked, Threat: "EICAR Test String"}}; err != nil ||
+			!reflect.DeepEqual(infections, wanted) {
+			t.Logf("Wanted the infections:%+v, got:%+v & %v", wanted, infections, err)
+			t.Fail()
+		}
+
+		violations, err := resp.Violations()
+
+		wantedViolations := []Violation{
+			{Filename: "eicar.zip/eicar.com", Threat: "EICAR Test String", ThreatID: "11101", Resolution: ResolutionBlocked},
+			{Filename: "eicar.zip/readme.txt", Threat: "Container Violation", ThreatID: "0", Resolution: ResolutionNotFixed},
+		}
+
+		if err != nil || !reflect.DeepEqual(violations, wantedViolations) {
+			t.Logf("Wanted the violations:%+v, got:%+v & %v", wantedViolations, violations, err)
+			t.Fail()
+		}
+
+		wantedHeader := "2 eicar.zip/eicar.com EICAR Test String 11101 2 eicar.zip/readme.txt Container Violation 0 0"
+
+		if val := resp.Header.Get(ViolationsFoundHeader); val != wantedHeader || resp.Header.Get(ISTagHeader) != `"SPE-5.2.12"` {
+			t.Logf("Wanted the header value with the lines joined by spaces:%q, got:%q", wantedHeader, val)
+			t.Fail()
+		}
+	})
+
+}
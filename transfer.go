@@ -0,0 +1,108 @@
+package icapclient
+
+import (
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// Transfer is what to send the ICAP service of a body, as the Transfer-Preview, Transfer-Ignore & Transfer-Complete
+// options of the service tell by the file extension
+type Transfer int
+
+// the transfers of a body
+const (
+	TransferPreview  Transfer = iota // a preview of the body, the rest of it if the service asks for it
+	TransferIgnore                   // nothing, the service is not to be called at all
+	TransferComplete                 // the entire body without a preview
+)
+
+// String returns the name of the transfer
+func (t Transfer) String() string {
+	switch t {
+	case TransferIgnore:
+		return "ignore"
+	case TransferComplete:
+		return "complete"
+	default:
+		return "preview"
+	}
+}
+
+// Transfer tells what to send the service of a file with the name, by its extension
+//
+// The lists are looked into for the extension first, then for the "*" standing for the rest of the extensions,
+// the preview is sent if none of them has either
+func (opts *ServiceOptions) Transfer(filename string) Transfer {
+
+	ext := strings.TrimPrefix(path.Ext(filename), ".")
+
+	lists := []struct {
+		transfer Transfer
+		exts     []string
+	}{
+		{TransferIgnore, opts.TransferIgnore},
+		{TransferComplete, opts.TransferComplete},
+		{TransferPreview, opts.TransferPreview},
+	}
+
+	for _, wildcard := range []bool{false, true} {
+		for _, list := range lists {
+			for _, e := range list.exts {
+				if (!wildcard && ext != "" && strings.EqualFold(e, ext)) || (wildcard && e == "*") {
+					return list.transfer
+				}
+			}
+		}
+	}
+
+	return TransferPreview
+}
+
+// filename returns the name of the file the encapsulated http message carries, from the Content-Disposition header
+// if there is one, from the url path otherwise
+func (r *Request) filename() string {
+
+	var hdr http.Header
+	var httpReq *http.Request
+
+	switch {
+	case r.Method == MethodRESPMOD && r.HTTPResponse != nil:
+		hdr = r.HTTPResponse.Header
+		httpReq = r.HTTPResponse.Request
+		if httpReq == nil {
+			httpReq = r.HTTPRequest
+		}
+	case r.Method == MethodREQMOD && r.HTTPRequest != nil:
+		hdr = r.HTTPRequest.Header
+		httpReq = r.HTTPRequest
+	}
+
+	if _, params, err := mime.ParseMediaType(hdr.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+		return path.Base(strings.Replace(params["filename"], `\`, "/", -1)) // some clients send the full windows path
+	}
+
+	if httpReq != nil && httpReq.URL != nil && httpReq.URL.Path != "" {
+		return path.Base(httpReq.URL.Path)
+	}
+
+	return ""
+}
+
+// notScannedResponse returns the response standing in for a call not made as the service asks to ignore the body
+func notScannedResponse(opts *ServiceOptions) *Response {
+
+	resp := &Response{
+		StatusCode: http.StatusNoContent,
+		Status:     http.StatusText(http.StatusNoContent),
+		Header:     http.Header{},
+		NotScanned: true,
+	}
+
+	if opts.ISTag != "" {
+		resp.Header.Set(ISTagHeader, opts.ISTag)
+	}
+
+	return resp
+}
@@ -0,0 +1,201 @@
+package icapclient
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestTransfer(t *testing.T) {
+
+	t.Run("ServiceOptions Transfer", func(t *testing.T) {
+
+		type testSample struct {
+			opts           *ServiceOptions
+			filename       string
+			wantedTransfer Transfer
+		}
+
+		opts := &ServiceOptions{
+			TransferPreview:  []string{"*"},
+			TransferIgnore:   []string{"html", "JPG"},
+			TransferComplete: []string{"asp", "bat", "exe", "com"},
+		}
+
+		sampleTable := []testSample{
+			{opts: opts, filename: "index.html", wantedTransfer: TransferIgnore},
+			{opts: opts, filename: "photo.jpg", wantedTransfer: TransferIgnore},
+			{opts: opts, filename: "setup.EXE", wantedTransfer: TransferComplete},
+			{opts: opts, filename: "report.pdf", wantedTransfer: TransferPreview},
+			{opts: opts, filename: "README", wantedTransfer: TransferPreview},
+			{opts: opts, filename: "", wantedTransfer: TransferPreview},
+			{
+				opts:           &ServiceOptions{TransferComplete: []string{"*"}, TransferPreview: []string{"pdf"}},
+				filename:       "archive.zip",
+				wantedTransfer: TransferComplete,
+			},
+			{
+				opts:           &ServiceOptions{TransferIgnore: []string{"*"}, TransferPreview: []string{"pdf"}},
+				filename:       "report.pdf",
+				wantedTransfer: TransferPreview,
+			},
+			{
+				opts:           &ServiceOptions{},
+				filename:       "setup.exe",
+				wantedTransfer: TransferPreview,
+			},
+		}
+
+		for _, sample := range sampleTable {
+			if transfer := sample.opts.Transfer(sample.filename); transfer != sample.wantedTransfer {
+				t.Logf("Wanted the transfer for %s: %s, got: %s", sample.filename, sample.wantedTransfer, transfer)
+				t.Fail()
+			}
+		}
+	})
+
+	t.Run("Request filename", func(t *testing.T) {
+
+		type testSample struct {
+			method             string
+			url                string
+			contentDisposition string
+			wantedFilename     string
+		}
+
+		sampleTable := []testSample{
+			{
+				method:         MethodRESPMOD,
+				url:            "http://someurl.com/files/report.pdf?version=2",
+				wantedFilename: "report.pdf",
+			},
+			{
+				method:             MethodRESPMOD,
+				url:                "http://someurl.com/download?id=12",
+				contentDisposition: `attachment; filename="setup.exe"`,
+				wantedFilename:     "setup.exe",
+			},
+			{
+				method:             MethodREQMOD,
+				url:                "http://someurl.com/upload.php",
+				contentDisposition: `attachment; filename="C:\Users\me\photo.jpg"`,
+				wantedFilename:     "photo.jpg",
+			},
+			{
+				method:             MethodREQMOD,
+				url:                "http://someurl.com/upload.php",
+				contentDisposition: `inline`,
+				wantedFilename:     "upload.php",
+			},
+		}
+
+		for _, sample := range sampleTable {
+			httpReq, _ := http.NewRequest(http.MethodGet, sample.url, nil)
+
+			var httpResp *http.Response
+			if sample.method == MethodRESPMOD {
+				httpResp = &http.Response{Header: http.Header{}, Request: httpReq}
+				httpResp.Header.Set("Content-Disposition", sample.contentDisposition)
+			} else {
+				httpReq.Header.Set("Content-Disposition", sample.contentDisposition)
+			}
+
+			req, err := NewRequest(sample.method, "icap://localhost:1344/scan", httpReq, httpResp)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			if filename := req.filename(); filename != sample.wantedFilename {
+				t.Logf("Wanted the filename: %s, got: %s", sample.wantedFilename, filename)
+				t.Fail()
+			}
+		}
+	})
+
+	t.Run("Client applies the transfer options", func(t *testing.T) {
+
+		srv := newFakeICAPServer(t, func(req *fakeICAPRequest) string {
+			if req.method == MethodOPTIONS {
+				return optionsResponse("Methods: RESPMOD", "Allow: 204", "Preview: 4", "ISTag: TAG",
+					"Transfer-Preview: *", "Transfer-Ignore: jpg, gif", "Transfer-Complete: exe")
+			}
+			return noContentResponse("TAG")
+		})
+		defer srv.close()
+
+		client := &Client{NegotiateOptions: true}
+		defer client.CloseIdleConnections()
+
+		type testSample struct {
+			url               string
+			wantedNotScanned  bool
+			wantedPreviewSent bool
+		}
+
+		sampleTable := []testSample{
+			{url: "http://someurl.com/photo.JPG", wantedNotScanned: true},
+			{url: "http://someurl.com/setup.exe", wantedPreviewSent: false},
+			{url: "http://someurl.com/report.pdf", wantedPreviewSent: true},
+		}
+
+		for _, sample := range sampleTable {
+			httpReq, _ := http.NewRequest(http.MethodGet, sample.url, nil)
+			httpResp := &http.Response{
+				Status:     "200 OK",
+				StatusCode: http.StatusOK,
+				Proto:      "HTTP/1.1",
+				ProtoMajor: 1,
+				ProtoMinor: 1,
+				Header:     http.Header{},
+				Body:       ioutil.NopCloser(strings.NewReader("Hello World")),
+				Request:    httpReq,
+			}
+
+			req, err := NewRequest(MethodRESPMOD, srv.url("/respmod"), httpReq, httpResp)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			calls := len(srv.received(MethodRESPMOD))
+
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			if resp.NotScanned != sample.wantedNotScanned || resp.StatusCode != http.StatusNoContent {
+				t.Logf("Wanted %s to be not scanned:%v with status code:%d, got:%v & %d", sample.url, sample.wantedNotScanned,
+					http.StatusNoContent, resp.NotScanned, resp.StatusCode)
+				t.Fail()
+			}
+
+			received := srv.received(MethodRESPMOD)
+
+			if sample.wantedNotScanned {
+				if len(received) != calls {
+					t.Logf("Wanted no call to the service for %s, got one", sample.url)
+					t.Fail()
+				}
+				continue
+			}
+
+			if len(received) != calls+1 {
+				t.Fatalf("Wanted a call to the service for %s, got none", sample.url)
+			}
+
+			last := received[len(received)-1]
+
+			if _, previewSent := last.header[PreviewHeader]; previewSent != sample.wantedPreviewSent {
+				t.Logf("Wanted the preview sent for %s:%v, got:%v", sample.url, sample.wantedPreviewSent, previewSent)
+				t.Fail()
+			}
+
+			if string(last.body) != "Hello World" {
+				t.Logf("Wanted the service to get the entire body:%s, got:%s", "Hello World", string(last.body))
+				t.Fail()
+			}
+		}
+	})
+
+}
@@ -1,10 +1,10 @@
 package icapclient
 
 import (
+	"bufio"
 	"context"
-	"io"
+	"crypto/tls"
 	"net"
-	"strings"
 	"time"
 )
 
@@ -16,108 +16,271 @@ type transport struct {
 	readTimeout  time.Duration
 	writeTimeout time.Duration
 	sckt         net.Conn
+	tlsConfig    *tls.Config
+	pool         *connPool
+	keepAlive    bool // determines if the connection can be given back to the pool after the call
+	br           *bufio.Reader
+	bodyOpen     bool // the body of the last response is still being read, the connection is closed along with it
+	closed       bool
+	ctx          context.Context // the context of the call, the reads & the writes are aborted once it is done
+	stopWatch    chan struct{}
+	watchDone    chan struct{}
 }
 
+// aLongTimeAgo is a deadline in the past, setting it with the connection aborts the reads & the writes in progress
+var aLongTimeAgo = time.Unix(1, 0)
+
 // dial fires up a tcp socket
 func (t *transport) dial() error {
-	sckt, err := net.DialTimeout(t.network, t.addr, t.timeout)
+	return t.dialWithContext(context.Background())
+}
 
-	if err != nil {
-		return err
+// dialWithContext fires up a tcp socket, or takes an idle one from the connection pool if there is any
+func (t *transport) dialWithContext(ctx context.Context) error {
+	t.ctx = ctx
+
+	if t.timeout > 0 { // the time spent waiting for a free connection slot & the TLS handshake count towards the dialer timeout as well
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.timeout)
+		defer cancel()
 	}
 
-	if err := sckt.SetReadDeadline(time.Now().UTC().Add(t.readTimeout)); err != nil {
-		return err
+	var sckt net.Conn
+	var err error
+
+	if t.pool != nil {
+		sckt, err = t.pool.get(ctx, t.poolKey(), t.connect)
+	} else {
+		sckt, err = t.connect(ctx)
 	}
 
-	if err := sckt.SetWriteDeadline(time.Now().UTC().Add(t.writeTimeout)); err != nil {
-		return err
+	if err != nil {
+		return t.opErr(PhaseDial, err)
 	}
 
 	t.sckt = sckt
+	t.keepAlive = t.pool != nil
+
+	t.watch()
 
 	return nil
 }
 
-// dialWithContext fires up a tcp socket
-func (t *transport) dialWithContext(ctx context.Context) error {
+// connect opens a new connection with the server, doing the TLS handshake for the ICAP over TLS connections
+func (t *transport) connect(ctx context.Context) (net.Conn, error) {
 	sckt, err := (&net.Dialer{
 		Timeout: t.timeout,
 	}).DialContext(ctx, t.network, t.addr)
 
-	if err != nil {
-		return err
+	if err != nil || t.tlsConfig == nil {
+		return sckt, err
 	}
 
-	if err := sckt.SetReadDeadline(time.Now().UTC().Add(t.readTimeout)); err != nil {
-		return err
-	}
+	tlsSckt := tls.Client(sckt, t.tlsConfig)
 
-	if err := sckt.SetWriteDeadline(time.Now().UTC().Add(t.writeTimeout)); err != nil {
-		return err
+	if err := tlsSckt.HandshakeContext(ctx); err != nil {
+		sckt.Close()
+		return nil, err
 	}
 
-	t.sckt = sckt
+	return tlsSckt, nil
+}
 
-	return nil
+// poolKey is the key of the connection in the connection pool, the plain & TLS connections to the same address are kept apart
+func (t *transport) poolKey() string {
+	if t.tlsConfig != nil {
+		return SchemeICAPS + "://" + t.addr
+	}
+
+	return SchemeICAP + "://" + t.addr
 }
 
 // Write writes data to the server
 func (t *transport) write(data []byte) (int, error) {
 	logDebug("Dumping the message being sent to the server...")
 	dumpDebug(string(data))
-	return t.sckt.Write(data)
+
+	if err := t.sckt.SetWriteDeadline(t.deadline(t.writeTimeout)); err != nil { // the timeout applies to every write, so that streaming a large body doesn't time out
+		t.keepAlive = false
+		return 0, err
+	}
+
+	if err := t.context().Err(); err != nil { // checking after setting the deadline, so that the one set on cancellation isn't overridden
+		t.keepAlive = false
+		return 0, t.opErr(PhaseWrite, err)
+	}
+
+	n, err := t.sckt.Write(data)
+
+	if err != nil {
+		t.keepAlive = false
+		err = t.opErr(PhaseWrite, err)
+	}
+
+	return n, err
 }
 
-// Read reads data from server
-func (t *transport) read() (string, error) {
+// Write lets the messages be streamed to the server as an io.Writer
+func (t *transport) Write(data []byte) (int, error) {
+	return t.write(data)
+}
+
+// Read lets the messages be read from the server as an io.Reader, the read timeout applies to every read
+func (t *transport) Read(data []byte) (int, error) {
+	if err := t.sckt.SetReadDeadline(t.deadline(t.readTimeout)); err != nil {
+		t.keepAlive = false
+		return 0, err
+	}
 
-	data := make([]byte, 0)
+	if err := t.context().Err(); err != nil {
+		t.keepAlive = false
+		return 0, t.opErr(PhaseRead, err)
+	}
 
-	logDebug("Dumping messages received from the server...")
+	n, err := t.sckt.Read(data)
 
-	for {
-		tmp := make([]byte, 1096)
+	if err != nil {
+		t.keepAlive = false // the connection is either closed by the server or broken
+		err = t.opErr(PhaseRead, err)
+	}
 
-		n, err := t.sckt.Read(tmp)
+	return n, err
+}
 
-		if err != nil {
-			if err == io.EOF {
-				logDebug("End of file detected from EOF error")
-				break
-			}
-			return "", err
-		}
+// context returns the context of the call
+func (t *transport) context() context.Context {
+	if t.ctx == nil {
+		return context.Background()
+	}
 
-		if n == 0 {
-			logDebug("End of file detected by 0 bytes")
-			break
-		}
+	return t.ctx
+}
 
-		data = append(data, tmp[:n]...)
-		if string(data) == icap100ContinueMsg { // explicitly breaking because the Read blocks for 100 continue message // TODO: find out why
-			logDebug("Stopping because got 100 Continue from the server")
-			break
-		}
+// deadline returns the deadline for a socket operation with the timeout, or the deadline of the context if it is sooner
+func (t *transport) deadline(timeout time.Duration) time.Time {
+	d := deadline(timeout)
 
-		if strings.HasSuffix(string(data), "0\r\n\r\n") {
-			logDebug("End of the file detected by 0 Double CRLF indicator")
-			break
-		}
+	if ctxDeadline, ok := t.context().Deadline(); ok && (d.IsZero() || ctxDeadline.Before(d)) {
+		return ctxDeadline
+	}
 
-		if strings.Contains(string(data), icap204NoModsMsg) {
-			logDebug("End of file detected by 204 no modifications and Double CRLF at the end")
-			break
+	return d
+}
+
+// opErr returns the error of a failed operation in the phase of the call, the error of the context if the context is why
+// it failed, a *TimeoutError if it timed out
+func (t *transport) opErr(phase string, err error) error {
+	ctx := t.context()
+
+	if ctxErr := ctx.Err(); ctxErr == context.Canceled {
+		return ctxErr
+	} else if ctxErr == context.DeadlineExceeded {
+		return &TimeoutError{Phase: phase, Err: ctxErr}
+	}
+
+	timeoutErr, ok := err.(interface{ Timeout() bool })
+
+	if !ok || !timeoutErr.Timeout() {
+		return err
+	}
+
+	if ctxDeadline, ok := ctx.Deadline(); ok && !time.Now().Before(ctxDeadline) { // the socket deadline might hit a moment before the context's
+		return &TimeoutError{Phase: phase, Err: context.DeadlineExceeded}
+	}
+
+	return &TimeoutError{Phase: phase, Err: err}
+}
+
+// watch aborts the reads & the writes in progress as soon as the context of the call is done
+func (t *transport) watch() {
+	done := t.context().Done()
+
+	if done == nil { // the context can never be done
+		return
+	}
+
+	t.stopWatch = make(chan struct{})
+	t.watchDone = make(chan struct{})
+
+	go func(sckt net.Conn, stop, watchDone chan struct{}) {
+		defer close(watchDone)
+
+		select {
+		case <-done:
+			sckt.SetDeadline(aLongTimeAgo)
+		case <-stop:
 		}
+	}(t.sckt, t.stopWatch, t.watchDone)
+}
+
+// unwatch stops watching the context, the connection can't be reused if the context is done meanwhile
+func (t *transport) unwatch() {
+	if t.stopWatch == nil {
+		return
+	}
 
-		dumpDebug(string(tmp))
+	close(t.stopWatch)
+	<-t.watchDone
+	t.stopWatch = nil
 
+	if t.context().Err() != nil {
+		t.keepAlive = false
 	}
+}
 
-	return string(data), nil
+// reader returns the buffered reader of the messages received from the server
+func (t *transport) reader() *bufio.Reader {
+	if t.br == nil {
+		t.br = bufio.NewReader(t)
+	}
+
+	return t.br
+}
+
+// bodyDone is called once the body of the response is read till the end or closed, the connection is closed along with the body
+func (t *transport) bodyDone(fullyRead bool) {
+	if !fullyRead {
+		t.keepAlive = false // the rest of the message is still there on the connection
+	}
+
+	t.bodyOpen = false
+
+	t.close()
 }
 
-// close closes the tcp connection
+// close closes the tcp connection, or gives it back to the connection pool if it can be reused
 func (t *transport) close() error {
-	return t.sckt.Close()
+	if t.closed {
+		return nil
+	}
+
+	t.closed = true
+
+	t.unwatch()
+
+	if t.br != nil && t.br.Buffered() > 0 { // something unexpected is received after the message
+		t.keepAlive = false
+	}
+
+	if t.pool == nil {
+		return t.sckt.Close()
+	}
+
+	if t.keepAlive {
+		t.pool.put(t.poolKey(), t.sckt)
+		return nil
+	}
+
+	t.pool.discard(t.poolKey(), t.sckt)
+
+	return nil
+}
+
+// deadline returns the deadline for a socket operation with the timeout, zero timeout means no deadline
+func deadline(timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+
+	return time.Now().UTC().Add(timeout)
 }
@@ -1,7 +1,6 @@
 package icapclient
 
 import (
-	"errors"
 	"net/http"
 	"net/url"
 )
@@ -9,7 +8,7 @@ import (
 // validMethod validates the ICAP method
 func validMethod(method string) (bool, error) {
 	if _, registered := registeredMethods[method]; !registered {
-		return false, errors.New(ErrMethodNotRegistered)
+		return false, &ValidationError{Field: "Method", Err: ErrMethodNotRegistered}
 	}
 
 	return true, nil
@@ -18,12 +17,12 @@ func validMethod(method string) (bool, error) {
 // validURL validates the Server URL provided
 func validURL(url *url.URL) (bool, error) {
 
-	if url.Scheme != SchemeICAP {
-		return false, errors.New(ErrInvalidScheme)
+	if url.Scheme != SchemeICAP && url.Scheme != SchemeICAPS {
+		return false, &ValidationError{Field: "URL", Err: ErrInvalidScheme}
 	}
 
 	if url.Host == "" {
-		return false, errors.New(ErrInvalidHost)
+		return false, &ValidationError{Field: "URL", Err: ErrInvalidHost}
 	}
 
 	return true, nil
@@ -32,13 +31,13 @@ func validURL(url *url.URL) (bool, error) {
 // validMethodWithHTTP validates if the ICAP request method and the http messages are alligned or not
 func validMethodWithHTTP(httpReq *http.Request, httpResp *http.Response, method string) (bool, error) {
 	if method == MethodREQMOD && httpReq == nil {
-		return false, errors.New(ErrREQMODWithNoReq)
+		return false, &ValidationError{Field: "HTTPRequest", Err: ErrREQMODWithNoReq}
 	}
 	if method == MethodREQMOD && httpResp != nil {
-		return false, errors.New(ErrREQMODWithResp)
+		return false, &ValidationError{Field: "HTTPResponse", Err: ErrREQMODWithResp}
 	}
 	if method == MethodRESPMOD && httpResp == nil {
-		return false, errors.New(ErrRESPMODWithNoResp)
+		return false, &ValidationError{Field: "HTTPResponse", Err: ErrRESPMODWithNoResp}
 	}
 
 	return true, nil
@@ -0,0 +1,258 @@
+package icapclient
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"net/textproto"
+	"reflect"
+	"strings"
+)
+
+// VerdictStatus tells what the scan of the content came up with
+type VerdictStatus int
+
+// the statuses of a verdict
+const (
+	VerdictClean      VerdictStatus = iota // nothing is found, the content is unmodified
+	VerdictInfected                        // a threat is found in the content
+	VerdictModified                        // the content is modified by the service, for example: sanitized
+	VerdictBlocked                         // the service blocks the content, for example: by a policy
+	VerdictError                           // the scan failed
+	VerdictNotScanned                      // the content is allowed without a scan, as the service asks to ignore it or the call failed open
+)
+
+// String returns the name of the status
+func (s VerdictStatus) String() string {
+	switch s {
+	case VerdictInfected:
+		return "infected"
+	case VerdictModified:
+		return "modified"
+	case VerdictBlocked:
+		return "blocked"
+	case VerdictError:
+		return "error"
+	case VerdictNotScanned:
+		return "not scanned"
+	default:
+		return "clean"
+	}
+}
+
+// Verdict is the outcome of the scan of the content, as classified out of the ICAP response
+type Verdict struct {
+	Status     VerdictStatus
+	Threats    []string         // the names of the threats found
+	Reason     string           // why the content is blocked or the scan failed, as the server tells if it does
+	StatusCode int              // the ICAP status code of the response, zero if there is none
+	Breaker    *BreakerDecision // the decision of the circuit breaker if the content is not scanned as the call failed open
+	Err        error            // the error of the call if the scan failed
+}
+
+// String returns the status of the verdict along with the threats found & the reason if there are any,
+// for example: infected: EICAR-Test-File
+func (v *Verdict) String() string {
+
+	s := v.Status.String()
+
+	if len(v.Threats) > 0 {
+		s += ": " + strings.Join(v.Threats, ", ")
+	}
+
+	if v.Reason != "" {
+		s += " (" + v.Reason + ")"
+	}
+
+	return s
+}
+
+// Allowed tells if the content can be let through, the clean, modified & not scanned content can
+func (v *Verdict) Allowed() bool {
+	return v.Status == VerdictClean || v.Status == VerdictModified || v.Status == VerdictNotScanned
+}
+
+// Rule adjusts the verdict of the response, for example: by the headers of a vendor the built-in rules don't know of
+type Rule func(resp *Response, v *Verdict)
+
+// Classifier makes the verdict out of the ICAP response with the built-in rules & then its own in order
+//
+// The built-in rules tell:
+//   - 204 No Content is clean
+//   - the threats named in X-Infection-Found, X-Virus-ID or X-Violations-Found make the content infected
+//   - X-Blocked-Reason, an encapsulated http response of 403 Forbidden, or an http response in place of the http request
+//     for REQMOD make the content blocked
+//   - 200 OK with the http message sent back unchanged is clean, the status line or the request line & the header
+//     fields are compared with the ones sent, in whatever order. The body is not, it is streamed to the caller
+//   - 200 OK otherwise is modified, setting the Allow: 204 header(the default) lets the services respond with
+//     204 No Content for the unmodified content instead of sending it back
+//   - the other statuses & the errors of the call are errors
+//
+// Only the responses of the calls made by a Client are known to be sent back unchanged, the 200 OK of the responses
+// read otherwise, as by ReadResponse, are modified
+type Classifier struct {
+	Rules []Rule
+}
+
+// DefaultClassifier is the classifier of Response.Verdict, with the built-in rules only
+var DefaultClassifier = NewClassifier()
+
+// NewClassifier is the factory function for Classifier
+func NewClassifier(rules ...Rule) *Classifier {
+	return &Classifier{
+		Rules: rules,
+	}
+}
+
+// Verdict returns the verdict of the response with the DefaultClassifier
+func (r *Response) Verdict() *Verdict {
+	return DefaultClassifier.Classify(r, nil)
+}
+
+// Classify returns the verdict of the result of a call, the response or the error of it
+func (c *Classifier) Classify(resp *Response, err error) *Verdict {
+
+	v := classify(resp, err)
+
+	if resp != nil {
+		for _, rule := range c.Rules {
+			rule(resp, v)
+		}
+	}
+
+	return v
+}
+
+// classify returns the verdict of the result of a call with the built-in rules
+func classify(resp *Response, err error) *Verdict {
+
+	if err != nil || resp == nil {
+		return &Verdict{Status: VerdictError, Err: err, Reason: errorReason(err)}
+	}
+
+	v := &Verdict{StatusCode: resp.StatusCode, Breaker: resp.Breaker}
+
+	if resp.NotScanned {
+		v.Status = VerdictNotScanned
+		if resp.Breaker != nil {
+			v.Reason = resp.Breaker.Err.Error()
+		}
+		return v
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNoContent:
+		v.Status = VerdictClean
+	case resp.StatusCode == http.StatusOK:
+		v.Threats = threatNames(resp)
+		v.Reason = resp.Header.Get(BlockedReasonHeader)
+
+		switch {
+		case len(v.Threats) > 0:
+			v.Status = VerdictInfected
+		case v.Reason != "" || blockedResponse(resp):
+			v.Status = VerdictBlocked
+		case unchanged(resp):
+			v.Status = VerdictClean
+		default:
+			v.Status = VerdictModified
+		}
+	default:
+		v.Status = VerdictError
+		v.Reason = resp.Status
+	}
+
+	return v
+}
+
+// blockedResponse tells if the encapsulated http message of the response is the one of a blocked content
+func blockedResponse(resp *Response) bool {
+
+	if resp.ContentResponse == nil {
+		return false
+	}
+
+	return resp.ContentResponse.StatusCode == http.StatusForbidden || resp.method == MethodREQMOD // the request is answered instead of being let through
+}
+
+// unchanged tells if the service sends back the encapsulated http message of the call as it is, by its headers
+func unchanged(resp *Response) bool {
+
+	got := resp.respHdr
+	if resp.method == MethodREQMOD {
+		got = resp.reqHdr
+	}
+
+	if resp.sentHTTPHeader == nil || got == nil {
+		return false
+	}
+
+	sentLine, sentHdr, err := readHTTPHeader(resp.sentHTTPHeader)
+	if err != nil {
+		return false
+	}
+
+	gotLine, gotHdr, err := readHTTPHeader(got)
+	if err != nil {
+		return false
+	}
+
+	return sentLine == gotLine && reflect.DeepEqual(sentHdr, gotHdr)
+}
+
+// readHTTPHeader reads the start line & the header fields of the wire representation of http headers
+func readHTTPHeader(b []byte) (string, textproto.MIMEHeader, error) {
+
+	tp := textproto.NewReader(bufio.NewReader(bytes.NewReader(b)))
+
+	line, err := tp.ReadLine()
+	if err != nil {
+		return "", nil, err
+	}
+
+	hdr, err := tp.ReadMIMEHeader()
+
+	return strings.TrimSpace(line), hdr, err
+}
+
+// errorReason returns the description of the error, empty if there is none
+func errorReason(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	return err.Error()
+}
+
+// threatNames returns the names of the threats the headers of the response tell of, without the repeats,
+// the malformed headers are skipped
+func threatNames(resp *Response) []string {
+
+	names := []string{}
+	seen := map[string]bool{}
+
+	add := func(name string) {
+		if name = strings.TrimSpace(name); name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	for _, val := range resp.Header[http.CanonicalHeaderKey(InfectionFoundHeader)] {
+		if inf, err := ParseInfectionFound(val); err == nil {
+			add(inf.Threat)
+		}
+	}
+
+	for _, val := range resp.Header[http.CanonicalHeaderKey(VirusIDHeader)] {
+		add(val)
+	}
+
+	if violations, err := resp.Violations(); err == nil {
+		for _, v := range violations {
+			add(v.Threat)
+		}
+	}
+
+	return names
+}
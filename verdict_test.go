@@ -0,0 +1,225 @@
+package icapclient
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestVerdict(t *testing.T) {
+
+	t.Run("Response Verdict", func(t *testing.T) {
+
+		type testSample struct {
+			respStr       string
+			method        string
+			sent          string // the encapsulated http headers sent for the call
+			wantedStatus  VerdictStatus
+			wantedThreats []string
+			wantedReason  string
+		}
+
+		sampleTable := []testSample{
+			{
+				respStr:      "ICAP/1.0 204 No Modifications\r\nISTag: TAG\r\nEncapsulated: null-body=0\r\n\r\n",
+				wantedStatus: VerdictClean,
+			},
+			{
+				respStr: "ICAP/1.0 200 OK\r\nISTag: TAG\r\nX-Infection-Found: Type=0; Resolution=2; Threat=EICAR-Test-File;\r\n" +
+					"Encapsulated: res-hdr=0, null-body=26\r\n\r\nHTTP/1.1 403 Forbidden\r\n\r\n",
+				method:        MethodRESPMOD,
+				wantedStatus:  VerdictInfected,
+				wantedThreats: []string{"EICAR-Test-File"},
+			},
+			{
+				respStr: "ICAP/1.0 200 OK\r\nX-Virus-ID: Eicar-Test-Signature\r\n" +
+					"Encapsulated: res-hdr=0, null-body=26\r\n\r\nHTTP/1.1 403 Forbidden\r\n\r\n",
+				method:        MethodRESPMOD,
+				wantedStatus:  VerdictInfected,
+				wantedThreats: []string{"Eicar-Test-Signature"},
+			},
+			{
+				respStr: "ICAP/1.0 200 OK\r\nX-Violations-Found: 1\r\n\teicar.com\r\n\tEICAR test file\r\n\t111\r\n\t0\r\n" +
+					"Encapsulated: res-hdr=0, null-body=26\r\n\r\nHTTP/1.1 403 Forbidden\r\n\r\n",
+				method:        MethodRESPMOD,
+				wantedStatus:  VerdictInfected,
+				wantedThreats: []string{"EICAR test file"},
+			},
+			{
+				respStr: "ICAP/1.0 200 OK\r\nX-Blocked-Reason: Category: Gambling\r\n" +
+					"Encapsulated: res-hdr=0, null-body=19\r\n\r\nHTTP/1.1 200 OK\r\n\r\n",
+				method:       MethodRESPMOD,
+				wantedStatus: VerdictBlocked,
+				wantedReason: "Category: Gambling",
+			},
+			{
+				respStr:      "ICAP/1.0 200 OK\r\nEncapsulated: res-hdr=0, null-body=26\r\n\r\nHTTP/1.1 403 Forbidden\r\n\r\n",
+				method:       MethodRESPMOD,
+				wantedStatus: VerdictBlocked,
+			},
+			{
+				respStr:      "ICAP/1.0 200 OK\r\nEncapsulated: res-hdr=0, null-body=19\r\n\r\nHTTP/1.1 200 OK\r\n\r\n",
+				method:       MethodREQMOD,
+				wantedStatus: VerdictBlocked,
+			},
+			{
+				respStr: "ICAP/1.0 200 OK\r\nEncapsulated: req-hdr=0, null-body=39\r\n\r\n" +
+					"GET /index.html HTTP/1.1\r\nHost: foo\r\n\r\n",
+				method:       MethodREQMOD,
+				wantedStatus: VerdictModified,
+			},
+			{
+				respStr:      "ICAP/1.0 200 OK\r\nEncapsulated: res-hdr=0, null-body=19\r\n\r\nHTTP/1.1 200 OK\r\n\r\n",
+				method:       MethodRESPMOD,
+				wantedStatus: VerdictModified,
+			},
+			{
+				respStr: "ICAP/1.0 200 OK\r\nEncapsulated: res-hdr=0, res-body=64\r\n\r\n" +
+					"HTTP/1.1 200 OK\r\nContent-Length: 5\r\nContent-Type: text/plain\r\n\r\n5\r\nHello\r\n0\r\n\r\n",
+				method:       MethodRESPMOD,
+				sent:         "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 5\r\n\r\n",
+				wantedStatus: VerdictClean,
+			},
+			{
+				respStr: "ICAP/1.0 200 OK\r\nEncapsulated: res-hdr=0, res-body=64\r\n\r\n" +
+					"HTTP/1.1 200 OK\r\nContent-Length: 5\r\nContent-Type: text/plain\r\n\r\n5\r\nHello\r\n0\r\n\r\n",
+				method:       MethodRESPMOD,
+				sent:         "HTTP/1.1 200 OK\r\nContent-Type: text/html\r\nContent-Length: 5\r\n\r\n",
+				wantedStatus: VerdictModified,
+			},
+			{
+				respStr: "ICAP/1.0 200 OK\r\nEncapsulated: req-hdr=0, null-body=39\r\n\r\n" +
+					"GET /index.html HTTP/1.1\r\nHost: foo\r\n\r\n",
+				method:       MethodREQMOD,
+				sent:         "GET /index.html HTTP/1.1\r\nHost: foo\r\n\r\n",
+				wantedStatus: VerdictClean,
+			},
+			{
+				respStr: "ICAP/1.0 200 OK\r\nEncapsulated: req-hdr=0, null-body=39\r\n\r\n" +
+					"GET /index.html HTTP/1.1\r\nHost: foo\r\n\r\n",
+				method:       MethodREQMOD,
+				sent:         "GET /admin.html HTTP/1.1\r\nHost: foo\r\n\r\n",
+				wantedStatus: VerdictModified,
+			},
+			{
+				respStr:      "ICAP/1.0 503 Service Overloaded\r\nEncapsulated: null-body=0\r\n\r\n",
+				wantedStatus: VerdictError,
+				wantedReason: "Service Overloaded",
+			},
+		}
+
+		for _, sample := range sampleTable {
+			resp, err := ReadResponse(bufio.NewReader(strings.NewReader(sample.respStr)))
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			resp.method = sample.method
+			if sample.sent != "" {
+				resp.sentHTTPHeader = []byte(sample.sent)
+			}
+
+			v := resp.Verdict()
+
+			if v.Status != sample.wantedStatus || strings.Join(v.Threats, ",") != strings.Join(sample.wantedThreats, ",") ||
+				v.Reason != sample.wantedReason || v.StatusCode != resp.StatusCode {
+				t.Logf("Wanted the verdict:%s with the threats:%v & the reason:%q, got:%s, %v & %q", sample.wantedStatus,
+					sample.wantedThreats, sample.wantedReason, v.Status, v.Threats, v.Reason)
+				t.Fail()
+			}
+		}
+	})
+
+	t.Run("Client Do with the message sent back unchanged", func(t *testing.T) {
+
+		srv := newFakeICAPServer(t, func(req *fakeICAPRequest) string { // echoing the request back
+			return fmt.Sprintf("ICAP/1.0 200 OK\r\nISTag: TAG\r\nEncapsulated: req-hdr=0, null-body=%d\r\n\r\n%s",
+				len(req.httpHdr), req.httpHdr)
+		})
+		defer srv.close()
+
+		client := &Client{}
+		defer client.CloseIdleConnections()
+
+		httpReq, _ := http.NewRequest(http.MethodGet, "http://someurl.com/index.html", nil)
+		httpReq.Header.Set("X-Custom", "value")
+
+		req, err := NewRequest(MethodREQMOD, srv.url("/reqmod"), httpReq, nil)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if v := resp.Verdict(); v.Status != VerdictClean {
+			t.Logf("Wanted the verdict:%s for the request sent back unchanged, got:%s", VerdictClean, v.Status)
+			t.Fail()
+		}
+	})
+
+	t.Run("Classifier Classify", func(t *testing.T) {
+
+		callErr := &TimeoutError{Phase: PhaseRead, Err: errors.New("i/o timeout")}
+
+		failedOpen := &Response{
+			StatusCode: http.StatusNoContent,
+			NotScanned: true,
+			Breaker:    &BreakerDecision{Service: "icap://localhost/respmod", Policy: FailOpen, Err: ErrCircuitOpen},
+		}
+
+		vendorRule := func(resp *Response, v *Verdict) { // a vendor telling the threat with its own header
+			if name := resp.Header.Get("X-Vendor-Threat"); name != "" {
+				v.Status = VerdictInfected
+				v.Threats = append(v.Threats, name)
+			}
+		}
+
+		type testSample struct {
+			classifier    *Classifier
+			resp          *Response
+			err           error
+			wantedStatus  VerdictStatus
+			wantedThreats []string
+			wantedAllowed bool
+		}
+
+		sampleTable := []testSample{
+			{classifier: DefaultClassifier, err: callErr, wantedStatus: VerdictError},
+			{classifier: DefaultClassifier, resp: failedOpen, wantedStatus: VerdictNotScanned, wantedAllowed: true},
+			{
+				classifier:    NewClassifier(vendorRule),
+				resp:          &Response{StatusCode: http.StatusOK, Header: http.Header{"X-Vendor-Threat": {"Trojan.Foo"}}},
+				wantedStatus:  VerdictInfected,
+				wantedThreats: []string{"Trojan.Foo"},
+			},
+			{
+				classifier:    NewClassifier(vendorRule),
+				resp:          &Response{StatusCode: http.StatusNoContent, Header: http.Header{}},
+				wantedStatus:  VerdictClean,
+				wantedAllowed: true,
+			},
+		}
+
+		for _, sample := range sampleTable {
+			v := sample.classifier.Classify(sample.resp, sample.err)
+
+			if v.Status != sample.wantedStatus || strings.Join(v.Threats, ",") != strings.Join(sample.wantedThreats, ",") ||
+				v.Allowed() != sample.wantedAllowed || v.Err != sample.err {
+				t.Logf("Wanted the verdict:%s with the threats:%v & allowed:%v, got:%s, %v & %v", sample.wantedStatus,
+					sample.wantedThreats, sample.wantedAllowed, v.Status, v.Threats, v.Allowed())
+				t.Fail()
+			}
+		}
+
+		if v := DefaultClassifier.Classify(failedOpen, nil); v.Breaker != failedOpen.Breaker || v.Reason != ErrCircuitOpen.Error() {
+			t.Logf("Wanted the decision of the breaker recorded with the verdict, got:%+v", v)
+			t.Fail()
+		}
+	})
+
+}